@@ -0,0 +1,88 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"io"
+	"sync"
+)
+
+// States of the escape sequence parser of stripWriter.
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+)
+
+// stripWriter removes ANSI escape sequences from what is written to it.
+type stripWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	state int
+	buf   []byte
+}
+
+// StripANSIWriter returns a writer that removes ANSI escape sequences, like
+// the color codes of a terminal, before passing the rest on to w. It lets a
+// file device get the same lines as a colorized terminal without the codes.
+// A sequence split across writes is still removed.
+//
+//	log.Dev.All(io.MultiWriter(os.Stdout, log.StripANSIWriter(f)))
+func StripANSIWriter(w io.Writer) io.Writer {
+	return &stripWriter{w: w}
+}
+
+// Write implements the io.Writer interface.
+func (sw *stripWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.buf = sw.buf[:0]
+	for _, c := range p {
+		switch sw.state {
+		case ansiText:
+			if c == 0x1b {
+				sw.state = ansiEscape
+				continue
+			}
+			sw.buf = append(sw.buf, c)
+
+		case ansiEscape:
+			// ESC [ starts a control sequence, anything else is a two
+			// byte sequence.
+			if c == '[' {
+				sw.state = ansiCSI
+				continue
+			}
+			sw.state = ansiText
+
+		case ansiCSI:
+			// Parameter and intermediate bytes run up to the final byte.
+			if c >= 0x40 && c <= 0x7e {
+				sw.state = ansiText
+			}
+		}
+	}
+
+	if len(sw.buf) > 0 {
+		if _, err := sw.w.Write(sw.buf); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
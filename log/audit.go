@@ -0,0 +1,224 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// auditQueueSize is the number of audit records that can wait to be
+// written before the calls that write them block.
+const auditQueueSize = 1 << 16
+
+// auditRecord is an audit record waiting to be written.
+type auditRecord struct {
+	w io.Writer
+	b []byte
+}
+
+// auditQueue holds the audit records waiting to be written. It is kept
+// apart from the write channel so audit records are never dropped when
+// the trace lines are.
+type auditQueue struct {
+	once    sync.Once
+	mu      sync.Mutex
+	cond    *sync.Cond
+	records []auditRecord
+	writing bool
+}
+
+// audits is the queue of audit records.
+var audits auditQueue
+
+// startAudit starts the goroutine that writes the audit records the first
+// time it is called and returns the queue.
+func startAudit() *auditQueue {
+	q := &audits
+
+	q.once.Do(func() {
+		q.cond = sync.NewCond(&q.mu)
+		go writeAudit()
+	})
+
+	return q
+}
+
+// queueAudit queues the record to be written to w. It blocks while the
+// queue is full.
+func queueAudit(w io.Writer, b []byte) {
+	q := startAudit()
+
+	q.mu.Lock()
+	{
+		for len(q.records) >= auditQueueSize {
+			q.cond.Wait()
+		}
+		q.records = append(q.records, auditRecord{w, b})
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+
+	if atomic.LoadInt32(&l.synchronous) == 1 {
+		waitAudit()
+	}
+}
+
+// writeAudit is run as a goroutine. It writes the queued audit records
+// in order, each with its own write.
+func writeAudit() {
+	q := &audits
+
+	q.mu.Lock()
+	for {
+		for len(q.records) == 0 {
+			q.cond.Wait()
+		}
+
+		records := q.records
+		q.records = nil
+		q.writing = true
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		// The records share the lock of their writer with the trace
+		// lines, so a device never sees concurrent writes.
+		for _, r := range records {
+			if err := writeTo(r.w, r.b); err != nil {
+				fmt.Fprintf(os.Stderr, "writeAudit ERROR: %s\n", err)
+				writeFailed(r.w, err)
+			}
+		}
+
+		q.mu.Lock()
+		q.writing = false
+		q.cond.Broadcast()
+	}
+}
+
+// waitAudit waits until every queued audit record has been written.
+func waitAudit() {
+	q := startAudit()
+
+	q.mu.Lock()
+	for len(q.records) > 0 || q.writing {
+		q.cond.Wait()
+	}
+	q.mu.Unlock()
+}
+
+// Audit is used to write an audit record to the DevAudit device.
+func Audit(actor, action, resource, outcome string, extra ...SplunkPair) {
+	Up1.Audit(actor, action, resource, outcome, extra...)
+}
+
+// AuditFields is used to write an audit record with the specified fields
+// to the DevAudit device.
+func AuditFields(fields map[string]interface{}) {
+	Up1.AuditFields(fields)
+}
+
+// Audit is used to write an audit record to the DevAudit device. The
+// actor, action, resource and outcome are required and are written first
+// as key=value pairs, followed by the extra pairs. If a required field is
+// empty nothing is written to DevAudit and a warning is logged instead.
+//
+// Audit records are never dropped. They don't go through the buffered
+// channel the trace lines use, which drops lines when the writer falls
+// behind, but through a queue of their own that is written in order by
+// a separate goroutine. The queue holds 65536 records. Once it is full
+// the calls that write audit records block until there is room, so a
+// device that stops accepting writes will in time stop the goroutines
+// that audit. Flush and Shutdown wait for the queued records.
+//
+//	2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: actor=bob action=delete resource=user/7 outcome=success
+func (lvl Uplevel) Audit(actor, action, resource, outcome string, extra ...SplunkPair) {
+	required := []SplunkPair{
+		{Key: "actor", Value: actor},
+		{Key: "action", Value: action},
+		{Key: "resource", Value: resource},
+		{Key: "outcome", Value: outcome},
+	}
+
+	for _, p := range required {
+		if p.Value == "" {
+			uplevel{lvl: lvl + 1}.Warnf("AUDIT", "", "audit record dropped: missing %s", p.Key)
+			return
+		}
+	}
+
+	w := Dev.get(DevAudit)
+	if w == nil {
+		return
+	}
+
+	dt, file, _, pid := dtFile(2+int(lvl), "-")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s: %s[%d]: %s: AUDIT:", dt, appPrefix(), pid, file)
+
+	// Records are written whole, SetMaxFields doesn't apply to them.
+	for _, p := range required {
+		writeSplunkPair(&buf, p)
+	}
+	for _, p := range extra {
+		writeSplunkPair(&buf, p)
+	}
+
+	buf.WriteByte('\n')
+
+	countTag(DevAudit)
+	queueAudit(w, buf.Bytes())
+}
+
+// AuditFields is used to write an audit record with the specified fields
+// to the DevAudit device. The fields are written as key=value pairs in key
+// order, all of them, as SetMaxFields doesn't apply to audit records. The
+// record is delivered the same way as the ones written by Audit, it is
+// never dropped.
+//
+//	2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: action=delete actor=bob
+func (lvl Uplevel) AuditFields(fields map[string]interface{}) {
+	w := Dev.get(DevAudit)
+	if w == nil {
+		return
+	}
+
+	dt, file, _, pid := dtFile(2+int(lvl), "-")
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s: %s[%d]: %s: AUDIT:", dt, appPrefix(), pid, file)
+
+	for _, k := range keys {
+		writeSplunkPair(&buf, SplunkPair{Key: k, Value: fields[k]})
+	}
+	buf.WriteByte('\n')
+
+	countTag(DevAudit)
+	queueAudit(w, buf.Bytes())
+}
@@ -0,0 +1,75 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// blobDir holds the directory TraceWithBlob writes blobs into.
+var blobDir atomic.Value
+
+// SetBlobDir sets the directory TraceWithBlob writes blobs into. Passing
+// an empty string turns off writing blobs.
+func SetBlobDir(dir string) {
+	blobDir.Store(dir)
+}
+
+// writeBlob writes the blob into the blob directory and returns its
+// reference. The reference is taken from the SHA-256 of the blob so the
+// same blob is only stored once.
+func writeBlob(blob []byte) (string, error) {
+	dir, _ := blobDir.Load().(string)
+	if dir == "" {
+		return "", errors.New("no blob directory is set")
+	}
+
+	sum := sha256.Sum256(blob)
+	ref := hex.EncodeToString(sum[:8])
+
+	name := filepath.Join(dir, ref+".bin")
+	if _, err := os.Stat(name); err == nil {
+		return ref, nil
+	}
+
+	// Write to a temporary file first so a reader never sees part of a blob.
+	f, err := ioutil.TempFile(dir, ref+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(blob); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,72 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// callbackWriter calls a function for each line written to it.
+type callbackWriter struct {
+	mu      sync.Mutex
+	fn      func(line string)
+	partial []byte
+}
+
+// CallbackWriter returns a device writer that calls fn with each line
+// written to it, without the trailing newline. A write with many lines
+// calls fn once per line and the start of a line that doesn't end in a
+// newline is kept until the rest of it arrives. It can be used to pass
+// the lines on to another logging package.
+//
+//	log.Dev.All(log.CallbackWriter(func(line string) {
+//		slog.Info(line)
+//	}))
+//
+// The indented lines of a DATA block are passed to fn as lines of their
+// own. The calls to fn are never made at the same time.
+func CallbackWriter(fn func(line string)) io.Writer {
+	return &callbackWriter{fn: fn}
+}
+
+// Write implements the io.Writer interface.
+func (cw *callbackWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	rest := p
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+
+		ln := rest[:i]
+		if len(cw.partial) > 0 {
+			ln = append(cw.partial, ln...)
+			cw.partial = cw.partial[:0]
+		}
+		cw.fn(string(ln))
+
+		rest = rest[i+1:]
+	}
+	cw.partial = append(cw.partial, rest...)
+
+	return len(p), nil
+}
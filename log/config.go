@@ -0,0 +1,204 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"io"
+	"reflect"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Config is a copy of the package level settings taken by SaveConfig.
+type Config struct {
+	prefix        string
+	dest          map[int8]io.Writer
+	settings      []int32
+	synchronous   bool
+	bulkLogPeriod time.Duration
+	idleFlush     time.Duration
+	stallTimeout  time.Duration
+	flushPeriods  [len(tagNames)]int64
+	writeTimeouts [len(tagNames)]int64
+	immediates    [len(tagNames)]int32
+	testTimings   [3]int64
+	durationUnit  int64
+	schemaVersion int64
+	filter        func(Entry) bool
+	argTransform  func(int, interface{}) interface{}
+	template      *template.Template
+	encoder       Encoder
+	sampling      *sampleRates
+	ctxSampling   *uint64
+	emptyMsg      string
+	wasOffMsg     string
+	clock         *timeSource
+	fixture       *fixture
+	formatters    map[reflect.Type]func(interface{}) string
+	blobDir       string
+}
+
+// outputSettings returns the settings of the logger that are read
+// atomically on every call, in the order SaveConfig copies them.
+// Synchronous mode is left out, it is put back with SetSynchronous so the
+// lines already queued are flushed.
+func outputSettings() []*int32 {
+	return []*int32{
+		&l.noSource, &l.dataSingleLine, &l.dataMarshal, &l.collapse,
+		&l.splunkTime, &l.maxFields, &l.maxDataLines, &l.sectionWidth,
+		&l.timeFormat, &l.panicAsError, &l.splunkCaller,
+		&l.hostname, &l.includePkg, &l.includeLevel, &l.varStyle,
+		&l.compactTags, &l.funcNameStyle, &l.indentNesting, &l.overflow,
+	}
+}
+
+// SaveConfig returns a copy of the configuration: the prefix and writers
+// set with Init, the output format settings, the filter, template,
+// encoder and schema version, argument transform, value formatters,
+// sampling and diagnostic messages, the clock, test fixture and blob
+// directory, and the bulk log period, idle flush delay, stall timeout,
+// device flush periods, write timeouts, immediate devices and test
+// timings. RestoreConfig puts it back, so a test can change settings and
+// undo them without knowing which ones it changed.
+//
+//	cfg := log.SaveConfig()
+//	t.Cleanup(func() { log.RestoreConfig(cfg) })
+//
+// The level of a Logger is its own and PushLevel is undone with Pop, so
+// neither is part of it.
+func SaveConfig() *Config {
+	c := Config{
+		bulkLogPeriod: GetBulkLogPeriod(),
+		idleFlush:     time.Duration(atomic.LoadInt64(&idleFlushDelay)),
+		durationUnit:  atomic.LoadInt64(&durationUnit),
+		schemaVersion: atomic.LoadInt64(&schemaVersion),
+		template:      lineTemplate(),
+		emptyMsg:      diagnosticValue(&emptyMsg),
+		wasOffMsg:     diagnosticValue(&loggingWasOffMsg),
+		prefix:        appPrefix(),
+		synchronous:   atomic.LoadInt32(&l.synchronous) == 1,
+	}
+
+	l.mu.Lock()
+	c.stallTimeout = l.stallTimeout
+	l.mu.Unlock()
+
+	l.destMu.RLock()
+	c.dest = make(map[int8]io.Writer, len(l.dest))
+	for d, w := range l.dest {
+		c.dest[d] = w
+	}
+	l.destMu.RUnlock()
+
+	for _, p := range outputSettings() {
+		c.settings = append(c.settings, atomic.LoadInt32(p))
+	}
+	for i := range tagNames {
+		c.flushPeriods[i] = atomic.LoadInt64(&flushPeriods[i])
+		c.writeTimeouts[i] = atomic.LoadInt64(&writeTimeouts[i])
+		c.immediates[i] = atomic.LoadInt32(&immediates[i])
+	}
+	c.testTimings = [3]int64{
+		atomic.LoadInt64(&testTimings.bulk),
+		atomic.LoadInt64(&testTimings.shutdownPre),
+		atomic.LoadInt64(&testTimings.shutdownPost),
+	}
+
+	c.filter, _ = filter.Load().(func(Entry) bool)
+	c.argTransform, _ = argTransform.Load().(func(int, interface{}) interface{})
+	c.sampling, _ = sampling.Load().(*sampleRates)
+	c.ctxSampling, _ = contextSampling.Load().(*uint64)
+	if b, ok := encoder.Load().(encoderBox); ok {
+		c.encoder = b.e
+	}
+	c.clock = currentClock()
+	c.fixture, _ = testFixture.Load().(*fixture)
+	c.formatters, _ = valueFormatters.Load().(map[reflect.Type]func(interface{}) string)
+	c.blobDir, _ = blobDir.Load().(string)
+
+	return &c
+}
+
+// RestoreConfig puts back the configuration taken by SaveConfig. Logging
+// keeps running, the lines logged after it use the restored settings.
+// The writers are left alone when the copy was taken before Init, when
+// there were none. A Config that wasn't returned by SaveConfig, like nil
+// or the zero value, is ignored.
+func RestoreConfig(c *Config) {
+	if c == nil || len(c.settings) != len(outputSettings()) {
+		return
+	}
+
+	l.prefix.Store(c.prefix)
+
+	l.mu.Lock()
+	l.stallTimeout = c.stallTimeout
+	l.mu.Unlock()
+
+	if len(c.dest) > 0 {
+		l.destMu.Lock()
+		l.dest = make(map[int8]io.Writer, len(c.dest))
+		for d, w := range c.dest {
+			l.dest[d] = w
+		}
+		l.destMu.Unlock()
+	}
+
+	for i, p := range outputSettings() {
+		atomic.StoreInt32(p, c.settings[i])
+	}
+	for i := range tagNames {
+		atomic.StoreInt64(&flushPeriods[i], c.flushPeriods[i])
+		atomic.StoreInt64(&writeTimeouts[i], c.writeTimeouts[i])
+		atomic.StoreInt32(&immediates[i], c.immediates[i])
+	}
+	atomic.StoreInt64(&testTimings.bulk, c.testTimings[0])
+	atomic.StoreInt64(&testTimings.shutdownPre, c.testTimings[1])
+	atomic.StoreInt64(&testTimings.shutdownPost, c.testTimings[2])
+	atomic.StoreInt64(&durationUnit, c.durationUnit)
+	atomic.StoreInt64(&schemaVersion, c.schemaVersion)
+
+	SetFilter(c.filter)
+	SetArgTransform(c.argTransform)
+	traceTemplate.Store(c.template)
+	SetEncoder(c.encoder)
+	sampling.Store(c.sampling)
+	contextSampling.Store(c.ctxSampling)
+	SetEmptyMessage(c.emptyMsg)
+	SetLoggingWasOffMessage(c.wasOffMsg)
+	clock.Store(c.clock)
+	testFixture.Store(c.fixture)
+	SetBlobDir(c.blobDir)
+
+	valueFormattersMu.Lock()
+	valueFormatters.Store(c.formatters)
+	valueFormattersMu.Unlock()
+
+	SetSynchronous(c.synchronous)
+
+	// The flush periods are worked out again by the writer.
+	SetBulkLogPeriod(c.bulkLogPeriod)
+	SetIdleFlushDelay(c.idleFlush)
+}
+
+// diagnosticValue returns the message set in place of a default, or an
+// empty string when none is set.
+func diagnosticValue(v *atomic.Value) string {
+	msg, _ := v.Load().(string)
+	return msg
+}
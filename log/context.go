@@ -0,0 +1,207 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// noContext is the type of NoContext.
+type noContext struct{}
+
+// String implements the fmt.Stringer interface so NoContext is written as
+// nothing by the lines that don't leave out the segment, like Event and
+// the templates set with SetTemplate.
+func (noContext) String() string {
+	return ""
+}
+
+// NoContext can be passed as the context of a logging call that has none.
+// The context segment is then left out of the line instead of being
+// written empty.
+//
+//	log.Start(log.NoContext, "main")
+//	... LOG[69910]: main.go#10: main: Started:
+var NoContext interface{} = noContext{}
+
+// contexts maintains a stack of pushed contexts for each goroutine.
+var contexts = struct {
+	mu sync.Mutex
+	m  map[uint64][]interface{}
+}{
+	m: make(map[uint64][]interface{}),
+}
+
+// gid returns the id of the calling goroutine. Go does not expose the id
+// so it is parsed from the "goroutine N [" header of the stack trace.
+func gid() uint64 {
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// PushContext sets the default context for the calling goroutine and
+// returns a function that restores the previous one. Calls that do not
+// take a context, like Trace0, use the value on the top of the stack.
+//
+// There is no goroutine local storage in Go so contexts are kept in a
+// stack keyed by goroutine id. The pop function must be called, usually
+// with defer, or the stack for that goroutine will leak. Contexts are not
+// inherited by goroutines started after the push, and looking up the
+// goroutine id makes these calls slower than the ones that take an
+// explicit context.
+func PushContext(ctx interface{}) func() {
+	id := gid()
+
+	contexts.mu.Lock()
+	{
+		contexts.m[id] = append(contexts.m[id], ctx)
+	}
+	contexts.mu.Unlock()
+
+	return func() {
+		contexts.mu.Lock()
+		{
+			s := contexts.m[id]
+			if len(s) <= 1 {
+				delete(contexts.m, id)
+			} else {
+				contexts.m[id] = s[:len(s)-1]
+			}
+		}
+		contexts.mu.Unlock()
+	}
+}
+
+// pushedContext returns the context on the top of the stack for
+// the calling goroutine or an empty string if there is none.
+func pushedContext() interface{} {
+	id := gid()
+
+	contexts.mu.Lock()
+	defer contexts.mu.Unlock()
+
+	s := contexts.m[id]
+	if len(s) == 0 {
+		return ""
+	}
+
+	return s[len(s)-1]
+}
+
+// maxDepths is the most contexts whose Start depth is kept at once.
+const maxDepths = 10000
+
+// depths holds the Start depth of each context when nesting is indented.
+var depths = struct {
+	mu sync.Mutex
+	m  map[string]int
+}{
+	m: make(map[string]int),
+}
+
+// SetIndentNesting turns indenting nested Start and Complete blocks on or
+// off. When it is on each Start for a context indents the lines of that
+// context that follow by two more spaces, in front of the function name,
+// and each Complete takes them back, so a single threaded flow reads like
+// a call tree:
+//
+//	... TEST: Outer: Started:
+//	... TEST:   Inner: Started:
+//	... TEST:     Inner: Trace: working
+//	... TEST:   Inner: Completed:
+//	... TEST: Outer: Completed:
+//
+// Contexts are told apart by their %v text. A Complete without a Start
+// doesn't indent less than nothing, and a Start without a Complete leaves
+// the context indented. At most 10000 contexts are kept indented at once;
+// past that a Start forgets one of the others. Turning it on or off starts
+// every context over. It is off by default.
+func SetIndentNesting(on bool) {
+	depths.mu.Lock()
+	depths.m = make(map[string]int)
+	depths.mu.Unlock()
+
+	if on {
+		atomic.StoreInt32(&l.indentNesting, 1)
+		return
+	}
+	atomic.StoreInt32(&l.indentNesting, 0)
+}
+
+// nesting returns the indent for a line of the context with the tag and
+// how far the line moves the depth of the context: in for Started and out
+// for Completed. The move is left to moveDepth once the line is written.
+func nesting(context interface{}, tag string) (string, int) {
+	if atomic.LoadInt32(&l.indentNesting) == 0 {
+		return "", 0
+	}
+
+	depths.mu.Lock()
+	depth := depths.m[fmt.Sprintf("%v", context)]
+	depths.mu.Unlock()
+
+	switch tag {
+	case "Started":
+		return strings.Repeat("  ", depth), 1
+	case "Completed", "Completed ERROR":
+		if depth > 0 {
+			depth--
+		}
+		return strings.Repeat("  ", depth), -1
+	}
+
+	return strings.Repeat("  ", depth), 0
+}
+
+// moveDepth moves the depth of the context by the amount nesting returned.
+func moveDepth(context interface{}, move int) {
+	if move == 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%v", context)
+
+	depths.mu.Lock()
+	defer depths.mu.Unlock()
+
+	depth := depths.m[key] + move
+	if depth <= 0 {
+		delete(depths.m, key)
+		return
+	}
+
+	if _, ok := depths.m[key]; !ok && len(depths.m) >= maxDepths {
+		for k := range depths.m {
+			delete(depths.m, k)
+			break
+		}
+	}
+	depths.m[key] = depth
+}
@@ -16,7 +16,14 @@
 
 package log
 
-import "io"
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Set of constants that represent different trace lines
 // types. Used to map different devices to the types.
@@ -32,8 +39,55 @@ const (
 	DevQuery
 	DevData
 	DevSplunk
+	DevEvent
+	DevAudit
 )
 
+// tagNames maps each device to the tag counted by TagCounts.
+var tagNames = [...]string{
+	DevStart:   "START",
+	DevError:   "ERROR",
+	DevPanic:   "PANIC",
+	DevTrace:   "TRACE",
+	DevWarning: "WARNING",
+	DevQuery:   "QUERY",
+	DevData:    "DATA",
+	DevSplunk:  "SPLUNK",
+	DevEvent:   "EVENT",
+	DevAudit:   "AUDIT",
+}
+
+// tagCounts holds the number of lines written for each device.
+var tagCounts [len(tagNames)]uint64
+
+// countTag counts a line written to the device.
+func countTag(d int8) {
+	atomic.AddUint64(&tagCounts[d], 1)
+}
+
+// resetTagCounts sets the counts of every device back to zero.
+func resetTagCounts() {
+	for d := range tagCounts {
+		atomic.StoreUint64(&tagCounts[d], 0)
+	}
+}
+
+// TagCounts returns the number of lines written for each tag since Init
+// was last called. The tags are START, ERROR, PANIC, TRACE, WARNING,
+// QUERY, DATA, SPLUNK, EVENT and AUDIT. Lines are counted by device so Start and
+// Complete are both counted as START. Lines dropped by the filter or
+// written to a nil device are not counted.
+func TagCounts() map[string]uint64 {
+	m := make(map[string]uint64, len(tagNames))
+	for d, name := range tagNames {
+		if name != "" {
+			m[name] = atomic.LoadUint64(&tagCounts[d])
+		}
+	}
+
+	return m
+}
+
 // DevWriter can be used in Init to change the default
 // writers for use.
 type DevWriter struct {
@@ -62,6 +116,348 @@ func (dev) get(d int8) io.Writer {
 	return w
 }
 
+// Rotate points the device at a new writer and then writes every line that
+// is still buffered for the old one, so a rotated file is complete once it
+// returns and the old writer can be closed. This is unlike setting the
+// writer with Dev.Trace and friends, which leaves buffered lines to be
+// written to the old writer later. Lines logged before Rotate is called go
+// to the old writer and lines logged after it returns go to the new one.
+//
+// The boundary is not exact for calls that run at the same time as
+// Rotate. The device is swapped before the flush rather than after, so
+// new calls can't buffer lines for the old writer while it is flushed,
+// but a call that picked up the old writer just before the swap can still
+// queue its line for it after Rotate returns. When logging goes on from
+// other goroutines during the rotation, call Flush once more before
+// closing the old writer. DevAll rotates every device. An error is
+// returned for a device that doesn't exist.
+//
+//	f, err := os.Create("app.log.new")
+//	...
+//	if err := log.Dev.Rotate(log.DevTrace, f); err == nil {
+//		old.Close()
+//	}
+func (dev) Rotate(device int8, w io.Writer) error {
+	if device < DevAll || int(device) >= len(tagNames) {
+		return fmt.Errorf("unknown device %d", device)
+	}
+
+	l.destMu.Lock()
+	{
+		if device == DevAll {
+			for d := range tagNames {
+				if d != int(DevAll) {
+					l.dest[int8(d)] = w
+				}
+			}
+		} else {
+			l.dest[device] = w
+		}
+	}
+	l.destMu.Unlock()
+
+	// Swapping first means new calls stop buffering lines for the old
+	// writer. The flush writes what was buffered for it before.
+	Flush()
+
+	return nil
+}
+
+// DevEnabled reports whether lines for the device are written anywhere.
+// It can be used to skip building an expensive message when the device
+// has been set to nil.
+func DevEnabled(d int8) bool {
+	return Dev.get(d) != nil
+}
+
+// immediates marks the devices whose lines are flushed as soon as they
+// are logged.
+var immediates = [len(tagNames)]int32{
+	DevError: 1,
+	DevPanic: 1,
+}
+
+// SetImmediate sets whether the lines of the device are flushed as soon as
+// they are logged instead of waiting for the flush period, so the line is
+// not lost when the program crashes right after writing it. The line takes
+// every line buffered before it for the same writer along, so the order of
+// the lines is kept. DevAll sets every device. It is on for DevError, used
+// by ErrFatal too, and DevPanic by default and off for the others.
+func (dev) SetImmediate(device int8, on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+
+	if device == DevAll {
+		for i := range immediates {
+			atomic.StoreInt32(&immediates[i], v)
+		}
+		return
+	}
+
+	if device > DevAll && int(device) < len(immediates) {
+		atomic.StoreInt32(&immediates[device], v)
+	}
+}
+
+// immediate reports whether the lines of the device are flushed as soon as
+// they are logged.
+func immediate(d int8) bool {
+	return d >= DevAll && int(d) < len(immediates) && atomic.LoadInt32(&immediates[d]) == 1
+}
+
+// flushPeriods holds the flush period set for each device in nanoseconds.
+// A period of 0 means the device uses the bulk log period.
+var flushPeriods [len(tagNames)]int64
+
+// SetFlushPeriod sets how long lines for the device are buffered before
+// they are written, in place of the bulk log period. A low latency device
+// like stderr can be flushed sooner than a file that is better written in
+// large chunks. DevAll sets the period of every device and a period of 0
+// or less goes back to the bulk log period. When devices with different
+// periods share a writer, it is flushed at the shortest one.
+func (dev) SetFlushPeriod(device int8, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	if device == DevAll {
+		for i := range flushPeriods {
+			atomic.StoreInt64(&flushPeriods[i], int64(d))
+		}
+		notifyPeriodChanged()
+		return
+	}
+
+	if device > DevAll && int(device) < len(flushPeriods) {
+		atomic.StoreInt64(&flushPeriods[device], int64(d))
+		notifyPeriodChanged()
+	}
+}
+
+// flushPeriod returns how long lines for the writer can be buffered. It is
+// the shortest period of the devices that use the writer.
+func flushPeriod(w io.Writer) time.Duration {
+	bulk := GetBulkLogPeriod()
+	period := time.Duration(-1)
+
+	l.destMu.RLock()
+	{
+		for d, dw := range l.dest {
+			if dw != w || d < DevAll || int(d) >= len(flushPeriods) {
+				continue
+			}
+			p := time.Duration(atomic.LoadInt64(&flushPeriods[d]))
+			if p == 0 {
+				p = bulk
+			}
+			if period < 0 || p < period {
+				period = p
+			}
+		}
+	}
+	l.destMu.RUnlock()
+
+	if period < 0 {
+		return bulk
+	}
+
+	return period
+}
+
+// writeTimeouts holds the write timeout set for each device in nanoseconds.
+// A timeout of 0 means writes to the device are never abandoned.
+var writeTimeouts [len(tagNames)]int64
+
+// timedOut counts the flushes that were abandoned by a write timeout.
+var timedOut uint64
+
+// stuckWrites holds, for each writer, a channel that is closed once a
+// write that was abandoned finally returns.
+var stuckWrites = struct {
+	sync.Mutex
+	m map[io.Writer]chan struct{}
+}{m: make(map[io.Writer]chan struct{})}
+
+// SetWriteTimeout sets how long a flush waits on the device's writer. When
+// the write doesn't return in time the flush moves on and the lines of that
+// flush are lost for the device. DevAll sets the timeout of every device and
+// a timeout of 0 or less waits forever, which is the default. When devices
+// with different timeouts share a writer, the shortest one is used.
+//
+// A blocked Write can't be cancelled, so the goroutine doing it is left
+// behind until it returns. To keep these from piling up, there is at most
+// one per writer: while it is still blocked every flush for the writer is
+// dropped right away. Each abandoned or dropped flush is counted by
+// WriteTimeouts.
+func (dev) SetWriteTimeout(device int8, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	if device == DevAll {
+		for i := range writeTimeouts {
+			atomic.StoreInt64(&writeTimeouts[i], int64(d))
+		}
+		return
+	}
+
+	if device > DevAll && int(device) < len(writeTimeouts) {
+		atomic.StoreInt64(&writeTimeouts[device], int64(d))
+	}
+}
+
+// WriteTimeouts returns the number of flushes lost to SetWriteTimeout since
+// the program started.
+func WriteTimeouts() uint64 {
+	return atomic.LoadUint64(&timedOut)
+}
+
+// writeTimeout returns how long a write to the writer can take. It is the
+// shortest timeout of the devices that use the writer, 0 when none is set.
+func writeTimeout(w io.Writer) time.Duration {
+	var timeout time.Duration
+
+	l.destMu.RLock()
+	{
+		for d, dw := range l.dest {
+			if dw != w || d < DevAll || int(d) >= len(writeTimeouts) {
+				continue
+			}
+			t := time.Duration(atomic.LoadInt64(&writeTimeouts[d]))
+			if t > 0 && (timeout == 0 || t < timeout) {
+				timeout = t
+			}
+		}
+	}
+	l.destMu.RUnlock()
+
+	return timeout
+}
+
+// writeLocks holds a *sync.Mutex for each writer that has been written to.
+// The flushes of the writer goroutine, the audit records and the lines
+// written synchronously all go through writeTo, so a writer never sees
+// concurrent writes.
+var writeLocks sync.Map
+
+// writeTo writes the bytes to the writer while holding the lock of the
+// writer. A writer that panics is reported as an error so the goroutine
+// doing the write keeps going.
+func writeTo(w io.Writer, b []byte) (err error) {
+	mu, _ := writeLocks.LoadOrStore(w, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("write panicked: %v", r)
+		}
+	}()
+
+	_, err = w.Write(b)
+	return err
+}
+
+// writeDevice writes the bytes of a flush to the writer, giving up once the
+// write timeout of the writer has passed.
+func writeDevice(w io.Writer, b []byte) error {
+	timeout := writeTimeout(w)
+	if timeout <= 0 {
+		return writeTo(w, b)
+	}
+
+	stuckWrites.Lock()
+	if stuck, ok := stuckWrites.m[w]; ok {
+		select {
+		case <-stuck:
+			delete(stuckWrites.m, w)
+		default:
+			stuckWrites.Unlock()
+			atomic.AddUint64(&timedOut, 1)
+			return fmt.Errorf("write dropped, an earlier write has not returned")
+		}
+	}
+	stuckWrites.Unlock()
+
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = writeTo(w, b)
+		close(done)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return err
+	case <-t.C:
+		stuckWrites.Lock()
+		stuckWrites.m[w] = done
+		stuckWrites.Unlock()
+		atomic.AddUint64(&timedOut, 1)
+		return fmt.Errorf("write timed out after %v", timeout)
+	}
+}
+
+// deviceOrder returns the lowest device that uses the writer, or one past
+// the last device for a writer that no device uses anymore.
+func deviceOrder(w io.Writer) int {
+	order := len(tagNames)
+
+	l.destMu.RLock()
+	{
+		for d, dw := range l.dest {
+			if dw == w && int(d) < order {
+				order = int(d)
+			}
+		}
+	}
+	l.destMu.RUnlock()
+
+	return order
+}
+
+// sameFiles returns a warning for each pair of devices that write to the
+// same regular file through different *os.File handles. Writes through
+// separate handles don't share an offset, so the lines can overwrite or
+// interleave with each other. It is best effort: writers that are not an
+// *os.File, or can't be stat'ed, are skipped.
+func sameFiles(dest map[int8]io.Writer) []string {
+	type device struct {
+		d    int8
+		f    *os.File
+		info os.FileInfo
+	}
+
+	var files []device
+	for d := range tagNames {
+		f, ok := dest[int8(d)].(*os.File)
+		if !ok || f == nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, device{int8(d), f, info})
+	}
+
+	var warnings []string
+	for i := range files {
+		for _, other := range files[i+1:] {
+			if files[i].f != other.f && os.SameFile(files[i].info, other.info) {
+				warnings = append(warnings, fmt.Sprintf("devices %s and %s write to the same file %s through different handles", tagNames[files[i].d], tagNames[other.d], other.f.Name()))
+			}
+		}
+	}
+
+	return warnings
+}
+
 // All sets all destinations to the specified device.
 func (dev) All(w io.Writer) {
 	l.destMu.Lock()
@@ -74,6 +470,8 @@ func (dev) All(w io.Writer) {
 		l.dest[DevQuery] = w
 		l.dest[DevData] = w
 		l.dest[DevSplunk] = w
+		l.dest[DevEvent] = w
+		l.dest[DevAudit] = w
 	}
 	l.destMu.Unlock()
 }
@@ -149,3 +547,42 @@ func (dev) Splunk(w io.Writer) {
 	}
 	l.destMu.Unlock()
 }
+
+// Event sets the event functions device.
+func (dev) Event(w io.Writer) {
+	l.destMu.Lock()
+	{
+		l.dest[DevEvent] = w
+	}
+	l.destMu.Unlock()
+}
+
+// Audit sets the audit functions device.
+func (dev) Audit(w io.Writer) {
+	l.destMu.Lock()
+	{
+		l.dest[DevAudit] = w
+	}
+	l.destMu.Unlock()
+}
+
+// priorityWriter writes each call to Write as a line on a device.
+type priorityWriter struct {
+	device   int8
+	priority int
+}
+
+// NewPriorityWriter returns an io.Writer that queues each write as a line
+// for the specified device. When lines are written to the device in bulk,
+// lines with a higher priority are written ahead of lines with a lower
+// one, even if they were queued later. Trace lines have a priority of 0
+// and lines with the same priority keep their order.
+func NewPriorityWriter(device int8, priority int) io.Writer {
+	return priorityWriter{device: device, priority: priority}
+}
+
+// Write implements the io.Writer interface.
+func (pw priorityWriter) Write(p []byte) (int, error) {
+	outputAt(Dev.get(pw.device), pw.priority, "%s", p)
+	return len(p), nil
+}
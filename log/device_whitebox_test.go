@@ -19,7 +19,10 @@ package log
 import (
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // succeed is the Unicode codepoint for a check mark.
@@ -45,6 +48,8 @@ func TestDevAPI(t *testing.T) {
 		{DevQuery, Dev.Query},
 		{DevData, Dev.Data},
 		{DevSplunk, Dev.Splunk},
+		{DevEvent, Dev.Event},
+		{DevAudit, Dev.Audit},
 	}
 
 	t.Log("Given the need to set all devices.")
@@ -98,7 +103,7 @@ func TestInitOnlyOneDevice(t *testing.T) {
 	defer Shutdown()
 
 	nilDevice := [...]int8{DevError, DevPanic, DevTrace, DevWarning,
-		DevQuery, DevData, DevSplunk}
+		DevQuery, DevData, DevSplunk, DevEvent, DevAudit}
 
 	if Dev.get(DevStart) != os.Stdin {
 		t.Error("\tDevice DevStart should be Stdout.", failed)
@@ -115,3 +120,76 @@ func TestInitOnlyOneDevice(t *testing.T) {
 		t.Logf("\tDevice %d should not be stdin. %s", d, succeed)
 	}
 }
+
+func TestSameFiles(t *testing.T) {
+	t.Log("Given the need to catch a log file that was opened twice.")
+	{
+		name := filepath.Join(t.TempDir(), "app.log")
+
+		f1, err := os.Create(name)
+		if err != nil {
+			t.Fatal("\tShould be able to create the log file.", failed, err)
+		}
+		defer f1.Close()
+
+		f2, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal("\tShould be able to open the log file again.", failed, err)
+		}
+		defer f2.Close()
+
+		warnings := sameFiles(map[int8]io.Writer{
+			DevStart: f1,
+			DevTrace: f1,
+			DevError: f2,
+			DevData:  os.Stdout,
+		})
+
+		exp := "devices ERROR and TRACE write to the same file " + name + " through different handles"
+		if len(warnings) == 2 && strings.HasPrefix(warnings[0], "devices START and ERROR") && warnings[1] == exp {
+			t.Log("\tShould warn for each pair of devices using different handles.", succeed)
+		} else {
+			t.Errorf("\tShould warn for each pair of devices using different handles. %s %q", failed, warnings)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	t.Log("Given the need to rotate a device without losing buffered lines.")
+	{
+		var old, cur SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &old})
+		defer Shutdown()
+
+		SetBulkLogPeriod(time.Hour)
+		defer SetBulkLogPeriod(50 * time.Millisecond)
+
+		Tracef("TEST", "foo", "before")
+		if err := Dev.Rotate(DevTrace, &cur); err != nil {
+			t.Fatal("\tShould rotate the device.", failed, err)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: before\n"
+		if got := old.String(); got == exp {
+			t.Log("\tShould write the buffered lines to the old writer before returning.", succeed)
+		} else {
+			t.Errorf("\tShould write the buffered lines to the old writer before returning. %s %q", failed, got)
+		}
+
+		Tracef("TEST", "foo", "after")
+		Flush()
+
+		exp = "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: after\n"
+		if got := cur.String(); got == exp && Dev.get(DevError) == &old {
+			t.Log("\tShould write later lines to the new writer of that device only.", succeed)
+		} else {
+			t.Errorf("\tShould write later lines to the new writer of that device only. %s %q", failed, got)
+		}
+
+		if err := Dev.Rotate(42, &cur); err != nil {
+			t.Log("\tShould fail for an unknown device.", succeed)
+		} else {
+			t.Error("\tShould fail for an unknown device.", failed)
+		}
+	}
+}
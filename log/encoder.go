@@ -0,0 +1,274 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Encoder writes a trace line in a format of its own. Encode is called
+// with the parts of each trace line and returns the line, with or without
+// the trailing newline.
+type Encoder interface {
+	Encode(fields LineFields) []byte
+}
+
+// LineFields holds the parts of a trace line that are passed to an
+// Encoder set with SetEncoder.
+type LineFields struct {
+	Time    string    // Formatted as set with SetTimeFormat.
+	Stamp   time.Time // The time written in Time.
+	App     string
+	PID     int
+	File    string // "-" when SetSourceLocation is off.
+	Line    int
+	Context interface{} // NoContext when there is none.
+	Func    string
+	Tag     string
+	Message string       // The message after the var segment.
+	Fields  []SplunkPair // The fields of the var segment, in order.
+	Data    []string     // The lines of the block of a DATA line.
+}
+
+// encoder holds the encoder set with SetEncoder.
+var encoder atomic.Value // encoderBox
+
+// encoderBox lets a nil encoder be stored.
+type encoderBox struct {
+	e Encoder
+}
+
+// SetEncoder sets the encoder used to write the trace lines in place of
+// the built in layout and the template set with SetTemplate. TextEncoder,
+// JSONEncoder, LogfmtEncoder and OTLPEncoder are built in, other formats
+// can be written by implementing Encoder. The lines of the block of a DATA line are
+// passed with the line, without the empty ones and cut to
+// SetMaxDataLines. The LoggingWasOff and empty message diagnostics are encoded too, with the
+// tags LOG WARNING and LOG ERROR. Splunk, Event and Audit records are not
+// changed. Passing nil goes back to the built in layout.
+func SetEncoder(e Encoder) {
+	encoder.Store(encoderBox{e})
+}
+
+// lineFields returns the fields of a trace line. The file is split into
+// the file and the line number.
+func lineFields(dt string, pid int, file string, context interface{}, funcName string, tag string, message string, pairs []SplunkPair) LineFields {
+	f := LineFields{
+		Time:    dt,
+		Stamp:   clockNow(),
+		App:     appPrefix(),
+		PID:     pid,
+		File:    file,
+		Context: context,
+		Func:    funcName,
+		Tag:     tag,
+		Message: message,
+		Fields:  pairs,
+	}
+
+	if i := strings.LastIndex(file, "#"); i >= 0 {
+		f.File = file[:i]
+		f.Line, _ = strconv.Atoi(file[i+1:])
+	}
+
+	return f
+}
+
+// formatLine writes a trace line with the encoder set with SetEncoder,
+// else the template set with SetTemplate, else TextEncoder. The line is
+// returned without the trailing newline.
+func formatLine(f LineFields) string {
+	if b, _ := encoder.Load().(encoderBox); b.e != nil {
+		return encode(b.e, f)
+	}
+
+	if ln, ok := renderLine(f); ok {
+		return ln
+	}
+
+	return encode(TextEncoder{}, f)
+}
+
+// encode returns the line written by the encoder without the trailing
+// newline.
+func encode(e Encoder, f LineFields) string {
+	return strings.TrimSuffix(string(e.Encode(f)), "\n")
+}
+
+// diagnosticLine returns a diagnostic message of the logger itself. When
+// an encoder is set with SetEncoder it is encoded as a trace line with the
+// tag, so the output can still be parsed.
+func diagnosticLine(tag string, msg string) string {
+	b, _ := encoder.Load().(encoderBox)
+	if b.e == nil {
+		return msg
+	}
+
+	dt, _, _, pid := dtFile(1, "log")
+	f := lineFields(dt, pid, "-", NoContext, "log", tag, strings.TrimSuffix(msg, "\n"), nil)
+
+	return encode(b.e, f) + "\n"
+}
+
+// TextEncoder writes the trace lines in the built in layout. It is used
+// when no encoder or template is set.
+type TextEncoder struct{}
+
+// Encode implements the Encoder interface.
+func (TextEncoder) Encode(f LineFields) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s: %s[%d]: %s: %s%s: %s", f.Time, f.App, f.PID, lineFile(f), contextSegment(f.Context), f.Func, f.Tag)
+
+	// The line that follows a fatal error ends with its tag.
+	if f.Tag != "TERMINATING" {
+		buf.WriteByte(':')
+	}
+
+	if rest := joinVars(formatVars(f.Fields), f.Message); rest != "" {
+		buf.WriteString(" " + rest)
+	}
+
+	if f.Data != nil {
+		writeDataLines(&buf, dataBytes(f.Data))
+	}
+
+	return buf.Bytes()
+}
+
+// schemaVersion holds the version JSONEncoder writes in the v field.
+var schemaVersion = int64(1)
+
+// SetSchemaVersion sets the version of the layout written by JSONEncoder,
+// in the top level v field of every line. It is 1 by default. Bump it when
+// the fields change, so the programs reading the lines can tell the
+// layouts apart.
+func SetSchemaVersion(v int) {
+	atomic.StoreInt64(&schemaVersion, int64(v))
+}
+
+// JSONEncoder writes each trace line as a JSON object, with the version
+// set with SetSchemaVersion and the fields of the var segment in a fields
+// object:
+//
+//	{"v":1,"time":"...","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Trace","message":"hello","fields":{"id":7}}
+//
+// The lines of the block of a DATA line are joined with newlines into a
+// data string, so every line is a single JSON object. The context is left
+// out for NoContext and the fields and data when there are none.
+type JSONEncoder struct{}
+
+// Encode implements the Encoder interface.
+func (JSONEncoder) Encode(f LineFields) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, `{"v":%d,"time":`, atomic.LoadInt64(&schemaVersion))
+	writeJSON(&buf, f.Time)
+	buf.WriteString(`,"app":`)
+	writeJSON(&buf, f.App)
+	fmt.Fprintf(&buf, `,"pid":%d,"file":`, f.PID)
+	writeJSON(&buf, f.File)
+	fmt.Fprintf(&buf, `,"line":%d`, f.Line)
+	if f.Context != NoContext {
+		buf.WriteString(`,"context":`)
+		writeJSON(&buf, fmt.Sprintf("%v", f.Context))
+	}
+	buf.WriteString(`,"func":`)
+	writeJSON(&buf, f.Func)
+	buf.WriteString(`,"tag":`)
+	writeJSON(&buf, f.Tag)
+	buf.WriteString(`,"message":`)
+	writeJSON(&buf, f.Message)
+	if len(f.Fields) > 0 {
+		buf.WriteString(`,"fields":`)
+		writeJSONPairs(&buf, f.Fields)
+	}
+	if f.Data != nil {
+		buf.WriteString(`,"data":`)
+		writeJSON(&buf, strings.Join(f.Data, "\n"))
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes()
+}
+
+// LogfmtMode sets where LogfmtEncoder writes the message.
+type LogfmtMode int
+
+const (
+	// LogfmtMessageFirst writes the message after the tag, ahead of the
+	// fields of the var segment.
+	LogfmtMessageFirst LogfmtMode = iota
+
+	// LogfmtMessageLast writes the message as the last key of the line,
+	// after the fields of the var segment and the data.
+	LogfmtMessageLast
+)
+
+// LogfmtEncoder writes each trace line as logfmt key=value pairs. The
+// keys of the line come first, always in the same order, then the message
+// and the fields of the var segment in the order they were given:
+//
+//	time="2009/11/10 15:00:00.000000000" app=LOG pid=69910 file=file.go line=512 context=TEST func=foo tag=ERROR msg="failed: A" id=7
+//
+// With the mode LogfmtMessageLast the msg key ends the line, for parsers
+// that read the message from there. The lines of the block of a DATA
+// line are joined with newlines into a quoted data value. The context is
+// left out for NoContext and the data when there is none.
+type LogfmtEncoder struct {
+	Mode LogfmtMode
+}
+
+// Encode implements the Encoder interface.
+func (e LogfmtEncoder) Encode(f LineFields) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "time=%s app=%s pid=%d file=%s line=%d", logfmtQuote(f.Time), logfmtQuote(f.App), f.PID, logfmtQuote(f.File), f.Line)
+	if f.Context != NoContext {
+		buf.WriteString(" context=" + logfmtQuote(f.Context))
+	}
+	fmt.Fprintf(&buf, " func=%s tag=%s", logfmtQuote(f.Func), logfmtQuote(f.Tag))
+
+	if e.Mode != LogfmtMessageLast {
+		buf.WriteString(" msg=" + logfmtQuote(f.Message))
+	}
+	for _, kv := range f.Fields {
+		buf.WriteString(" " + kv.Key + "=" + logfmtQuote(kv.Value))
+	}
+	if f.Data != nil {
+		buf.WriteString(" data=" + logfmtQuote(strings.Join(f.Data, "\n")))
+	}
+	if e.Mode == LogfmtMessageLast {
+		buf.WriteString(" msg=" + logfmtQuote(f.Message))
+	}
+
+	return buf.Bytes()
+}
+
+// lineFile returns the file slot of a trace line.
+func lineFile(f LineFields) string {
+	if f.Line == 0 {
+		return f.File
+	}
+
+	return f.File + "#" + strconv.Itoa(f.Line)
+}
@@ -0,0 +1,150 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Event is used to write a structured record for machine consumption to
+// the DevEvent device. Each record is a single line of JSON with the time,
+// app, pid and event name, and the specified fields under "fields".
+//
+//	{"time":"2009-11-10T15:00:00Z","app":"LOG","pid":69910,"event":"login","fields":{"user":"bob"}}
+//
+// Fields are written in key order and are limited by SetMaxFields. If a value can't be encoded as JSON,
+// like a channel or a cyclic structure, the event is written as a plain
+// text line tagged EVENT FALLBACK instead so it is not lost. Values that
+// failed are written as their type.
+//
+//	2009/11/10 15:00:00.000000000: LOG[69910]: EVENT FALLBACK: login: ch=<chan int> user=bob: json: unsupported type: chan int
+func Event(name string, fields map[string]interface{}) {
+	w := Dev.get(DevEvent)
+	if w == nil {
+		return
+	}
+
+	now := clockNow()
+	pid := os.Getpid()
+	if atomic.LoadInt32(&l.test) == 1 {
+		pid = testValues().pid
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	keep, more := fieldLimit(len(keys))
+	keys = keys[:keep]
+
+	var buf bytes.Buffer
+
+	buf.WriteString(`{"time":`)
+	err := writeJSON(&buf, now.UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"app":`)
+	err = firstErr(err, writeJSON(&buf, appPrefix()))
+	fmt.Fprintf(&buf, `,"pid":%d,"event":`, pid)
+	err = firstErr(err, writeJSON(&buf, name))
+
+	// Keep track of the values that failed so the fallback doesn't try
+	// to format them. A cyclic map would never finish.
+	var failed map[string]bool
+
+	if len(keys) > 0 {
+		buf.WriteString(`,"fields":{`)
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err = firstErr(err, writeJSON(&buf, k))
+			buf.WriteByte(':')
+			if ferr := writeJSON(&buf, fields[k]); ferr != nil {
+				if failed == nil {
+					failed = make(map[string]bool)
+				}
+				failed[k] = true
+				err = firstErr(err, ferr)
+			}
+		}
+		if more > 0 {
+			fmt.Fprintf(&buf, `,"...":"(+%d more)"`, more)
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte('}')
+
+	if err != nil {
+		buf.Reset()
+		fmt.Fprintf(&buf, "%s: %s[%d]: EVENT FALLBACK: %s:", formatTime(now), appPrefix(), pid, name)
+		for _, k := range keys {
+			v := fmt.Sprintf("<%T>", fields[k])
+			if !failed[k] {
+				v = splunkEncode(fields[k])
+			}
+			fmt.Fprintf(&buf, " %s=%s", splunkEncode(k), v)
+		}
+		if more > 0 {
+			fmt.Fprintf(&buf, " ...(+%d more)", more)
+		}
+		fmt.Fprintf(&buf, ": %s", err)
+	}
+
+	if !passes(DevEvent, NoContext, "", buf.String()) {
+		return
+	}
+
+	countTag(DevEvent)
+	output(w, "%s\n", buf.String())
+}
+
+// writeJSON writes the JSON encoding of the value into the buffer. Nothing
+// is written if the value can't be encoded. A panic in a MarshalJSON
+// method is returned as an error.
+func writeJSON(buf *bytes.Buffer, v interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("json: panic encoding %T: %v", v, r)
+		}
+	}()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+
+	return nil
+}
+
+// firstErr returns the first of the errors that is not nil.
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HexDump implements the Formatter interface to produce a hex dump of a
+// slice of bytes with 16 bytes on each line.
+//
+//	(0x0000) EE 6E 11 00 00 00 3E EA DE 18 00 00 2D 00 00 00
+//	(0x0010) 3E EA DE
+type HexDump []byte
+
+// Format implements the Formatter interface.
+func (h HexDump) Format() string {
+	var buf bytes.Buffer
+
+	for st := 0; st < len(h); st += 16 {
+		end := st + 16
+		if end > len(h) {
+			end = len(h)
+		}
+
+		fmt.Fprintf(&buf, "(0x%.4X)", st)
+		for _, b := range h[st:end] {
+			fmt.Fprintf(&buf, " %.2X", b)
+		}
+		buf.WriteByte('\n')
+	}
+
+	return buf.String()
+}
+
+// HexASCIIDump implements the Formatter interface to produce a hex dump of
+// a slice of bytes in the style of hexdump -C, with 16 bytes on each line
+// and a column of the printable ASCII characters.
+//
+//	00000000  48 65 6c 6c 6f 2c 20 57  6f 72 6c 64 21 0a        |Hello, World!.|
+//	0000000e
+type HexASCIIDump []byte
+
+// Format implements the Formatter interface.
+func (h HexASCIIDump) Format() string {
+	var buf bytes.Buffer
+
+	for st := 0; st < len(h); st += 16 {
+		end := st + 16
+		if end > len(h) {
+			end = len(h)
+		}
+
+		fmt.Fprintf(&buf, "%08x ", st)
+		for i := st; i < st+16; i++ {
+			if i == st+8 {
+				buf.WriteByte(' ')
+			}
+			if i < end {
+				fmt.Fprintf(&buf, " %02x", h[i])
+			} else {
+				buf.WriteString("   ")
+			}
+		}
+
+		buf.WriteString("  |")
+		for _, b := range h[st:end] {
+			if b < ' ' || b > '~' {
+				b = '.'
+			}
+			buf.WriteByte(b)
+		}
+		buf.WriteString("|\n")
+	}
+	fmt.Fprintf(&buf, "%08x\n", len(h))
+
+	return buf.String()
+}
+
+// Base64Dump implements the Formatter interface to produce the standard
+// base64 encoding of a slice of bytes with 76 characters on each line.
+type Base64Dump []byte
+
+// Format implements the Formatter interface.
+func (d Base64Dump) Format() string {
+	s := base64.StdEncoding.EncodeToString(d)
+
+	var buf bytes.Buffer
+	for len(s) > 76 {
+		buf.WriteString(s[:76])
+		buf.WriteByte('\n')
+		s = s[76:]
+	}
+	buf.WriteString(s)
+
+	return buf.String()
+}
+
+// Duration is a time.Duration that is written for whoever reads it. Trace
+// lines get the duration as text for people and the Splunk pairs, JSON
+// events and the VarEquals and VarJSON var segments get it as a number of
+// milliseconds for dashboards. Since the unit is not written with the
+// number, keys that end up in Splunk or an Event should say it.
+//
+//	log.DataKV(ctx, "Get", "elapsed", log.Duration(d))
+//	... DATA: elapsed: 1.2s
+//	log.Splunk(log.SplunkPair{Key: "elapsed_ms", Value: log.Duration(d)})
+//	... elapsed_ms=1200
+type Duration time.Duration
+
+// String implements the fmt.Stringer interface.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Format implements the Formatter interface.
+func (d Duration) Format() string {
+	return d.String()
+}
+
+// Millis returns the duration in whole milliseconds.
+func (d Duration) Millis() int64 {
+	return int64(time.Duration(d) / time.Millisecond)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(d.Millis(), 10)), nil
+}
+
+// DumpFormat selects how DataBinary writes a slice of bytes.
+type DumpFormat int
+
+// Set of formats supported by DataBinary.
+const (
+	// DumpHex writes the bytes using HexDump.
+	DumpHex DumpFormat = iota
+
+	// DumpHexASCII writes the bytes using HexASCIIDump.
+	DumpHexASCII
+
+	// DumpBase64 writes the bytes using Base64Dump.
+	DumpBase64
+)
+
+// formatter returns the Formatter that writes the bytes in this format.
+func (f DumpFormat) formatter(b []byte) Formatter {
+	switch f {
+	case DumpHexASCII:
+		return HexASCIIDump(b)
+	case DumpBase64:
+		return Base64Dump(b)
+	default:
+		return HexDump(b)
+	}
+}
+
+// Safe returns a Formatter that writes the value like %+v does, but with
+// bounds so a huge or self-referential value can't hang the logger or use
+// up memory. Values nested deeper than maxDepth are written as "...",
+// a pointer, map or slice that refers back to a value being written is
+// written as <cycle TYPE>, and the output is cut at maxLen bytes. A limit
+// of 0 or less turns that limit off, but cycles are always detected.
+//
+//	log.DataTrace(context, "Update", log.Safe(cfg, 4, 1024))
+//
+// Methods like String and Error are not called since they can't be
+// bounded. Map keys are written in sorted order.
+func Safe(v interface{}, maxDepth int, maxLen int) Formatter {
+	return safeValue{v: v, maxDepth: maxDepth, maxLen: maxLen}
+}
+
+// safeValue implements the Formatter returned by Safe.
+type safeValue struct {
+	v        interface{}
+	maxDepth int
+	maxLen   int
+}
+
+// Format implements the Formatter interface.
+func (s safeValue) Format() string {
+	sw := safeWriter{maxDepth: s.maxDepth, maxLen: s.maxLen, seen: make(map[safeRef]bool)}
+	sw.value(reflect.ValueOf(s.v), 0)
+	if sw.full {
+		sw.buf.WriteString("...")
+	}
+
+	return sw.buf.String()
+}
+
+// safeWriter walks a value for Safe, keeping track of the pointers on
+// the current path to detect cycles.
+type safeWriter struct {
+	buf      bytes.Buffer
+	maxDepth int
+	maxLen   int
+	full     bool
+	seen     map[safeRef]bool
+}
+
+// safeRef identifies a value being written. The type is part of it since
+// a struct and its first field share an address.
+type safeRef struct {
+	p uintptr
+	t reflect.Type
+}
+
+// write adds the string to the output, cutting it at the length limit.
+func (sw *safeWriter) write(s string) {
+	if sw.full {
+		return
+	}
+
+	if sw.maxLen > 0 && sw.buf.Len()+len(s) > sw.maxLen {
+		s = s[:sw.maxLen-sw.buf.Len()]
+		sw.full = true
+	}
+	sw.buf.WriteString(s)
+}
+
+// deep reports whether the depth is past the depth limit.
+func (sw *safeWriter) deep(depth int) bool {
+	return sw.maxDepth > 0 && depth >= sw.maxDepth
+}
+
+// enter marks the pointer as being written. It returns false if it
+// already is, which means the value refers back to itself.
+func (sw *safeWriter) enter(v reflect.Value) bool {
+	p := safeRef{p: v.Pointer(), t: v.Type()}
+	if sw.seen[p] {
+		sw.write("<cycle " + v.Type().String() + ">")
+		return false
+	}
+	sw.seen[p] = true
+
+	return true
+}
+
+// leave marks the pointer as written.
+func (sw *safeWriter) leave(v reflect.Value) {
+	delete(sw.seen, safeRef{p: v.Pointer(), t: v.Type()})
+}
+
+// value writes the value at the specified depth.
+func (sw *safeWriter) value(v reflect.Value, depth int) {
+	if sw.full {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		sw.write("<nil>")
+
+	case reflect.Bool:
+		sw.write(strconv.FormatBool(v.Bool()))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sw.write(strconv.FormatInt(v.Int(), 10))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		sw.write(strconv.FormatUint(v.Uint(), 10))
+
+	case reflect.Float32, reflect.Float64:
+		sw.write(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+
+	case reflect.Complex64, reflect.Complex128:
+		sw.write(fmt.Sprint(v.Complex()))
+
+	case reflect.String:
+		sw.write(v.String())
+
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			sw.write("<nil>")
+			return
+		}
+		sw.write(fmt.Sprintf("(%s)(0x%x)", v.Type(), v.Pointer()))
+
+	case reflect.Interface:
+		if v.IsNil() {
+			sw.write("<nil>")
+			return
+		}
+		sw.value(v.Elem(), depth)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			sw.write("<nil>")
+			return
+		}
+		if sw.deep(depth) {
+			sw.write("&...")
+			return
+		}
+		if !sw.enter(v) {
+			return
+		}
+		sw.write("&")
+		sw.value(v.Elem(), depth+1)
+		sw.leave(v)
+
+	case reflect.Struct:
+		if sw.deep(depth) {
+			sw.write("{...}")
+			return
+		}
+		sw.write("{")
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				sw.write(" ")
+			}
+			sw.write(t.Field(i).Name + ":")
+			sw.value(v.Field(i), depth+1)
+		}
+		sw.write("}")
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			if !sw.enter(v) {
+				return
+			}
+			defer sw.leave(v)
+		}
+		if sw.deep(depth) && v.Len() > 0 {
+			sw.write("[...]")
+			return
+		}
+		sw.write("[")
+		for i := 0; i < v.Len() && !sw.full; i++ {
+			if i > 0 {
+				sw.write(" ")
+			}
+			sw.value(v.Index(i), depth+1)
+		}
+		sw.write("]")
+
+	case reflect.Map:
+		if v.Len() > 0 {
+			if !sw.enter(v) {
+				return
+			}
+			defer sw.leave(v)
+		}
+		if sw.deep(depth) && v.Len() > 0 {
+			sw.write("map[...]")
+			return
+		}
+		sw.mapValue(v, depth)
+
+	default:
+		sw.write("<" + v.Type().String() + ">")
+	}
+}
+
+// mapValue writes the entries of the map in key order. Each entry takes
+// at least a byte, so no more entries than the length limit are read from
+// a huge map.
+func (sw *safeWriter) mapValue(v reflect.Value, depth int) {
+	type entry struct {
+		key string
+		val reflect.Value
+	}
+
+	var entries []entry
+	for it := v.MapRange(); it.Next(); {
+		if sw.maxLen > 0 && len(entries) >= sw.maxLen {
+			break
+		}
+		kw := safeWriter{maxDepth: sw.maxDepth, maxLen: sw.maxLen, seen: sw.seen}
+		kw.value(it.Key(), depth+1)
+		entries = append(entries, entry{key: kw.buf.String(), val: it.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	sw.write("map[")
+	for i, e := range entries {
+		if sw.full {
+			break
+		}
+		if i > 0 {
+			sw.write(" ")
+		}
+		sw.write(e.key + ":")
+		sw.value(e.val, depth+1)
+	}
+	sw.write("]")
+}
+
+// valueFormatters holds the map[reflect.Type]func(interface{}) string of
+// formatters set with RegisterValueFormatter. It is copied on each change.
+var valueFormatters atomic.Value
+
+// valueFormattersMu serializes the changes to valueFormatters.
+var valueFormattersMu sync.Mutex
+
+// RegisterValueFormatter sets the function used to write values of the
+// type in DataKV, the Splunk pairs and the fields bound with WithFields, in
+// place of %v. A nil function removes the one set for the type. Values are
+// matched on their exact type, so a pointer type needs its own formatter.
+//
+//	log.RegisterValueFormatter(reflect.TypeOf(UserID(0)), func(v interface{}) string {
+//		return fmt.Sprintf("%#x", uint64(v.(UserID)))
+//	})
+func RegisterValueFormatter(t reflect.Type, f func(interface{}) string) {
+	valueFormattersMu.Lock()
+	defer valueFormattersMu.Unlock()
+
+	old, _ := valueFormatters.Load().(map[reflect.Type]func(interface{}) string)
+	m := make(map[reflect.Type]func(interface{}) string, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+
+	if f == nil {
+		delete(m, t)
+	} else {
+		m[t] = f
+	}
+	valueFormatters.Store(m)
+}
+
+// customValue formats the value with the formatter registered for its
+// type. It returns false when there is none.
+func customValue(v interface{}) (string, bool) {
+	m, _ := valueFormatters.Load().(map[reflect.Type]func(interface{}) string)
+	if len(m) == 0 || v == nil {
+		return "", false
+	}
+
+	f, ok := m[reflect.TypeOf(v)]
+	if !ok {
+		return "", false
+	}
+
+	return f(v), true
+}
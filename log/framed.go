@@ -0,0 +1,95 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// framedWriter writes each logical line as a length-prefixed frame.
+type framedWriter struct {
+	w io.Writer
+}
+
+// NewFramedWriter returns a device writer that writes each logical line
+// to w as a frame: a 4-byte big-endian length followed by the line without
+// its trailing newline. The indented lines of a DATA block are part of the
+// frame of the line before them, so a bulk write produces one frame per
+// trace line. ReadFrame reads the frames back.
+func NewFramedWriter(w io.Writer) io.Writer {
+	return framedWriter{w: w}
+}
+
+// Write implements the io.Writer interface.
+func (fw framedWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	var frame []byte
+
+	writeFrame := func() {
+		if frame == nil {
+			return
+		}
+		frame = bytes.TrimSuffix(frame, []byte("\n"))
+
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(frame)))
+		buf.Write(n[:])
+		buf.Write(frame)
+		frame = nil
+	}
+
+	for rest := p; len(rest) > 0; {
+		ln := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			ln = rest[:i+1]
+		}
+		rest = rest[len(ln):]
+
+		if frame == nil || ln[0] != '\t' {
+			writeFrame()
+		}
+		frame = append(frame, ln...)
+	}
+	writeFrame()
+
+	if _, err := fw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ReadFrame reads a frame written by a framed writer and returns the line.
+// It returns io.EOF when there are no more frames.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint32(n[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,110 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package httplog writes HTTP requests and responses into the trace as
+// DATA blocks.
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/Comcast/go-log/log"
+)
+
+// RedactedHeaders is the set of headers whose values are never written.
+var RedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// redacted is written in place of the value of a redacted header.
+const redacted = "[REDACTED]"
+
+// DataHTTPRequest is used to write the method, URL, headers and body length
+// of a request into the trace as a block of data. The password in the URL
+// is redacted. The request body is not read.
+func DataHTTPRequest(context interface{}, function string, r *http.Request) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "method: %s\n", r.Method)
+	fmt.Fprintf(&buf, "url: %s\n", redactURL(r.URL))
+	writeHeaders(&buf, r.Header)
+	writeLength(&buf, r.ContentLength)
+
+	log.Up1.DataString(context, function, buf.String())
+}
+
+// DataHTTPResponse is used to write the status, URL, headers and body
+// length of a response into the trace as a block of data. The password in
+// the URL is redacted. The response body is not read.
+func DataHTTPResponse(context interface{}, function string, resp *http.Response) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "status: %s\n", resp.Status)
+	if resp.Request != nil {
+		fmt.Fprintf(&buf, "url: %s\n", redactURL(resp.Request.URL))
+	}
+	writeHeaders(&buf, resp.Header)
+	writeLength(&buf, resp.ContentLength)
+
+	log.Up1.DataString(context, function, buf.String())
+}
+
+// redactURL returns the URL with the password replaced by "xxxxx".
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// writeHeaders writes the headers in name order, one value per line.
+func writeHeaders(buf *bytes.Buffer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range h[name] {
+			if isRedacted(name) {
+				v = redacted
+			}
+			fmt.Fprintf(buf, "%s: %s\n", name, v)
+		}
+	}
+}
+
+// writeLength writes the body length, which is -1 when it is unknown.
+func writeLength(buf *bytes.Buffer, n int64) {
+	if n < 0 {
+		buf.WriteString("body length: unknown\n")
+		return
+	}
+	fmt.Fprintf(buf, "body length: %d\n", n)
+}
+
+// isRedacted reports whether the value of the header must not be written.
+func isRedacted(name string) bool {
+	for _, r := range RedactedHeaders {
+		if http.CanonicalHeaderKey(r) == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+	return false
+}
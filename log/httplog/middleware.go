@@ -0,0 +1,156 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package httplog
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Comcast/go-log/log"
+)
+
+// statusWriter wraps a http.ResponseWriter to capture the status
+// code and the number of bytes written for the access log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader captures the status code before passing it on.
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the bytes written before passing them on.
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// Unwrap returns the original http.ResponseWriter so http.ResponseController
+// can reach its optional interfaces.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// flush sends the buffered data, which writes the header with an implicit
+// 200 if none was written.
+func (sw *statusWriter) flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	sw.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijack takes over the connection, which is logged as switching
+// protocols if no status was written.
+func (sw *statusWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := sw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && sw.status == 0 {
+		sw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// flushWriter is a statusWriter for a writer that can flush.
+type flushWriter struct{ *statusWriter }
+
+// Flush implements the http.Flusher interface.
+func (w flushWriter) Flush() { w.flush() }
+
+// hijackWriter is a statusWriter for a writer that can be hijacked.
+type hijackWriter struct{ *statusWriter }
+
+// Hijack implements the http.Hijacker interface.
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+// flushHijackWriter is a statusWriter for a writer that can flush and be
+// hijacked.
+type flushHijackWriter struct{ *statusWriter }
+
+// Flush implements the http.Flusher interface.
+func (w flushHijackWriter) Flush() { w.flush() }
+
+// Hijack implements the http.Hijacker interface.
+func (w flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+// wrap returns the statusWriter with the same optional interfaces as the
+// writer it wraps, so streaming responses and protocol upgrades still
+// work in the handler.
+func wrap(sw *statusWriter) http.ResponseWriter {
+	_, canFlush := sw.ResponseWriter.(http.Flusher)
+	_, canHijack := sw.ResponseWriter.(http.Hijacker)
+
+	switch {
+	case canFlush && canHijack:
+		return flushHijackWriter{sw}
+	case canFlush:
+		return flushWriter{sw}
+	case canHijack:
+		return hijackWriter{sw}
+	}
+
+	return sw
+}
+
+// Middleware wraps a handler and writes a single Splunk line for each
+// request with the method, path, status, bytes written and duration. A
+// request whose handler panics is still written, with a status of 500 if
+// none was written, and the panic is passed on.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := statusWriter{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+
+			// A handler that writes nothing gets an implicit 200, or
+			// the 500 the server answers with when it panics.
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+				if p != nil {
+					sw.status = http.StatusInternalServerError
+				}
+			}
+
+			log.Splunk(
+				log.SplunkPair{Key: "method", Value: r.Method},
+				log.SplunkPair{Key: "path", Value: r.URL.Path},
+				log.SplunkPair{Key: "status", Value: sw.status},
+				log.SplunkPair{Key: "bytes", Value: sw.bytes},
+				log.SplunkPair{Key: "duration", Value: time.Since(start)},
+			)
+
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		next.ServeHTTP(wrap(&sw), r)
+	})
+}
@@ -0,0 +1,191 @@
+//go:build linux
+
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journalSocket is the socket journald reads native protocol entries from.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journalPriorities maps the tag of a trace line, verbose or compact, to
+// its syslog priority.
+var journalPriorities = map[string]string{
+	"TERMINATING":     "2",
+	"ERROR":           "3",
+	"E":               "3",
+	"Completed ERROR": "3",
+	"X":               "3",
+	"Warning":         "4",
+	"W":               "4",
+	"Started":         "7",
+	"S":               "7",
+	"Completed":       "7",
+	"C":               "7",
+	"Trace":           "7",
+	"T":               "7",
+	"Query":           "7",
+	"Q":               "7",
+	"DATA":            "7",
+	"D":               "7",
+}
+
+// journaldWriter sends each trace line to journald as an entry.
+type journaldWriter struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// journalEntry holds the fields of a trace line.
+type journalEntry struct {
+	fields [][2]string
+	msg    string
+}
+
+// NewJournaldWriter returns a device writer that sends each line to
+// journald using its native protocol, so the parts of a trace line can be
+// filtered on with journalctl:
+//
+//	MESSAGE            the message after the tag
+//	PRIORITY           3 for ERROR, 4 for Warning, 2 for TERMINATING and 7 for the rest
+//	SYSLOG_IDENTIFIER  the prefix set with Init
+//	CODE_FILE          the file
+//	CODE_LINE          the line number
+//	CONTEXT            the context
+//	GO_FUNC            the function
+//	TAG                the tag
+//
+// The indented lines of a DATA block are added to the MESSAGE of the line
+// before them. Lines that are not trace lines, like Splunk and Event lines,
+// are sent whole as the MESSAGE with a PRIORITY of 6. An error is returned
+// when the journald socket can't be reached. Entries larger than a
+// datagram the socket accepts fail to write. It is only built on Linux.
+func NewJournaldWriter() (io.Writer, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journaldWriter{conn: conn}, nil
+}
+
+// Write implements the io.Writer interface.
+func (jw *journaldWriter) Write(p []byte) (int, error) {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+
+	var entries []*journalEntry
+	for _, ln := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if ln == "" {
+			continue
+		}
+
+		// The indented lines of a DATA block belong to the line before.
+		if ln[0] == '\t' && len(entries) > 0 {
+			e := entries[len(entries)-1]
+			if e.msg != "" {
+				e.msg += "\n"
+			}
+			e.msg += ln[1:]
+			continue
+		}
+
+		entries = append(entries, parseJournalEntry(ln))
+	}
+
+	for _, e := range entries {
+		if _, err := jw.conn.Write(e.encode()); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// parseJournalEntry splits a trace line into journald fields.
+func parseJournalEntry(ln string) *journalEntry {
+	parts := strings.SplitN(ln, ": ", 6)
+	if len(parts) < 6 {
+		return &journalEntry{fields: [][2]string{{"PRIORITY", "6"}}, msg: ln}
+	}
+
+	tag, msg := parts[5], ""
+	if i := strings.Index(tag, ":"); i >= 0 {
+		tag, msg = tag[:i], strings.TrimPrefix(tag[i+1:], " ")
+	}
+
+	priority, ok := journalPriorities[tag]
+	if !ok {
+		priority = "6"
+	}
+
+	e := journalEntry{msg: msg}
+	e.fields = append(e.fields, [2]string{"PRIORITY", priority})
+
+	if i := strings.IndexByte(parts[1], '['); i > 0 {
+		e.fields = append(e.fields, [2]string{"SYSLOG_IDENTIFIER", parts[1][:i]})
+	}
+
+	file := parts[2]
+	if i := strings.LastIndexByte(file, '#'); i >= 0 {
+		e.fields = append(e.fields, [2]string{"CODE_FILE", file[:i]}, [2]string{"CODE_LINE", file[i+1:]})
+	}
+
+	e.fields = append(e.fields,
+		[2]string{"CONTEXT", parts[3]},
+		[2]string{"GO_FUNC", strings.TrimLeft(parts[4], " ")},
+		[2]string{"TAG", tag},
+	)
+
+	return &e
+}
+
+// encode writes the entry in the journald native protocol. Values with a
+// newline are written with their length in front of them.
+func (e *journalEntry) encode() []byte {
+	var buf bytes.Buffer
+
+	write := func(key, value string) {
+		buf.WriteString(key)
+		if !strings.Contains(value, "\n") {
+			buf.WriteByte('=')
+			buf.WriteString(value)
+			buf.WriteByte('\n')
+			return
+		}
+
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+
+	write("MESSAGE", e.msg)
+	for _, f := range e.fields {
+		write(f[0], f[1])
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournaldWriter(t *testing.T) {
+	t.Log("Given the need to send trace lines to journald as structured entries.")
+	{
+		dir, err := os.MkdirTemp("", "journal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		defer func(s string) { journalSocket = s }(journalSocket)
+		journalSocket = filepath.Join(dir, "socket")
+
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		w, err := NewJournaldWriter()
+		if err != nil {
+			t.Fatalf("\tShould connect to the socket. %s %v", failed, err)
+		}
+
+		io.WriteString(w, "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A\n"+
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta\n\tb\n"+
+			"2009/11/10 15:00:00.000000000: k=v\n")
+
+		exp := []string{
+			"MESSAGE=A\nPRIORITY=3\nSYSLOG_IDENTIFIER=LOG\nCODE_FILE=file.go\nCODE_LINE=512\nCONTEXT=TEST\nGO_FUNC=foo\nTAG=ERROR\n",
+			"MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\nPRIORITY=7\nSYSLOG_IDENTIFIER=LOG\nCODE_FILE=file.go\nCODE_LINE=512\nCONTEXT=TEST\nGO_FUNC=foo\nTAG=DATA\n",
+			"MESSAGE=2009/11/10 15:00:00.000000000: k=v\nPRIORITY=6\n",
+		}
+
+		b := make([]byte, 4096)
+		for i, e := range exp {
+			n, err := conn.Read(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b[:n]); got == e {
+				t.Logf("\tShould send entry %d with its fields. %s", i, succeed)
+			} else {
+				t.Errorf("\tShould send entry %d with its fields. %s %q", i, failed, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,811 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// emit writes the trace line to the device unless sampling or the filter
+// drops it.
+func emit(dev int8, context interface{}, function string, format string, a ...interface{}) {
+	w := kept(dev, context)
+	if w == nil {
+		return
+	}
+
+	if f, ok := filter.Load().(func(Entry) bool); ok && f != nil {
+		ln := fmt.Sprintf(format, a...)
+		if !f(Entry{Device: dev, Context: context, Function: function, Line: ln}) {
+			return
+		}
+		countTag(dev)
+		outputLine(w, 0, immediate(dev), "%s", ln)
+		return
+	}
+
+	countTag(dev)
+	outputLine(w, 0, immediate(dev), format, a...)
+}
+
+// kept returns the writer of the device, or nil when the device isn't set
+// or sampling drops the line.
+func kept(dev int8, context interface{}) io.Writer {
+	w := Dev.get(dev)
+	if w == nil || sampled(dev) || contextSampled(dev, context) {
+		return nil
+	}
+
+	return w
+}
+
+// passes reports whether the filter set with SetFilter lets the line
+// through.
+func passes(dev int8, context interface{}, function string, ln string) bool {
+	f, ok := filter.Load().(func(Entry) bool)
+	return !ok || f == nil || f(Entry{Device: dev, Context: context, Function: function, Line: ln})
+}
+
+// compactTags maps each tag to the character written by SetCompactTags.
+var compactTags = map[string]string{
+	"Started":         "S",
+	"Completed":       "C",
+	"Completed ERROR": "X",
+	"ERROR":           "E",
+	"Warning":         "W",
+	"Trace":           "T",
+	"Query":           "Q",
+	"DATA":            "D",
+}
+
+// lineTag returns the tag to write, compacted when SetCompactTags is on.
+func lineTag(tag string) string {
+	if atomic.LoadInt32(&l.compactTags) == 1 {
+		if c, ok := compactTags[tag]; ok {
+			return c
+		}
+	}
+
+	return tag
+}
+
+// emitLine writes a trace line of a logging call with the tag and the
+// message. It is only called by the logging calls, so the var segment is
+// taken from there.
+func (u uplevel) emitLine(dev int8, dt string, pid int, file string, context interface{}, funcName string, tag string, message string) {
+	writeLine(dev, dt, pid, file, context, funcName, tag, message, u.pairs(0))
+}
+
+// writeLine writes a trace line with the tag, the message and the fields
+// of its var segment. The depth of the context only moves for a line that
+// is written.
+func writeLine(dev int8, dt string, pid int, file string, context interface{}, funcName string, tag string, message string, pairs []SplunkPair) {
+	w := kept(dev, context)
+	if w == nil {
+		return
+	}
+
+	indent, depth := nesting(context, tag)
+	f := lineFields(dt, pid, file, context, indent+funcName, lineTag(tag), message, pairs)
+	ln := formatLine(f)
+	if !passes(dev, context, funcName, ln) {
+		return
+	}
+
+	moveDepth(context, depth)
+	countTag(dev)
+	outputLine(w, 0, immediate(dev), "%s", ln)
+}
+
+// emitTerminating writes the TERMINATING line that follows a fatal error.
+func emitTerminating(dev int8, dt string, pid int, file string, context interface{}, funcName string) {
+	writeLine(dev, dt, pid, file, context, funcName, "TERMINATING", "", nil)
+}
+
+// dataLine returns a DATA line with the non-empty lines of its block
+// after the header. It is only called by the logging calls, so the var
+// segment is taken from there.
+func (u uplevel) dataLine(dt string, pid int, file string, context interface{}, funcName string, lines [][]byte) string {
+	indent, _ := nesting(context, "DATA")
+	f := lineFields(dt, pid, file, context, indent+funcName, lineTag("DATA"), "", u.pairs(0))
+
+	lines = dataBlockLines(lines)
+	f.Data = make([]string, len(lines))
+	for i, ln := range lines {
+		f.Data[i] = string(ln)
+	}
+
+	return formatLine(f)
+}
+
+// dataBytes returns the lines of a data block as byte slices.
+func dataBytes(data []string) [][]byte {
+	lines := make([][]byte, len(data))
+	for i, ln := range data {
+		lines[i] = []byte(ln)
+	}
+
+	return lines
+}
+
+// contextSegment returns the context followed by its separator, or nothing
+// for NoContext.
+func contextSegment(context interface{}) string {
+	if context == NoContext {
+		return ""
+	}
+
+	return fmt.Sprintf("%v: ", context)
+}
+
+// uplevel builds and writes the trace lines for all of the logging calls.
+// It carries the stack frame level along with the var segment bound to
+// a Logger with WithFields.
+type uplevel struct {
+	lvl  Uplevel
+	vars []SplunkPair
+}
+
+// pairs returns the fields of the var segment, the host and pkg fields in
+// front of the bound ones. The caller is found the same number of frames
+// up as dtFile finds it, skip is the number of frames between the caller
+// of pairs and the logging call, -1 when it is the logging call.
+func (u uplevel) pairs(skip int) []SplunkPair {
+	var front []SplunkPair
+	if host := hostName(); host != "" {
+		front = append(front, SplunkPair{Key: "host", Value: host})
+	}
+	if atomic.LoadInt32(&l.includePkg) == 1 {
+		front = append(front, SplunkPair{Key: "pkg", Value: callerPackage(4 + skip + int(u.lvl))})
+	}
+	if front == nil {
+		return u.vars
+	}
+
+	return append(front, u.vars...)
+}
+
+// formatVars writes the fields of the var segment in the style set with
+// SetVarStyle.
+func formatVars(vars []SplunkPair) string {
+	if len(vars) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+
+	switch VarStyle(atomic.LoadInt32(&l.varStyle)) {
+	case VarEquals:
+		for i, kv := range vars {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(kv.Key)
+			buf.WriteByte('=')
+			buf.WriteString(splunkEncode(kv.Value))
+		}
+
+	case VarJSON:
+		writeJSONPairs(&buf, vars)
+
+	default:
+		for i, kv := range vars {
+			if i > 0 {
+				buf.WriteString(": ")
+			}
+			v := kv.Value
+			if cv, ok := customValue(v); ok {
+				v = cv
+			}
+			fmt.Fprintf(&buf, "%s[%v]", kv.Key, v)
+		}
+	}
+
+	return buf.String()
+}
+
+// writeJSONPairs writes the pairs as a JSON object, in order. Errors are
+// written as their message and values that can't be encoded with %v.
+func writeJSONPairs(buf *bytes.Buffer, vars []SplunkPair) {
+	buf.WriteByte('{')
+	for i, kv := range vars {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSON(buf, kv.Key)
+		buf.WriteByte(':')
+
+		v := kv.Value
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if cv, ok := customValue(v); ok {
+			v = cv
+		}
+		if writeJSON(buf, v) != nil {
+			writeJSON(buf, fmt.Sprintf("%v", v))
+		}
+	}
+	buf.WriteByte('}')
+}
+
+// joinVars places the var segment in front of the message.
+func joinVars(vars string, message string) string {
+	if vars == "" {
+		return message
+	}
+	if message == "" {
+		return vars
+	}
+
+	return vars + ": " + message
+}
+
+// with returns a copy with the pairs added to the end of the var segment.
+func (u uplevel) with(pairs []SplunkPair) uplevel {
+	vars := make([]SplunkPair, 0, len(u.vars)+len(pairs))
+	vars = append(vars, u.vars...)
+
+	return uplevel{u.lvl, append(vars, pairs...)}
+}
+
+// Start is used for the entry into a function.
+func (u uplevel) Start(context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevStart, dt, pid, file, context, funcName, "Started", "")
+}
+
+// Startf is used for the entry into a function with a formatted message.
+func (u uplevel) Startf(context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevStart, dt, pid, file, context, funcName, "Started", sprintf(format, a...))
+}
+
+// StartArgs is used for the entry into a function with its arguments
+// written as pairs in the var segment, in order.
+func (u uplevel) StartArgs(context interface{}, function string, args ...SplunkPair) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.with(args).emitLine(DevStart, dt, pid, file, context, funcName, "Started", "")
+}
+
+// Complete is used for the exit of a function.
+func (u uplevel) Complete(context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevStart, dt, pid, file, context, funcName, "Completed", "")
+}
+
+// TraceEnter is used for the entry into a function. It writes the Started
+// line and returns a function that writes the Completed line, with the
+// values passed to it written as a list like [42, <nil>]. Both lines carry
+// the file and line of the call to TraceEnter and the var segment as it
+// was then.
+//
+//	defer log.TraceEnter(ctx, "Load")()
+func (u uplevel) TraceEnter(context interface{}, function string) func(...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	pairs := u.pairs(-1)
+	writeLine(DevStart, dt, pid, file, context, funcName, "Started", "", pairs)
+
+	return func(results ...interface{}) {
+		dt, _, _, pid := dtFile(1, funcName)
+
+		var message string
+		if len(results) > 0 {
+			message = SplunkValue(results).String()
+		}
+
+		writeLine(DevStart, dt, pid, file, context, funcName, "Completed", message, pairs)
+	}
+}
+
+// Completef is used for the exit of a function with a formatted message.
+func (u uplevel) Completef(context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevStart, dt, pid, file, context, funcName, "Completed", sprintf(format, a...))
+}
+
+// CompleteReturns is used for the exit of a function with its return
+// values written as pairs in the var segment, in order.
+func (u uplevel) CompleteReturns(context interface{}, function string, returns ...SplunkPair) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.with(returns).emitLine(DevStart, dt, pid, file, context, funcName, "Completed", "")
+}
+
+// CompleteErr is used to write an error with complete into the trace.
+func (u uplevel) CompleteErr(err error, context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "Completed ERROR", fmt.Sprintf("%s", err))
+}
+
+// CompleteErrf is used to write an error with complete into the trace with a formatted message.
+func (u uplevel) CompleteErrf(err error, context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "Completed ERROR", sprintf(format, a...)+": "+fmt.Sprintf("%s", err))
+}
+
+// Err is used to write an error into the trace.
+func (u uplevel) Err(err error, context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "ERROR", fmt.Sprintf("%s", err))
+}
+
+// Errf is used to write an error into the trace with a formatted message.
+func (u uplevel) Errf(err error, context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "ERROR", sprintf(format, a...)+": "+fmt.Sprintf("%s", err))
+}
+
+// WrapErr is used to write an error into the trace and return it, so an
+// error can be logged and returned in one line. A nil error writes nothing
+// and returns nil.
+func (u uplevel) WrapErr(err error, context interface{}, function string) error {
+	if err == nil {
+		return nil
+	}
+
+	uplevel{u.lvl + 1, u.vars}.Err(err, context, function)
+	return err
+}
+
+// WrapErrf is used to write an error into the trace with a formatted
+// message and return it annotated with the message, like
+// fmt.Errorf("message: %w", err). A nil error writes nothing and returns
+// nil.
+func (u uplevel) WrapErrf(err error, context interface{}, function string, format string, a ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	uplevel{u.lvl + 1, u.vars}.Errf(err, context, function, format, a...)
+	return wrapErr(err, format, a...)
+}
+
+// wrapErr returns the error annotated with the formatted message, or nil
+// for a nil error.
+func wrapErr(err error, format string, a ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, a...), err)
+}
+
+// ErrFatal is used to write an error into the trace then terminate the program.
+func (u uplevel) ErrFatal(err error, context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "ERROR", fmt.Sprintf("%s", err))
+	emitTerminating(DevError, dt, pid, file, context, funcName)
+	// Wait for every device, not just DevError, so lines that are still
+	// buffered are not lost when the program exits.
+	Flush()
+	Shutdown()
+	os.Exit(1)
+}
+
+// ErrFatalf is used to write an error into the trace with a formatted message then terminate the program.
+func (u uplevel) ErrFatalf(err error, context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevError, dt, pid, file, context, funcName, "ERROR", sprintf(format, a...)+": "+fmt.Sprintf("%s", err))
+	emitTerminating(DevError, dt, pid, file, context, funcName)
+	// Wait for every device, not just DevError, so lines that are still
+	// buffered are not lost when the program exits.
+	Flush()
+	Shutdown()
+	os.Exit(1)
+}
+
+// ErrPanic is used to write an error into the trace then panic the program.
+func (u uplevel) ErrPanic(err error, context interface{}, function string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevPanic, dt, pid, file, context, funcName, "ERROR", fmt.Sprintf("%s", err))
+	emitTerminating(DevPanic, dt, pid, file, context, funcName)
+	// Wait for every device, not just DevPanic, so lines that are still
+	// buffered are not lost when the program panics.
+	Flush()
+	if atomic.LoadInt32(&l.panicAsError) == 1 {
+		return
+	}
+	Shutdown()
+	panic("Terminating Program")
+}
+
+// ErrPanicf is used to write an error into the trace with a formatted message then panic the program.
+func (u uplevel) ErrPanicf(err error, context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevPanic, dt, pid, file, context, funcName, "ERROR", sprintf(format, a...)+": "+fmt.Sprintf("%s", err))
+	emitTerminating(DevPanic, dt, pid, file, context, funcName)
+	// Wait for every device, not just DevPanic, so lines that are still
+	// buffered are not lost when the program panics.
+	Flush()
+	if atomic.LoadInt32(&l.panicAsError) == 1 {
+		return
+	}
+	Shutdown()
+	panic("Terminating Program")
+}
+
+// Tracef is used to write information into the trace with a formatted message.
+func (u uplevel) Tracef(context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevTrace, dt, pid, file, context, funcName, "Trace", sprintf(format, a...))
+}
+
+// Trace0 is used to write information into the trace using the context
+// set by PushContext and the name of the calling function.
+func (u uplevel) Trace0(message string) {
+	uplevel{u.lvl + 1, u.vars}.Tracef(pushedContext(), "", "%s", message)
+}
+
+// TraceWithBlob is used to write information into the trace with a blob of
+// data stored in its own file in the directory set by SetBlobDir. The line
+// includes blob[ref] and the ref is returned.
+func (u uplevel) TraceWithBlob(context interface{}, function string, message string, blob []byte) string {
+	ref, err := writeBlob(blob)
+	if err != nil {
+		uplevel{u.lvl + 1, u.vars}.Tracef(context, function, "%s: blob ERROR: %s", message, err)
+		return ""
+	}
+
+	uplevel{u.lvl + 1, u.vars}.Tracef(context, function, "%s: blob[%s]", message, ref)
+	return ref
+}
+
+// TraceObject is used to write the exported fields of a struct into the
+// trace as Field=value pairs on a single line, with the values quoted like
+// DataKVQuoted. Unexported fields are skipped and the fields of embedded
+// structs are written as if they were the struct's own, one level deep.
+// Fields that are structs, maps or slices are written with %v, so for
+// anything large or nested DataBlock is a better fit. A value that is not
+// a struct is written as value=...
+//
+//	Trace: ID=7 Name="Jane Doe" Admin=false
+func (u uplevel) TraceObject(context interface{}, function string, obj interface{}) {
+	uplevel{u.lvl + 1, u.vars}.Tracef(context, function, "%s", objectPairs(obj))
+}
+
+// Section is used to write a separator line with a title to the trace
+// device, to mark the start of a phase of a long run for whoever reads the
+// log. The line has no context, function or tag and is as wide as set with
+// SetSectionWidth.
+//
+//	... file.go#512: ==== loading ===========================================
+func (u uplevel) Section(title string) {
+	dt, file, _, pid := dtFile(2+int(u.lvl), "Section")
+	emit(DevTrace, NoContext, "", "%s: %s[%d]: %s: %s\n", dt, appPrefix(), pid, file, sectionLine(title))
+}
+
+// sectionLine returns the separator for the title padded with = to the
+// section width. A long title is still followed by a few.
+func sectionLine(title string) string {
+	width := int(atomic.LoadInt32(&l.sectionWidth))
+	if width == 0 {
+		width = 80
+	}
+
+	if title == "" {
+		return strings.Repeat("=", width)
+	}
+
+	s := "==== " + title + " "
+	pad := width - utf8.RuneCountInString(s)
+	if pad < 4 {
+		pad = 4
+	}
+
+	return s + strings.Repeat("=", pad)
+}
+
+// objectPairs returns the exported fields of the struct as Field=value
+// pairs separated by spaces.
+func objectPairs(obj interface{}) string {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return "value=" + logfmtQuote(obj)
+	}
+
+	var pairs []string
+
+	var add func(v reflect.Value, embedded bool)
+	add = func(v reflect.Value, embedded bool) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fv := v.Field(i)
+
+			if f.Anonymous && !embedded {
+				ev := fv
+				if ev.Kind() == reflect.Ptr && !ev.IsNil() {
+					ev = ev.Elem()
+				}
+				if ev.Kind() == reflect.Struct {
+					add(ev, true)
+					continue
+				}
+			}
+
+			if f.PkgPath != "" || !fv.CanInterface() {
+				continue
+			}
+			pairs = append(pairs, f.Name+"="+logfmtQuote(fv.Interface()))
+		}
+	}
+	add(v, false)
+
+	return strings.Join(pairs, " ")
+}
+
+// Warnf is used to write a warning into the trace with a formatted message.
+func (u uplevel) Warnf(context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevWarning, dt, pid, file, context, funcName, "Warning", sprintf(format, a...))
+}
+
+// Queryf is used to write a query into the trace with a formatted message.
+func (u uplevel) Queryf(context interface{}, function string, format string, a ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevQuery, dt, pid, file, context, funcName, "Query", sprintf(format, a...))
+}
+
+// DataKV is used to write a key/value pair into the trace.
+func (u uplevel) DataKV(context interface{}, function string, key string, value interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevData, dt, pid, file, context, funcName, "DATA", key+": "+dataValue(value))
+}
+
+// DataKVQuoted is used to write a key/value pair into the trace with the
+// value quoted and escaped like logfmt when it contains whitespace or
+// special characters.
+func (u uplevel) DataKVQuoted(context interface{}, function string, key string, value interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevData, dt, pid, file, context, funcName, "DATA", key+": "+logfmtQuote(value))
+}
+
+// DataList is used to write a list of values into the trace as
+// key: [a, b, c], formatted the same way as a SplunkValue. Since the values
+// are a SplunkValue the same list is a JSON array in an Event.
+func (u uplevel) DataList(context interface{}, function string, key string, values ...interface{}) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+	u.emitLine(DevData, dt, pid, file, context, funcName, "DATA", key+": "+SplunkValue(values).String())
+}
+
+// dataValue formats a value for DataKV. A value with a formatter set by
+// RegisterValueFormatter is written with it, a time.Duration is written in the
+// unit set with SetDurationUnit and a time.Time like the timestamp at the
+// start of the line. Everything else is written with %v.
+func dataValue(value interface{}) string {
+	if s, ok := customValue(value); ok {
+		return s
+	}
+
+	switch v := value.(type) {
+	case time.Duration:
+		return formatDuration(v)
+	case time.Time:
+		return formatTime(v)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// logfmtQuote formats the value like dataValue and quotes it when it is
+// empty or contains whitespace, quotes, '=', ':' or characters that are not
+// printable.
+func logfmtQuote(value interface{}) string {
+	s := dataValue(value)
+
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '=' || r == ':' || r == utf8.RuneError || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// DataGroup is used to collect key/value pairs that are written into the
+// trace as a single data block when Emit is called.
+func (u uplevel) DataGroup(context interface{}, function string) *Group {
+	return &Group{
+		u:        u,
+		context:  context,
+		function: function,
+	}
+}
+
+// DataBlock is used to write a block of data into the trace.
+func (u uplevel) DataBlock(context interface{}, function string, block interface{}) {
+	if v, ok := block.(string); ok {
+		uplevel{u.lvl + 1, u.vars}.DataString(context, function, v)
+		return
+	}
+
+	// There is no point indenting JSON that will be folded onto one line.
+	var d []byte
+	var err error
+	if atomic.LoadInt32(&l.dataSingleLine) == 1 {
+		d, err = json.Marshal(block)
+	} else {
+		d, err = json.MarshalIndent(block, "", "    ")
+	}
+	if err != nil {
+		switch MarshalErrorBehavior(atomic.LoadInt32(&l.dataMarshal)) {
+		case MarshalErrorWarn:
+			uplevel{u.lvl + 1, u.vars}.Warnf(context, function, "DataBlock: %s", err)
+			return
+		case MarshalErrorSkip:
+			return
+		}
+		d = []byte(err.Error())
+	}
+
+	uplevel{u.lvl + 1, u.vars}.DataString(context, function, string(d))
+}
+
+// DataString is used to write a string with CRLF each on their own line.
+func (u uplevel) DataString(context interface{}, function string, message string) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+
+	if message == "" {
+		u.emitLine(DevData, dt, pid, file, context, funcName, "DATA", "%!ds(MISSING)")
+		return
+	}
+
+	ln := u.dataLine(dt, pid, file, context, funcName, bytes.Split([]byte(message), []byte{'\n'}))
+
+	emit(DevData, context, funcName, "%s", ln)
+}
+
+// DataTrace is used to write a block of data from an io.Stringer respecting each line.
+func (u uplevel) DataTrace(context interface{}, function string, formatters ...Formatter) {
+	dt, file, funcName, pid := dtFile(2+int(u.lvl), function)
+
+	var lines [][]byte
+	for _, f := range formatters {
+		if f != nil {
+			lines = append(lines, bytes.Split([]byte(f.Format()), []byte{'\n'})...)
+		}
+	}
+
+	message := u.dataLine(dt, pid, file, context, funcName, lines)
+	if message == "" {
+		emit(DevData, context, funcName, "%s", "\t%%!ds(MISSING)\n")
+		return
+	}
+
+	emit(DevData, context, funcName, "%s", message)
+}
+
+// dataBlockLines returns the non-empty lines of a data block, cut to the
+// limit set with SetMaxDataLines.
+func dataBlockLines(lines [][]byte) [][]byte {
+	lines, more := dataLineLimit(lines)
+	if more > 0 {
+		lines = append(lines, []byte(fmt.Sprintf("... (%d more lines omitted)", more)))
+	}
+
+	return lines
+}
+
+// writeDataLines writes the lines of a data block after the header. Each
+// line is indented on its own line unless single line data is on, then
+// they are folded onto the header line separated by \n.
+func writeDataLines(buf *bytes.Buffer, lines [][]byte) {
+	if atomic.LoadInt32(&l.dataSingleLine) == 1 {
+		sep := " "
+		for _, line := range lines {
+			buf.WriteString(sep)
+			buf.Write(line)
+			sep = `\n`
+		}
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	for _, line := range lines {
+		fmt.Fprintf(buf, "\t%s\n", line)
+	}
+}
+
+// dataLineLimit drops the empty lines of a data block and returns the lines
+// that can be written under SetMaxDataLines and how many are left over.
+func dataLineLimit(lines [][]byte) (keep [][]byte, more int) {
+	max := int(atomic.LoadInt32(&l.maxDataLines))
+
+	keep = make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if max > 0 && len(keep) == max {
+			more++
+			continue
+		}
+		keep = append(keep, line)
+	}
+
+	return keep, more
+}
+
+// DataReader is used to write up to maxBytes read from r into the trace as
+// a block of data. Content that is not valid UTF-8 is written as a hex dump.
+// A maxBytes of 0 or less reads all of r.
+func (u uplevel) DataReader(context interface{}, function string, r io.Reader, maxBytes int) {
+	if maxBytes > 0 {
+		r = io.LimitReader(r, int64(maxBytes)+1)
+	}
+	b, err := ioutil.ReadAll(r)
+
+	truncated := maxBytes > 0 && len(b) > maxBytes
+	if truncated {
+		b = trimSplitRune(b[:maxBytes])
+	}
+
+	var buf bytes.Buffer
+	if utf8.Valid(b) {
+		buf.Write(b)
+	} else {
+		buf.WriteString(HexDump(b).Format())
+	}
+	buf.WriteByte('\n')
+
+	if err != nil {
+		fmt.Fprintf(&buf, "READ ERROR: %s\n", err)
+	}
+	if truncated {
+		fmt.Fprintf(&buf, "TRUNCATED: more than %d bytes\n", maxBytes)
+	}
+
+	uplevel{u.lvl + 1, u.vars}.DataString(context, function, buf.String())
+}
+
+// trimSplitRune drops the start of a rune left at the end of b by a cut,
+// so text cut in the middle of a rune is still valid UTF-8.
+func trimSplitRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+
+	return b
+}
+
+// DataBinary is used to write a slice of bytes into the trace as a block
+// of data using the specified dump format.
+func (u uplevel) DataBinary(context interface{}, function string, b []byte, format DumpFormat) {
+	uplevel{u.lvl + 1, u.vars}.DataTrace(context, function, format.formatter(b))
+}
@@ -17,11 +17,15 @@
 package log
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -42,8 +46,30 @@ type Formatter interface {
 // line is passed to the safe write goroutine
 // as the string to write to the device.
 type line struct {
-	w io.Writer
-	b []byte
+	w   io.Writer
+	b   *bytes.Buffer
+	pri int
+	now bool
+}
+
+// linePool recycles the buffers used to build lines. A buffer is returned
+// to the pool by the safe write goroutine once it has been copied into the
+// bulk buffer for its device, or by output if it was never sent.
+var linePool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledLine is the largest buffer kept in the pool so a single large
+// DATA block doesn't pin its memory.
+const maxPooledLine = 64 << 10
+
+// putLine returns a line buffer to the pool.
+func putLine(b *bytes.Buffer) {
+	if b.Cap() > maxPooledLine {
+		return
+	}
+	b.Reset()
+	linePool.Put(b)
 }
 
 // logger maintains internal state for our logger.
@@ -55,31 +81,94 @@ type logger struct {
 	wg           sync.WaitGroup
 	write        chan line
 	exit         chan struct{}
+	flush        chan chan struct{}
+	pending      chan chan [][]byte
 	stallTimeout time.Duration
-	enqueTimer   *time.Timer
 	bulkTimer    *time.Timer
 	bulkLines    map[io.Writer][]byte
+	bulkPri      map[io.Writer]map[int][]byte
+
+	// periodChanged wakes the writer when a flush period changes.
+	periodChanged chan struct{}
 
 	shutdown      bool
 	loggingOff    bool
 	pendingWrites int32
-	prefix        string
+	prefix        atomic.Value // string
 	test          int32
+	panicked      int32
+
+	// Output settings read atomically on every call. New ones are
+	// added to outputSettings so SaveConfig copies them.
+	noSource       int32
+	dataSingleLine int32
+	dataMarshal    int32
+	collapse       int32
+	splunkTime     int32
+	maxFields      int32
+	maxDataLines   int32
+	sectionWidth   int32
+	timeFormat     int32
+	panicAsError   int32
+	synchronous    int32
+	splunkCaller   int32
+	hostname       int32
+	includePkg     int32
+	includeLevel   int32
+	varStyle       int32
+	compactTags    int32
+	funcNameStyle  int32
+	indentNesting  int32
+	overflow       int32
+}
+
+// repeat tracks the last line written to a device when consecutive
+// duplicate lines are being collapsed.
+type repeat struct {
+	last  []byte
+	count int
 }
 
 // logger maintains a pointer to the single logger.
 var l = logger{
-	enqueTimer: time.NewTimer(time.Hour),
-	bulkTimer:  time.NewTimer(time.Hour),
-	bulkLines:  make(map[io.Writer][]byte, 2),
-	prefix:     "PREFIX",
+	bulkTimer: time.NewTimer(time.Hour),
+	bulkLines: make(map[io.Writer][]byte, 2),
+	bulkPri:   make(map[io.Writer]map[int][]byte),
+
+	periodChanged: make(chan struct{}, 1),
 }
 
 var bulkLogPeriod = int64(time.Second) // For production, we will use 1 sec, but can change for testing.
 
-// SetBulkLogPeriod sets the private value for the bulk log period.
+// SetBulkLogPeriod sets the private value for the bulk log period. Lines
+// that are already buffered are flushed by the new period.
 func SetBulkLogPeriod(p time.Duration) {
 	atomic.StoreInt64(&bulkLogPeriod, int64(p))
+	notifyPeriodChanged()
+}
+
+// idleFlushDelay is how long the first line after an idle period is
+// buffered, in nanoseconds.
+var idleFlushDelay = int64(10 * time.Millisecond)
+
+// SetIdleFlushDelay sets how long the first line written to a device that
+// has been idle for its flush period is buffered before it is written, so
+// a line on a quiet device, like a startup message, doesn't wait for the
+// whole period. Lines that follow it closely are buffered for the whole
+// period as usual, so busy devices are flushed as often as before. A delay
+// of 0, or one longer than the flush period, turns it off. The default is
+// 10ms.
+func SetIdleFlushDelay(d time.Duration) {
+	atomic.StoreInt64(&idleFlushDelay, int64(d))
+}
+
+// notifyPeriodChanged tells the writer goroutine that a flush period has
+// changed. It never blocks, one pending notice is enough.
+func notifyPeriodChanged() {
+	select {
+	case l.periodChanged <- struct{}{}:
+	default:
+	}
 }
 
 // GetBulkLogPeriod retrieves the private value for the bulk log period.
@@ -87,6 +176,16 @@ func GetBulkLogPeriod() time.Duration {
 	return time.Duration(atomic.LoadInt64(&bulkLogPeriod))
 }
 
+// appPrefix returns the prefix set with Init. It is read on every line
+// and can be changed by RestoreConfig while logging is running.
+func appPrefix() string {
+	if p, ok := l.prefix.Load().(string); ok {
+		return p
+	}
+
+	return "PREFIX"
+}
+
 // SetStallTimeout sets the stall timeout value.
 func SetStallTimeout(t time.Duration) {
 	l.mu.Lock()
@@ -94,6 +193,589 @@ func SetStallTimeout(t time.Duration) {
 	l.mu.Unlock()
 }
 
+// OverflowPolicy selects what is done with a line when the writer has
+// fallen behind and the buffer passed to Init is full.
+type OverflowPolicy int32
+
+// Set of policies supported for a full buffer.
+const (
+	// PolicyTurnOff waits up to the stall timeout for room and then
+	// turns logging off, dropping every new line until the buffer has
+	// been written. LoggingWasOff is written when it comes back on.
+	PolicyTurnOff OverflowPolicy = iota
+
+	// PolicyDropOldest drops the oldest line in the buffer to make room
+	// for the new one without waiting, so the most recent lines leading
+	// up to a problem are kept.
+	PolicyDropOldest
+)
+
+// droppedOldest counts the lines dropped by PolicyDropOldest.
+var droppedOldest uint64
+
+// SetOverflowPolicy sets what is done with a line when the buffer is
+// full. The default is PolicyTurnOff.
+func SetOverflowPolicy(p OverflowPolicy) {
+	atomic.StoreInt32(&l.overflow, int32(p))
+}
+
+// DroppedOldest returns the number of lines dropped by PolicyDropOldest
+// since the program started.
+func DroppedOldest() uint64 {
+	return atomic.LoadUint64(&droppedOldest)
+}
+
+// argTransform holds the function applied to each formatting argument.
+var argTransform atomic.Value
+
+// SetArgTransform sets a function that is applied to each variadic argument
+// before the message is formatted. It can be used to truncate or summarize
+// values, like errors with very long messages. Passing nil removes it.
+func SetArgTransform(f func(i int, v interface{}) interface{}) {
+	argTransform.Store(f)
+}
+
+// sprintf formats the message after applying the argument transform.
+func sprintf(format string, a ...interface{}) string {
+	if f, ok := argTransform.Load().(func(int, interface{}) interface{}); ok && f != nil {
+		ta := make([]interface{}, len(a))
+		for i, v := range a {
+			ta[i] = f(i, v)
+		}
+		a = ta
+	}
+
+	return fmt.Sprintf(format, a...)
+}
+
+// Diagnostic messages written in place of the defaults.
+var (
+	emptyMsg         atomic.Value
+	loggingWasOffMsg atomic.Value
+)
+
+// SetEmptyMessage replaces the message written when a call has nothing
+// to write. Passing an empty string restores the default.
+func SetEmptyMessage(msg string) {
+	emptyMsg.Store(msg)
+}
+
+// SetLoggingWasOffMessage replaces the message written to a device when
+// logging resumes after it was turned off because a device stalled.
+// Passing an empty string restores the default.
+func SetLoggingWasOffMessage(msg string) {
+	loggingWasOffMsg.Store(msg)
+}
+
+// diagnostic returns the message stored in v or the default if none is
+// set. The message always ends with a newline.
+func diagnostic(v *atomic.Value, def string) string {
+	msg, _ := v.Load().(string)
+	if msg == "" {
+		return def
+	}
+	if msg[len(msg)-1] != '\n' {
+		msg += "\n"
+	}
+
+	return msg
+}
+
+// Entry describes a trace line that is passed to the filter.
+type Entry struct {
+	Device   int8
+	Context  interface{}
+	Function string
+	Line     string
+}
+
+// filter holds the predicate that decides which trace lines are written.
+var filter atomic.Value
+
+// SetFilter sets a function that is called with each trace line before it
+// is queued. Returning false drops the line. It can be used to drop noise
+// like health checks based on the context, function or content of the
+// line. Lines are only formatted for the filter when one is set. Splunk
+// and Event lines are passed with NoContext and no function. Audit records
+// are never dropped, so they don't go through it. Passing nil removes it.
+func SetFilter(f func(entry Entry) bool) {
+	filter.Store(f)
+}
+
+// SetSourceLocation turns the file and line number lookup on or off. When it
+// is off the file slot of each trace line is written as "-" and the call to
+// runtime.Caller is skipped. It is on by default.
+func SetSourceLocation(on bool) {
+	if on {
+		atomic.StoreInt32(&l.noSource, 0)
+		return
+	}
+	atomic.StoreInt32(&l.noSource, 1)
+}
+
+// SetDataSingleLine turns the folding of data blocks on or off. When it is
+// on the lines of DataString, DataBlock and DataTrace are written on the
+// header line separated by an escaped \n instead of each on their own
+// indented line, so each block is one physical line for log shippers.
+// It is off by default.
+func SetDataSingleLine(on bool) {
+	if on {
+		atomic.StoreInt32(&l.dataSingleLine, 1)
+		return
+	}
+	atomic.StoreInt32(&l.dataSingleLine, 0)
+}
+
+// MarshalErrorBehavior selects what DataBlock does with a value that can't
+// be encoded as JSON.
+type MarshalErrorBehavior int32
+
+// Set of behaviors supported for values that can't be encoded.
+const (
+	// MarshalErrorInline writes the error as the data block.
+	MarshalErrorInline MarshalErrorBehavior = iota
+
+	// MarshalErrorWarn writes a Warning line with the error in place of
+	// the data block.
+	MarshalErrorWarn
+
+	// MarshalErrorSkip writes nothing.
+	MarshalErrorSkip
+)
+
+// SetDataMarshalErrorBehavior sets what DataBlock does when the value can't
+// be encoded as JSON, like a NaN or a channel. The default is
+// MarshalErrorInline.
+func SetDataMarshalErrorBehavior(b MarshalErrorBehavior) {
+	atomic.StoreInt32(&l.dataMarshal, int32(b))
+}
+
+// SetCollapseConsecutive turns the collapsing of duplicate lines on or off.
+// When it is on a line that is identical to the previous line written to
+// the same device, apart from the leading timestamp, is dropped and counted.
+// The count is written as "... (repeated N times)" when a different line
+// arrives or the buffered lines are flushed. It is off by default.
+func SetCollapseConsecutive(on bool) {
+	if on {
+		atomic.StoreInt32(&l.collapse, 1)
+		return
+	}
+	atomic.StoreInt32(&l.collapse, 0)
+}
+
+// TimeFormat selects how the timestamp at the start of each line is written.
+type TimeFormat int32
+
+// Set of timestamp formats supported by SetTimeFormat.
+const (
+	// FormatTimeDefault writes the date and time, 2006/01/02 15:04:05.000000000.
+	FormatTimeDefault TimeFormat = iota
+
+	// FormatTimeEpochMillis writes the milliseconds since the Unix epoch.
+	FormatTimeEpochMillis
+)
+
+// SetTimeFormat sets how the timestamp at the start of each trace and
+// Splunk line is written. The default is FormatTimeDefault. Splunk lines
+// use SetSplunkTimeFormat instead when it is set to an epoch format.
+func SetTimeFormat(f TimeFormat) {
+	atomic.StoreInt32(&l.timeFormat, int32(f))
+}
+
+// SplunkTimeFormat selects how Splunk writes the timestamp of each line.
+type SplunkTimeFormat int32
+
+// Set of timestamp formats supported by Splunk.
+const (
+	// SplunkTimeISO writes the same timestamp as the trace lines.
+	SplunkTimeISO SplunkTimeFormat = iota
+
+	// SplunkTimeEpoch writes the seconds since the Unix epoch.
+	SplunkTimeEpoch
+
+	// SplunkTimeEpochMillis writes the milliseconds since the Unix epoch.
+	SplunkTimeEpochMillis
+)
+
+// SetSplunkTimeFormat sets how Splunk writes the timestamp of each line.
+// The default is SplunkTimeISO.
+func SetSplunkTimeFormat(f SplunkTimeFormat) {
+	atomic.StoreInt32(&l.splunkTime, int32(f))
+}
+
+// SetSplunkIncludeCaller turns the caller pairs on Splunk lines on or off.
+// When it is on each line starts with file, line and func pairs for the
+// code that called Splunk. They are not counted by SetMaxFields, and file
+// and line are left out when SetSourceLocation is off. It is off by
+// default.
+func SetSplunkIncludeCaller(on bool) {
+	if on {
+		atomic.StoreInt32(&l.splunkCaller, 1)
+		return
+	}
+	atomic.StoreInt32(&l.splunkCaller, 0)
+}
+
+// durationUnit holds the unit DataKV writes a time.Duration in.
+var durationUnit = int64(time.Millisecond)
+
+// durationUnits maps each unit SetDurationUnit accepts to its suffix.
+var durationUnits = map[time.Duration]string{
+	time.Nanosecond:  "ns",
+	time.Microsecond: "us",
+	time.Millisecond: "ms",
+	time.Second:      "s",
+	time.Minute:      "m",
+	time.Hour:        "h",
+}
+
+// SetDurationUnit sets the unit DataKV and DataKVQuoted write a
+// time.Duration value in, like 1500ms for the default of time.Millisecond.
+// The unit can be time.Nanosecond, Microsecond, Millisecond, Second,
+// Minute or Hour, other values are ignored.
+func SetDurationUnit(unit time.Duration) {
+	if _, ok := durationUnits[unit]; ok {
+		atomic.StoreInt64(&durationUnit, int64(unit))
+	}
+}
+
+// formatDuration writes the duration as a number of the unit set with
+// SetDurationUnit followed by the unit.
+func formatDuration(d time.Duration) string {
+	unit := time.Duration(atomic.LoadInt64(&durationUnit))
+	return strconv.FormatFloat(float64(d)/float64(unit), 'f', -1, 64) + durationUnits[unit]
+}
+
+// formatTime formats the timestamp for the start of a line.
+func formatTime(t time.Time) string {
+	if TimeFormat(atomic.LoadInt32(&l.timeFormat)) == FormatTimeEpochMillis {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	return t.UTC().Format(layout)
+}
+
+// clock holds the *timeSource used to read the time for timestamps. A nil
+// source means time.Now.
+var clock atomic.Value
+
+// timeSource is a clock and whether it was installed by InitTest.
+type timeSource struct {
+	now  func() time.Time
+	test bool
+}
+
+// SetClock sets the function used to read the time for the timestamps of
+// every line, so tests and simulations can control the time outside of
+// test mode. Passing nil goes back to time.Now. When no clock is set,
+// InitTest installs one fixed at the time set with SetTestFixture which is
+// removed again by Shutdown. A clock set with SetClock is kept by both.
+func SetClock(f func() time.Time) {
+	if f == nil {
+		clock.Store((*timeSource)(nil))
+		return
+	}
+	clock.Store(&timeSource{now: f})
+}
+
+// currentClock returns the clock that is set, nil for time.Now.
+func currentClock() *timeSource {
+	s, _ := clock.Load().(*timeSource)
+	return s
+}
+
+// clockNow returns the current time from the clock.
+func clockNow() time.Time {
+	if s := currentClock(); s != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+// fixture holds the values written in place of the real ones in test mode.
+type fixture struct {
+	time time.Time
+	file string
+	pid  int
+}
+
+// testFixture holds the *fixture set with SetTestFixture.
+var testFixture atomic.Value
+
+// SetTestFixture sets the time, file and pid written on every line in test
+// mode, so the output can match golden files written with other values. It
+// can be called before or after InitTest and lasts until it is called
+// again. The defaults are 2009/11/10 15:00:00 UTC, file.go#512 and 69910.
+func SetTestFixture(t time.Time, file string, pid int) {
+	testFixture.Store(&fixture{time: t, file: file, pid: pid})
+}
+
+// testValues returns the fixture used in test mode.
+func testValues() *fixture {
+	if f, ok := testFixture.Load().(*fixture); ok && f != nil {
+		return f
+	}
+
+	return &fixture{
+		time: time.Date(2009, time.November, 10, 15, 0, 0, 0, time.UTC),
+		file: "file.go#512",
+		pid:  69910,
+	}
+}
+
+// testTime is the fixed time used in test mode.
+func testTime() time.Time {
+	return testValues().time
+}
+
+// hostname holds the name of the host, looked up the first time
+// SetIncludeHostname turns it on.
+var hostname struct {
+	once sync.Once
+	name string
+}
+
+// SetIncludeHostname turns the host[name] field on or off. When it is on
+// every trace line written by the logging calls starts its var segment
+// with the name of the host, so lines can be told apart once logs from
+// many hosts are put together. The name is looked up once. In test mode
+// it is written as host[testhost]. It is off by default.
+func SetIncludeHostname(on bool) {
+	if on {
+		hostname.once.Do(func() {
+			name, err := os.Hostname()
+			if err != nil || name == "" {
+				name = "unknown"
+			}
+			hostname.name = name
+		})
+		atomic.StoreInt32(&l.hostname, 1)
+		return
+	}
+	atomic.StoreInt32(&l.hostname, 0)
+}
+
+// hostName returns the name for the host field, or an empty string when
+// it is turned off.
+func hostName() string {
+	if atomic.LoadInt32(&l.hostname) == 0 {
+		return ""
+	}
+	if atomic.LoadInt32(&l.test) == 1 {
+		return "testhost"
+	}
+
+	return hostname.name
+}
+
+// SetIncludePackage turns the pkg[path] field on or off. When it is on
+// every trace line written by the logging calls has the import path of
+// the calling package in its var segment, after the host field, so the
+// lines of one package can be filtered on. It is off by default.
+func SetIncludePackage(on bool) {
+	if on {
+		atomic.StoreInt32(&l.includePkg, 1)
+		return
+	}
+	atomic.StoreInt32(&l.includePkg, 0)
+}
+
+// callerPackage returns the import path of the package of the function at
+// the calldepth, the part of its full name before the first dot after the
+// last slash.
+func callerPackage(calldepth int) string {
+	pc := make([]uintptr, 1)
+	if runtime.Callers(calldepth+1, pc) == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+
+	name := frame.Function
+	slash := strings.LastIndex(name, "/") + 1
+	if i := strings.Index(name[slash:], "."); i >= 0 {
+		return name[:slash+i]
+	}
+
+	return name
+}
+
+// VarStyle selects how the fields of the var segment are written.
+type VarStyle int32
+
+// Set of styles supported for the var segment.
+const (
+	// VarBrackets writes the fields as key[value] separated by ": ".
+	VarBrackets VarStyle = iota
+
+	// VarEquals writes the fields as key=value separated by a space.
+	// Values with a space are quoted like the Splunk pairs.
+	VarEquals
+
+	// VarJSON writes the fields as a single JSON object in order, like
+	// {"key":value}.
+	VarJSON
+)
+
+// SetVarStyle sets how the fields of the var segment are written. The
+// default is VarBrackets.
+func SetVarStyle(s VarStyle) {
+	atomic.StoreInt32(&l.varStyle, int32(s))
+}
+
+// FuncNameStyle selects how much of the name of the calling function is
+// written when a logging call is passed an empty function name.
+type FuncNameStyle int32
+
+// Set of styles supported for function names.
+const (
+	// FuncFull writes the package name with the function, like
+	// pkg.(*Type).Method.
+	FuncFull FuncNameStyle = iota
+
+	// FuncShort leaves out the package name, like (*Type).Method.
+	FuncShort
+
+	// FuncBase writes the function or method name only, like Method.
+	FuncBase
+)
+
+// SetFuncNameStyle sets how the name of the calling function is written
+// when it is looked up because the function passed in is empty. Names that
+// are passed in are written as is. The default is FuncFull.
+func SetFuncNameStyle(s FuncNameStyle) {
+	atomic.StoreInt32(&l.funcNameStyle, int32(s))
+}
+
+// shortenFunc trims the function name looked up by dtFile to the style
+// set with SetFuncNameStyle.
+func shortenFunc(name string) string {
+	switch FuncNameStyle(atomic.LoadInt32(&l.funcNameStyle)) {
+	case FuncShort:
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			return name[i+1:]
+		}
+	case FuncBase:
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			return name[i+1:]
+		}
+	}
+
+	return name
+}
+
+// SetIncludeLevel turns the level[name] field on or off. When it is on
+// every line written by a Logger starts its var segment with the level the
+// logger was at when the line was written, like level[Trace], so it is
+// clear why a line is there when logs from different settings are compared.
+// The package level calls have no level and don't write it. It is off by
+// default.
+func SetIncludeLevel(on bool) {
+	if on {
+		atomic.StoreInt32(&l.includeLevel, 1)
+		return
+	}
+	atomic.StoreInt32(&l.includeLevel, 0)
+}
+
+// levelIncluded reports whether the level field is turned on.
+func levelIncluded() bool {
+	return atomic.LoadInt32(&l.includeLevel) == 1
+}
+
+// SetCompactTags turns compact tags on or off. When it is on the tag of
+// each trace line is written as a single character to shrink the lines of
+// a high volume log:
+//
+//	S  Started
+//	C  Completed
+//	X  Completed ERROR
+//	E  ERROR
+//	W  Warning
+//	T  Trace
+//	Q  Query
+//	D  DATA
+//
+// The TERMINATING line, Splunk, Event and Audit lines are not changed. It
+// is off by default.
+func SetCompactTags(on bool) {
+	if on {
+		atomic.StoreInt32(&l.compactTags, 1)
+		return
+	}
+	atomic.StoreInt32(&l.compactTags, 0)
+}
+
+// SetSynchronous turns synchronous mode on or off. In synchronous mode
+// each line is written to its device before the logging call returns,
+// instead of being queued for the writer goroutine, and Shutdown doesn't
+// wait for queued lines. A slow device blocks every logging call, so it
+// is meant for short-lived programs and tests. Lines already queued are
+// flushed before it is turned on. Collapsing duplicate lines and write
+// priorities don't apply to lines written synchronously. It is off by
+// default.
+func SetSynchronous(on bool) {
+	if on {
+		Flush()
+		atomic.StoreInt32(&l.synchronous, 1)
+		return
+	}
+	atomic.StoreInt32(&l.synchronous, 0)
+}
+
+// SetPanicAsError turns on or off returning from ErrPanic and ErrPanicf
+// instead of panicking. The ERROR and TERMINATING lines are still written
+// and flushed but the logger is not shut down. It is meant for tests that
+// exercise code paths which call ErrPanic. It is off by default.
+func SetPanicAsError(on bool) {
+	if on {
+		atomic.StoreInt32(&l.panicAsError, 1)
+		return
+	}
+	atomic.StoreInt32(&l.panicAsError, 0)
+}
+
+// SetMaxFields limits the number of key/value pairs written by Splunk,
+// Event and DataGroup. The pairs past the limit are replaced with a
+// "...(+N more)" marker. Audit records are always written whole. A limit
+// of 0 or less, the default, turns it off.
+func SetMaxFields(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&l.maxFields, int32(n))
+}
+
+// SetMaxDataLines limits the number of lines written by DataString,
+// DataBlock and DataTrace. The lines past the limit are replaced with a
+// "... (M more lines omitted)" marker. A limit of 0 or less, the default,
+// turns it off.
+func SetMaxDataLines(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&l.maxDataLines, int32(n))
+}
+
+// SetSectionWidth sets the width of the separator lines written by Section.
+// A width of 0 or less goes back to the default of 80.
+func SetSectionWidth(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&l.sectionWidth, int32(n))
+}
+
+// fieldLimit returns how many of the n pairs can be written and how many
+// are left over.
+func fieldLimit(n int) (keep int, more int) {
+	max := int(atomic.LoadInt32(&l.maxFields))
+	if max <= 0 || n <= max {
+		return n, 0
+	}
+	return max, n - max
+}
+
 // Init initializes the logging system for use. It can be called
 // multiple times to reset the destination.
 func Init(prefix string, bufferSize int, dws ...DevWriter) {
@@ -112,9 +794,11 @@ func Init(prefix string, bufferSize int, dws ...DevWriter) {
 	}
 
 	// Set user defined values.
-	l.prefix = prefix
+	l.prefix.Store(prefix)
 	l.write = make(chan line, bufferSize)
 	l.exit = make(chan struct{})
+	l.flush = make(chan chan struct{})
+	l.pending = make(chan chan [][]byte)
 	l.stallTimeout = 250 * time.Millisecond
 
 	l.destMu.Lock()
@@ -130,6 +814,8 @@ func Init(prefix string, bufferSize int, dws ...DevWriter) {
 			DevQuery:  os.Stdout,
 			DevData:   os.Stdout,
 			DevSplunk: os.Stdout,
+			DevEvent:  os.Stdout,
+			DevAudit:  os.Stdout,
 		}
 	}
 	l.destMu.Unlock()
@@ -152,9 +838,21 @@ func Init(prefix string, bufferSize int, dws ...DevWriter) {
 		}
 	}
 
+	// Catch a file that was opened twice by mistake. This is only a
+	// warning, the writers are used as they are.
+	l.destMu.RLock()
+	warnings := sameFiles(l.dest)
+	l.destMu.RUnlock()
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Init WARNING: %s\n", w)
+	}
+
 	// Set the flags.
 	l.loggingOff = false
 	l.shutdown = false
+	resetWritten()
+	resetTagCounts()
+	atomic.StoreInt32(&l.panicked, 0)
 
 	// Create the safe writer goroutine to prevent the log
 	// from causing the host application to block on log calls.
@@ -164,28 +862,156 @@ func Init(prefix string, bufferSize int, dws ...DevWriter) {
 
 // InitTest configures the logger for testing purposes.
 func InitTest(prefix string, bufferSize int, dws ...DevWriter) {
-	SetBulkLogPeriod(50 * time.Millisecond)
+	SetBulkLogPeriod(time.Duration(atomic.LoadInt64(&testTimings.bulk)))
 	Init(prefix, bufferSize, dws...)
+	if currentClock() == nil {
+		clock.Store(&timeSource{now: testTime, test: true})
+	}
 	atomic.StoreInt32(&l.test, 1)
 }
 
-// Shutdown will wait until all the pending writes are complete.
+// testTimings holds the bulk log period used by InitTest and the waits of
+// Shutdown in test mode, in nanoseconds.
+var testTimings = struct {
+	bulk         int64
+	shutdownPre  int64
+	shutdownPost int64
+}{
+	bulk:         int64(50 * time.Millisecond),
+	shutdownPre:  int64(100 * time.Millisecond),
+	shutdownPost: int64(200 * time.Millisecond),
+}
+
+// SetTestTimings sets the bulk log period set by InitTest and, when logging
+// was started with InitTest, the waits of Shutdown: shutdownPre is how long
+// it waits for lines that are about to be queued and shutdownPost is the
+// longest it waits for the last writes to complete. The defaults are 50ms,
+// 100ms and 200ms. Tests that log from a single goroutine and then call
+// Shutdown can use short timings, like 1ms, to run faster. Values of 0 or
+// less keep the current timing. Logging started with Init always uses the
+// defaults.
+func SetTestTimings(bulk, shutdownPre, shutdownPost time.Duration) {
+	if bulk > 0 {
+		atomic.StoreInt64(&testTimings.bulk, int64(bulk))
+	}
+	if shutdownPre > 0 {
+		atomic.StoreInt64(&testTimings.shutdownPre, int64(shutdownPre))
+	}
+	if shutdownPost > 0 {
+		atomic.StoreInt64(&testTimings.shutdownPost, int64(shutdownPost))
+	}
+}
+
+// shutdownWait returns the test timing when logging was started with
+// InitTest and the default otherwise.
+func shutdownWait(def time.Duration, timing *int64) time.Duration {
+	if atomic.LoadInt32(&l.test) == 1 {
+		return time.Duration(atomic.LoadInt64(timing))
+	}
+
+	return def
+}
+
+// Shutdown will wait until all the pending writes are complete. It is safe
+// to call more than once and from several goroutines: a call made while
+// logging is not running does nothing, and a call made while another is
+// shutting down returns once that one completes.
 func Shutdown() {
+	l.mu.Lock()
+	running := !l.shutdown && l.write != nil
+	l.mu.Unlock()
+
 	// Sleep for a little bit to allow any possible messages that are about to be enqueued to be placed
-	// in the channel.
-	time.Sleep(100 * time.Millisecond)
+	// in the channel. Nothing is enqueued in synchronous mode.
+	if running && atomic.LoadInt32(&l.synchronous) == 0 {
+		time.Sleep(shutdownWait(100*time.Millisecond, &testTimings.shutdownPre))
+	}
+	waitAudit()
+
 	l.mu.Lock()
 	{
+		// Another call got here first. It held the lock until it was
+		// done, so there is nothing left to wait for.
+		if l.shutdown || l.write == nil {
+			l.mu.Unlock()
+			return
+		}
+
 		l.shutdown = true
 		close(l.write)
 		close(l.exit)
 		l.wg.Wait()
 		l.write = nil
 		l.exit = nil
+		l.flush = nil
+		l.pending = nil
+
+		if atomic.SwapInt32(&l.test, 0) == 1 {
+			if s := currentClock(); s != nil && s.test {
+				SetClock(nil)
+			}
+		}
+	}
+	l.mu.Unlock()
+}
+
+// Flush writes all of the lines logged before the call to their devices
+// and waits for the writes to complete.
+func Flush() {
+	// Audit records have their own queue that works without Init.
+	waitAudit()
+
+	l.mu.Lock()
+	if l.shutdown || l.write == nil {
+		l.mu.Unlock()
+		return
+	}
+	flush, exit := l.flush, l.exit
+	l.mu.Unlock()
 
-		atomic.StoreInt32(&l.test, 0)
+	done := make(chan struct{})
+	select {
+	case flush <- done:
+		<-done
+	case <-exit:
+	}
+}
+
+// Pending returns a copy of the lines that have been logged but not yet
+// handed to their devices, one line for each element without the newline.
+// The lines of each device are in the order they will be written and the
+// devices are in device order. Nothing is flushed, so it can be called
+// from a deferred recover to add the lines that were about to be written
+// to a crash report. Lines that are being written when it is called are
+// not included. It returns nil when logging is not running or the writer
+// doesn't answer within a second.
+func Pending() [][]byte {
+	l.mu.Lock()
+	if l.shutdown || l.write == nil {
+		l.mu.Unlock()
+		return nil
 	}
+	pending, exit := l.pending, l.exit
 	l.mu.Unlock()
+
+	reply := make(chan [][]byte, 1)
+	wait := time.NewTimer(time.Second)
+	defer wait.Stop()
+
+	select {
+	case pending <- reply:
+	case <-exit:
+		return nil
+	case <-wait.C:
+		return nil
+	}
+
+	select {
+	case lines := <-reply:
+		return lines
+	case <-wait.C:
+		return nil
+	}
 }
 
 // dtFile returns the current time and file for logging.
@@ -193,19 +1019,32 @@ func dtFile(calldepth int, function string) (dateTime string, file string, funcN
 	// Capture the name of the function logging if
 	// a function was not provided.
 	if function == "" {
-		pc := make([]uintptr, calldepth+1)
-		runtime.Callers(calldepth, pc)
-		f := runtime.FuncForPC(pc[calldepth-1])
-		_, funcName = path.Split(f.Name())
+		pc := make([]uintptr, 1)
+		runtime.Callers(calldepth+1, pc)
+		frame, _ := runtime.CallersFrames(pc).Next()
+		_, funcName = path.Split(frame.Function)
+		funcName = shortenFunc(funcName)
 	} else {
 		funcName = function
 	}
 
+	noSource := atomic.LoadInt32(&l.noSource) == 1
+
 	if atomic.LoadInt32(&l.test) == 1 {
-		return time.Date(2009, time.November, 10, 15, 0, 0, 0, time.UTC).UTC().Format(layout), "file.go#512", funcName, 69910
+		fx := testValues()
+		file = fx.file
+		if noSource {
+			file = "-"
+		}
+		return formatTime(clockNow()), file, funcName, fx.pid
 	}
 
-	dateTime = time.Now().UTC().Format(layout)
+	dateTime = formatTime(clockNow())
+
+	// Skip the caller lookup when source locations are turned off.
+	if noSource {
+		return dateTime, "-", funcName, os.Getpid()
+	}
 
 	_, filePath, line, ok := runtime.Caller(calldepth)
 	if !ok {
@@ -218,27 +1057,59 @@ func dtFile(calldepth int, function string) (dateTime string, file string, funcN
 
 // output performs the actual write to the destination device.
 func output(w io.Writer, format string, a ...interface{}) {
+	outputAt(w, 0, format, a...)
+}
+
+// outputAt performs the actual write to the destination device with the
+// specified priority. Lines with a higher priority are written ahead of
+// the others in the same bulk write.
+func outputAt(w io.Writer, priority int, format string, a ...interface{}) {
+	outputLine(w, priority, false, format, a...)
+}
+
+// outputLine performs the actual write to the destination device. When now
+// is set the device is flushed as soon as the line is received instead of
+// waiting for its flush period.
+func outputLine(w io.Writer, priority int, now bool, format string, a ...interface{}) {
 	if w == nil {
 		return
 	}
+
+	// Build the line in a pooled buffer.
+	b := linePool.Get().(*bytes.Buffer)
 	if format == "" {
-		format = emptyMessage
+		b.WriteString(diagnosticLine("LOG ERROR", diagnostic(&emptyMsg, emptyMessage)))
 	} else if a != nil {
-		format = fmt.Sprintf(format, a...)
+		fmt.Fprintf(b, format, a...)
+	} else {
+		b.WriteString(format)
 	}
 
-	if format[len(format)-1] != '\n' {
-		format = format + "\n"
+	if b.Len() == 0 || b.Bytes()[b.Len()-1] != '\n' {
+		b.WriteByte('\n')
 	}
 
-	// Create a slice from the string.
-	b := []byte(format)
-
 	l.mu.Lock()
 	{
-		// We are shutting down. Get out of town.
-		if l.shutdown {
+		// We are shutting down. Get out of town. Shutdown closes the write
+		// channel while holding this lock, so once the flag is set there
+		// can't be a send in flight.
+		if l.shutdown || l.write == nil {
 			l.mu.Unlock()
+			putLine(b)
+			return
+		}
+
+		// In synchronous mode the line is written right here. Holding the
+		// lock keeps writes from different goroutines from interleaving.
+		if atomic.LoadInt32(&l.synchronous) == 1 {
+			if err := writeTo(w, b.Bytes()); err != nil {
+				fmt.Fprintf(os.Stderr, "output ERROR: %s\n", err)
+				writeFailed(w, err)
+			}
+			addWritten(1)
+			l.mu.Unlock()
+			putLine(b)
 			return
 		}
 
@@ -247,62 +1118,548 @@ func output(w io.Writer, format string, a ...interface{}) {
 		if l.loggingOff {
 			if atomic.LoadInt32(&l.pendingWrites) > 0 {
 				l.mu.Unlock()
+				putLine(b)
 				return
 			}
 
 			l.loggingOff = false
-			fmt.Fprintf(w, LoggingWasOff)
+			io.WriteString(w, diagnosticLine("LOG WARNING", diagnostic(&loggingWasOffMsg, LoggingWasOff)))
 		}
 
-		l.enqueTimer.Reset(l.stallTimeout)
-
-		// If we can't perform the write within the wait time, then
-		// let's not wait and turn off logging.
+		// Most of the time there is room in the channel so try the send
+		// first. A timer is only needed when the channel is full.
 		select {
-		case l.write <- line{w, b}:
+		case l.write <- line{w, b, priority, now}:
 			atomic.AddInt32(&l.pendingWrites, 1)
-			l.enqueTimer.Stop()
-		case <-l.enqueTimer.C:
-			l.loggingOff = true
+		default:
+			if OverflowPolicy(atomic.LoadInt32(&l.overflow)) == PolicyDropOldest {
+				dropOldest(line{w, b, priority, now})
+				break
+			}
+
+			// If we can't perform the write within the wait time, then
+			// let's not wait and turn off logging.
+			stall := time.NewTimer(l.stallTimeout)
+			select {
+			case l.write <- line{w, b, priority, now}:
+				atomic.AddInt32(&l.pendingWrites, 1)
+			case <-stall.C:
+				l.loggingOff = true
+				putLine(b)
+			}
+			stall.Stop()
 		}
 	}
 	l.mu.Unlock()
 }
 
+// dropOldest takes the oldest line out of the full write channel and
+// sends the new one in its place. Lines are only sent while holding l.mu,
+// which the caller holds, so the room made can't be taken by another
+// sender. With an unbuffered channel the new line is dropped instead.
+func dropOldest(ln line) {
+	select {
+	case old := <-l.write:
+		atomic.AddInt32(&l.pendingWrites, -1)
+		putLine(old.b)
+	default:
+	}
+	atomic.AddUint64(&droppedOldest, 1)
+
+	select {
+	case l.write <- ln:
+		atomic.AddInt32(&l.pendingWrites, 1)
+	default:
+		putLine(ln.b)
+	}
+}
+
+// fallbackWrite is run as a goroutine in place of safeWrite once it has
+// panicked too many times. Logging is synchronous by then, so it writes
+// the lines that were left buffered or queued as they are, and answers
+// Flush, Pending and Shutdown until the write channel is closed.
+func fallbackWrite() {
+	defer l.wg.Done()
+
+	for w, pri := range l.bulkPri {
+		l.bulkLines[w] = byPriority(pri, l.bulkLines[w])
+		delete(l.bulkPri, w)
+	}
+	for w, b := range l.bulkLines {
+		if err := writeDevice(w, b); err != nil {
+			fmt.Fprintf(os.Stderr, "safeWrite ERROR: %s\n", err)
+			writeFailed(w, err)
+		}
+		delete(l.bulkLines, w)
+	}
+
+	receive := func(ln line) {
+		atomic.AddInt32(&l.pendingWrites, -1)
+		if ln.w == nil || ln.b == nil {
+			return
+		}
+		if err := writeDevice(ln.w, ln.b.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "safeWrite ERROR: %s\n", err)
+			writeFailed(ln.w, err)
+		}
+		addWritten(1)
+		putLine(ln.b)
+	}
+
+	write := l.write
+	drain := func() {
+		for {
+			select {
+			case ln, ok := <-write:
+				if !ok {
+					write = nil
+					return
+				}
+				receive(ln)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case ln, ok := <-write:
+			if !ok {
+				write = nil
+				continue
+			}
+			receive(ln)
+		case done := <-l.flush:
+			drain()
+			close(done)
+		case reply := <-l.pending:
+			drain()
+			reply <- nil
+		case <-l.exit:
+			drain()
+			return
+		}
+	}
+}
+
+// byPriority joins the lines buffered for each priority, highest first.
+// The lines with the default priority of 0 are passed separately.
+func byPriority(pri map[int][]byte, lines []byte) []byte {
+	pri[0] = append(pri[0], lines...)
+
+	keys := make([]int, 0, len(pri))
+	for k := range pri {
+		keys = append(keys, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+
+	var b []byte
+	for _, k := range keys {
+		b = append(b, pri[k]...)
+	}
+
+	return b
+}
+
+// maxWriterRestarts is the number of times the writer goroutine is
+// restarted after a panic before lines are written synchronously.
+const maxWriterRestarts = 3
+
 // safeWrite is run as a goroutine. It pulls a message from the
 // channel and perform the write.
 func safeWrite() {
-	l.bulkTimer.Reset(GetBulkLogPeriod())
+	// If the writer panics, start a new one so lines keep getting written
+	// and Shutdown doesn't wait forever. A warning is written the first
+	// time it happens. A writer that keeps panicking is replaced by
+	// fallbackWrite and logging goes synchronous. Panics in the writers of
+	// the devices are reported as write errors and don't get here. A Flush
+	// that was being answered is let go.
+	var flushing chan struct{}
+	defer func() {
+		if r := recover(); r != nil {
+			if flushing != nil {
+				close(flushing)
+			}
+
+			n := atomic.AddInt32(&l.panicked, 1)
+			if n > maxWriterRestarts {
+				fmt.Fprintf(os.Stderr, "safeWrite PANIC: %v: writing synchronously\n", r)
+				atomic.StoreInt32(&l.synchronous, 1)
+				go fallbackWrite()
+				return
+			}
+			if n == 1 {
+				fmt.Fprintf(os.Stderr, "safeWrite PANIC: %v: restarting the writer\n", r)
+			}
+			go safeWrite()
+			return
+		}
+
+		l.wg.Done()
+	}()
+
+	// Shutdown closes the write channel before the exit channel. Once
+	// it is closed it is set to nil so the select waits for the exit.
+	write := l.write
 
-	flush := func() {
+	// Duplicate lines are counted per device when collapsing is on.
+	repeats := make(map[io.Writer]*repeat)
+
+	writeRepeats := func(w io.Writer, r *repeat) {
+		if r.count > 0 {
+			l.bulkLines[w] = append(l.bulkLines[w], fmt.Sprintf("... (repeated %d times)\n", r.count)...)
+			r.count = 0
+		}
+	}
+
+	// Writes are performed on their own goroutine so a slow device can't
+	// block the channel. Each write waits on the previous write to the same
+	// device so a device never sees concurrent or out of order writes.
+	last := make(map[io.Writer]chan struct{})
+
+	// The number of lines received for each device that have not been
+	// written yet, for WaitForWrites.
+	counts := make(map[io.Writer]int)
+
+	// Each device is flushed once its flush period has passed since the
+	// first line buffered for it. The timer is set for the earliest one.
+	buffered := make(map[io.Writer]time.Time)
+	deadlines := make(map[io.Writer]time.Time)
+	var next time.Time
+
+	// The time the last line was received for each device, so the first
+	// line after an idle period can be flushed sooner, and the devices
+	// waiting on the idle flush delay rather than their period.
+	received := make(map[io.Writer]time.Time)
+	idle := make(map[io.Writer]bool)
+
+	schedule := func(w io.Writer) {
+		now := time.Now()
+		prev := received[w]
+		received[w] = now
+		if _, ok := deadlines[w]; ok {
+			return
+		}
+
+		period := flushPeriod(w)
+		if delay := time.Duration(atomic.LoadInt64(&idleFlushDelay)); delay > 0 && delay < period && now.Sub(prev) >= period {
+			period = delay
+			idle[w] = true
+		}
+		d := now.Add(period)
+		buffered[w] = now
+		deadlines[w] = d
+		if next.IsZero() || d.Before(next) {
+			next = d
+			l.bulkTimer.Reset(time.Until(d))
+		}
+	}
+
+	// resetTimer sets the timer for the earliest device still waiting.
+	resetTimer := func() {
+		next = time.Time{}
+		for _, d := range deadlines {
+			if next.IsZero() || d.Before(next) {
+				next = d
+			}
+		}
+		if !next.IsZero() {
+			l.bulkTimer.Reset(time.Until(next))
+		}
+	}
+
+	// take removes the lines of the devices that pass the due check from
+	// the buffers and returns them.
+	take := func(due func(w io.Writer) bool) map[io.Writer][]byte {
+		for w, r := range repeats {
+			if due(w) {
+				writeRepeats(w, r)
+			}
+		}
+
+		for k, pri := range l.bulkPri {
+			if due(k) {
+				l.bulkLines[k] = byPriority(pri, l.bulkLines[k])
+				delete(l.bulkPri, k)
+			}
+		}
+
+		taken := make(map[io.Writer][]byte)
 		for k, v := range l.bulkLines {
+			if due(k) {
+				taken[k] = v
+				delete(l.bulkLines, k)
+			}
+		}
+
+		for k := range deadlines {
+			if due(k) {
+				delete(deadlines, k)
+				delete(buffered, k)
+				delete(idle, k)
+			}
+		}
+		resetTimer()
+
+		// Forget about devices that have no write in flight.
+		for k, done := range last {
+			select {
+			case <-done:
+				delete(last, k)
+			default:
+			}
+		}
+
+		return taken
+	}
+
+	// flushDue writes the lines of the devices that pass the due check.
+	// The channel of each write in last is closed once it is done.
+	flushDue := func(due func(w io.Writer) bool) {
+		for k, v := range take(due) {
+			prev := last[k]
+			done := make(chan struct{})
+			last[k] = done
+			n := counts[k]
+			delete(counts, k)
+
 			go func(k io.Writer, v []byte) {
-				if _, err := k.Write(v); err != nil {
+				if prev != nil {
+					<-prev
+				}
+				if err := writeDevice(k, v); err != nil {
 					fmt.Fprintf(os.Stderr, "safeWrite ERROR: %s\n", err)
+					writeFailed(k, err)
 				}
+				addWritten(n)
+				close(done)
 			}(k, v)
-			delete(l.bulkLines, k)
+		}
+	}
+
+	// flushInOrder is used by Shutdown. Once the writes in flight are done
+	// it writes the lines of every device one after the other, in device
+	// order, so the last lines are not reordered across devices. The
+	// channel is closed once the last write returns.
+	flushInOrder := func() chan struct{} {
+		since := make(map[io.Writer]time.Time, len(buffered))
+		for k, t := range buffered {
+			since[k] = t
+		}
+
+		taken := take(func(io.Writer) bool { return true })
+		ws := make([]io.Writer, 0, len(taken))
+		for k := range taken {
+			ws = append(ws, k)
+		}
+		sort.Slice(ws, func(i, j int) bool {
+			di, dj := deviceOrder(ws[i]), deviceOrder(ws[j])
+			if di != dj {
+				return di < dj
+			}
+			return since[ws[i]].Before(since[ws[j]])
+		})
+
+		var prev []chan struct{}
+		for _, done := range last {
+			prev = append(prev, done)
+		}
+
+		var n int
+		for _, k := range ws {
+			n += counts[k]
+			delete(counts, k)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for _, p := range prev {
+				<-p
+			}
+			for _, k := range ws {
+				if err := writeDevice(k, taken[k]); err != nil {
+					fmt.Fprintf(os.Stderr, "safeWrite ERROR: %s\n", err)
+					writeFailed(k, err)
+				}
+			}
+			addWritten(n)
+			close(done)
+		}()
+
+		return done
+	}
+
+	// snapshot returns a copy of the buffered lines, in the order
+	// flushInOrder would write them, without taking them.
+	snapshot := func() [][]byte {
+		var ws []io.Writer
+		for k := range buffered {
+			ws = append(ws, k)
+		}
+		sort.Slice(ws, func(i, j int) bool {
+			di, dj := deviceOrder(ws[i]), deviceOrder(ws[j])
+			if di != dj {
+				return di < dj
+			}
+			return buffered[ws[i]].Before(buffered[ws[j]])
+		})
+
+		var lines [][]byte
+		for _, k := range ws {
+			// byPriority appends to the buffer of priority 0, so it is
+			// given a map of its own.
+			pri := make(map[int][]byte, len(l.bulkPri[k]))
+			for p, b := range l.bulkPri[k] {
+				pri[p] = b[:len(b):len(b)]
+			}
+
+			for _, ln := range bytes.Split(byPriority(pri, l.bulkLines[k]), []byte{'\n'}) {
+				if len(ln) > 0 {
+					lines = append(lines, ln)
+				}
+			}
+		}
+
+		return lines
+	}
+
+	// Lines left behind by a writer that panicked still need a flush.
+	for w := range l.bulkLines {
+		schedule(w)
+	}
+	for w := range l.bulkPri {
+		schedule(w)
+	}
+
+	receive := func(ln line) {
+		atomic.AddInt32(&l.pendingWrites, -1)
+
+		// The line is copied into the bulk buffer so its buffer can be
+		// reused once we are done here.
+		defer putLine(ln.b)
+
+		if ln.w == nil {
+			return
+		}
+
+		schedule(ln.w)
+		counts[ln.w]++
+
+		// Lines that can't wait take everything buffered for the device
+		// with them, so the order of the lines is kept.
+		if ln.now {
+			defer flushDue(func(w io.Writer) bool { return w == ln.w })
+		}
+
+		if atomic.LoadInt32(&l.collapse) == 1 {
+			r := repeats[ln.w]
+			if r == nil {
+				r = &repeat{}
+				repeats[ln.w] = r
+			}
+
+			// Leave the timestamp out of the comparison.
+			key := ln.b.Bytes()
+			if i := bytes.Index(key, []byte(": ")); i >= 0 {
+				key = key[i+2:]
+			}
+
+			if r.last != nil && bytes.Equal(r.last, key) {
+				r.count++
+				return
+			}
+
+			writeRepeats(ln.w, r)
+			r.last = append(r.last[:0], key...)
+		}
+
+		if ln.pri != 0 {
+			pri := l.bulkPri[ln.w]
+			if pri == nil {
+				pri = make(map[int][]byte)
+				l.bulkPri[ln.w] = pri
+			}
+			pri[ln.pri] = append(pri[ln.pri], ln.b.Bytes()...)
+			return
+		}
+
+		l.bulkLines[ln.w] = append(l.bulkLines[ln.w], ln.b.Bytes()...)
+	}
+
+	// drain picks up everything that has already been enqueued.
+	drain := func() {
+		for {
+			select {
+			case ln, ok := <-write:
+				if !ok {
+					write = nil
+					return
+				}
+				receive(ln)
+			default:
+				return
+			}
 		}
 	}
 
 exitFor:
 	for {
 		select {
-		case ln := <-l.write:
-			if ln.w != nil {
-				l.bulkLines[ln.w] = append(l.bulkLines[ln.w], ln.b...)
+		case ln, ok := <-write:
+			if !ok {
+				write = nil
+				continue
 			}
-			atomic.AddInt32(&l.pendingWrites, -1)
+			receive(ln)
 		case <-l.bulkTimer.C:
-			l.bulkTimer.Reset(GetBulkLogPeriod())
-			flush()
+			now := time.Now()
+			flushDue(func(w io.Writer) bool {
+				d, ok := deadlines[w]
+				return ok && !d.After(now)
+			})
+		case <-l.periodChanged:
+			// Work the deadlines out again so a shorter period takes
+			// effect now rather than after the old one runs out.
+			for w, t := range buffered {
+				if !idle[w] {
+					deadlines[w] = t.Add(flushPeriod(w))
+				}
+			}
+			resetTimer()
+		case reply := <-l.pending:
+			drain()
+			reply <- snapshot()
+		case done := <-l.flush:
+			flushing = done
+			drain()
+			flushDue(func(io.Writer) bool { return true })
+
+			// Writes started before, by the timer or by a line that is
+			// flushed immediately, may still be in flight.
+			for _, w := range last {
+				<-w
+			}
+			flushing = nil
+			close(done)
 		case <-l.exit:
 			l.bulkTimer.Stop()
-			flush()
-			time.Sleep(200 * time.Millisecond) // Need to wait for the flush to perform a write
+			drain()
+			done := flushInOrder()
+			if atomic.LoadInt32(&l.synchronous) == 1 {
+				<-done
+				break exitFor
+			}
+
+			// Wait for the writes, but not forever on a device that is
+			// stuck.
+			limit := time.NewTimer(shutdownWait(200*time.Millisecond, &testTimings.shutdownPost))
+			select {
+			case <-done:
+			case <-limit.C:
+			}
+			limit.Stop()
 			break exitFor
 		}
 	}
-
-	l.wg.Done()
 }
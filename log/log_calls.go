@@ -16,6 +16,8 @@
 
 package log
 
+import "io"
+
 // Start is used for the entry into a function.
 func Start(context interface{}, function string) {
 	Up1.Start(context, function)
@@ -26,16 +28,34 @@ func Startf(context interface{}, function string, format string, a ...interface{
 	Up1.Startf(context, function, format, a...)
 }
 
+// StartArgs is used for the entry into a function with its arguments
+// written as pairs in the var segment, in order.
+func StartArgs(context interface{}, function string, args ...SplunkPair) {
+	Up1.StartArgs(context, function, args...)
+}
+
 // Complete is used for the exit of a function.
 func Complete(context interface{}, function string) {
 	Up1.Complete(context, function)
 }
 
+// TraceEnter is used for the entry into a function. It returns a function
+// for the exit that writes the values passed to it.
+func TraceEnter(context interface{}, function string) func(...interface{}) {
+	return Up1.TraceEnter(context, function)
+}
+
 // Completef is used for the exit of a function with a formatted message.
 func Completef(context interface{}, function string, format string, a ...interface{}) {
 	Up1.Completef(context, function, format, a...)
 }
 
+// CompleteReturns is used for the exit of a function with its return
+// values written as pairs in the var segment, in order.
+func CompleteReturns(context interface{}, function string, returns ...SplunkPair) {
+	Up1.CompleteReturns(context, function, returns...)
+}
+
 // CompleteErr is used to write an error with complete into the trace.
 func CompleteErr(err error, context interface{}, function string) {
 	Up1.CompleteErr(err, context, function)
@@ -56,6 +76,21 @@ func Errf(err error, context interface{}, function string, format string, a ...i
 	Up1.Errf(err, context, function, format, a...)
 }
 
+// WrapErr is used to write an error into the trace and return it, so an
+// error can be logged and returned in one line. A nil error writes nothing
+// and returns nil.
+func WrapErr(err error, context interface{}, function string) error {
+	return Up1.WrapErr(err, context, function)
+}
+
+// WrapErrf is used to write an error into the trace with a formatted
+// message and return it annotated with the message, like
+// fmt.Errorf("message: %w", err). A nil error writes nothing and returns
+// nil.
+func WrapErrf(err error, context interface{}, function string, format string, a ...interface{}) error {
+	return Up1.WrapErrf(err, context, function, format, a...)
+}
+
 // ErrFatal is used to write an error into the trace then terminate the program.
 func ErrFatal(err error, context interface{}, function string) {
 	Up1.ErrFatal(err, context, function)
@@ -81,6 +116,31 @@ func Tracef(context interface{}, function string, format string, a ...interface{
 	Up1.Tracef(context, function, format, a...)
 }
 
+// Trace0 is used to write information into the trace using the context
+// set by PushContext and the name of the calling function.
+func Trace0(message string) {
+	Up1.Trace0(message)
+}
+
+// TraceWithBlob is used to write information into the trace with a blob of
+// data stored in its own file in the directory set by SetBlobDir. The line
+// includes blob[ref] and the ref is returned.
+func TraceWithBlob(context interface{}, function string, message string, blob []byte) string {
+	return Up1.TraceWithBlob(context, function, message, blob)
+}
+
+// TraceObject is used to write the exported fields of a struct into the
+// trace as Field=value pairs on a single line. It is shallow, use
+// DataBlock for large or nested values.
+func TraceObject(context interface{}, function string, obj interface{}) {
+	Up1.TraceObject(context, function, obj)
+}
+
+// Section is used to write a separator line with a title into the trace.
+func Section(title string) {
+	Up1.Section(title)
+}
+
 // Warnf is used to write a warning into the trace with a formatted message.
 func Warnf(context interface{}, function string, format string, a ...interface{}) {
 	Up1.Warnf(context, function, format, a...)
@@ -96,6 +156,25 @@ func DataKV(context interface{}, function string, key string, value interface{})
 	Up1.DataKV(context, function, key, value)
 }
 
+// DataKVQuoted is used to write a key/value pair into the trace with the
+// value quoted and escaped like logfmt when it contains whitespace or
+// special characters.
+func DataKVQuoted(context interface{}, function string, key string, value interface{}) {
+	Up1.DataKVQuoted(context, function, key, value)
+}
+
+// DataList is used to write a list of values into the trace as
+// key: [a, b, c], formatted the same way as a SplunkValue.
+func DataList(context interface{}, function string, key string, values ...interface{}) {
+	Up1.DataList(context, function, key, values...)
+}
+
+// DataGroup is used to collect key/value pairs that are written into the
+// trace as a single data block when Emit is called.
+func DataGroup(context interface{}, function string) *Group {
+	return Up1.DataGroup(context, function)
+}
+
 // DataBlock is used to write a block of data into the trace.
 func DataBlock(context interface{}, function string, block interface{}) {
 	Up1.DataBlock(context, function, block)
@@ -111,6 +190,19 @@ func DataTrace(context interface{}, function string, formatters ...Formatter) {
 	Up1.DataTrace(context, function, formatters...)
 }
 
+// DataReader is used to write up to maxBytes read from r into the trace as
+// a block of data. Content that is not valid UTF-8 is written as a hex dump.
+// A maxBytes of 0 or less reads all of r.
+func DataReader(context interface{}, function string, r io.Reader, maxBytes int) {
+	Up1.DataReader(context, function, r, maxBytes)
+}
+
+// DataBinary is used to write a slice of bytes into the trace as a block
+// of data using the specified dump format.
+func DataBinary(context interface{}, function string, b []byte, format DumpFormat) {
+	Up1.DataBinary(context, function, b, format)
+}
+
 // Splunk is used to write a log message in a splunk-able format.
 func Splunk(m ...SplunkPair) {
 	Up1.Splunk(m...)
@@ -18,9 +18,9 @@ package log
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -37,186 +37,240 @@ var Up1 Uplevel = 1
 
 // Start is used for the entry into a function.
 func (lvl Uplevel) Start(context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevStart), "%s: %s[%d]: %s: %v: %s: Started:\n", dt, l.prefix, pid, file, context, funcName)
+	uplevel{lvl: lvl + 1}.Start(context, function)
 }
 
 // Startf is used for the entry into a function with a formatted message.
 func (lvl Uplevel) Startf(context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevStart), "%s: %s[%d]: %s: %v: %s: Started: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...))
+	uplevel{lvl: lvl + 1}.Startf(context, function, format, a...)
+}
+
+// StartArgs is used for the entry into a function with its arguments
+// written as pairs in the var segment, in order.
+func (lvl Uplevel) StartArgs(context interface{}, function string, args ...SplunkPair) {
+	uplevel{lvl: lvl + 1}.StartArgs(context, function, args...)
 }
 
 // Complete is used for the exit of a function.
 func (lvl Uplevel) Complete(context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevStart), "%s: %s[%d]: %s: %v: %s: Completed:\n", dt, l.prefix, pid, file, context, funcName)
+	uplevel{lvl: lvl + 1}.Complete(context, function)
+}
+
+// TraceEnter is used for the entry into a function. It returns a function
+// for the exit that writes the values passed to it.
+func (lvl Uplevel) TraceEnter(context interface{}, function string) func(...interface{}) {
+	return uplevel{lvl: lvl + 1}.TraceEnter(context, function)
 }
 
 // Completef is used for the exit of a function with a formatted message.
 func (lvl Uplevel) Completef(context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevStart), "%s: %s[%d]: %s: %v: %s: Completed: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...))
+	uplevel{lvl: lvl + 1}.Completef(context, function, format, a...)
+}
+
+// CompleteReturns is used for the exit of a function with its return
+// values written as pairs in the var segment, in order.
+func (lvl Uplevel) CompleteReturns(context interface{}, function string, returns ...SplunkPair) {
+	uplevel{lvl: lvl + 1}.CompleteReturns(context, function, returns...)
 }
 
 // CompleteErr is used to write an error with complete into the trace.
 func (lvl Uplevel) CompleteErr(err error, context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: Completed ERROR: %s", dt, l.prefix, pid, file, context, funcName, err)
+	uplevel{lvl: lvl + 1}.CompleteErr(err, context, function)
 }
 
 // CompleteErrf is used to write an error with complete into the trace with a formatted message.
 func (lvl Uplevel) CompleteErrf(err error, context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: Completed ERROR: %s: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...), err)
+	uplevel{lvl: lvl + 1}.CompleteErrf(err, context, function, format, a...)
 }
 
 // Err is used to write an error into the trace.
 func (lvl Uplevel) Err(err error, context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: ERROR: %s", dt, l.prefix, pid, file, context, funcName, err)
+	uplevel{lvl: lvl + 1}.Err(err, context, function)
 }
 
 // Errf is used to write an error into the trace with a formatted message.
 func (lvl Uplevel) Errf(err error, context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: ERROR: %s: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...), err)
+	uplevel{lvl: lvl + 1}.Errf(err, context, function, format, a...)
+}
+
+// WrapErr is used to write an error into the trace and return it, so an
+// error can be logged and returned in one line. A nil error writes nothing
+// and returns nil.
+func (lvl Uplevel) WrapErr(err error, context interface{}, function string) error {
+	return uplevel{lvl: lvl + 1}.WrapErr(err, context, function)
+}
+
+// WrapErrf is used to write an error into the trace with a formatted
+// message and return it annotated with the message, like
+// fmt.Errorf("message: %w", err). A nil error writes nothing and returns
+// nil.
+func (lvl Uplevel) WrapErrf(err error, context interface{}, function string, format string, a ...interface{}) error {
+	return uplevel{lvl: lvl + 1}.WrapErrf(err, context, function, format, a...)
 }
 
 // ErrFatal is used to write an error into the trace then terminate the program.
 func (lvl Uplevel) ErrFatal(err error, context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: ERROR: %s", dt, l.prefix, pid, file, context, funcName, err)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: TERMINATING\n", dt, l.prefix, pid, file, context, funcName)
-	Shutdown()
-	os.Exit(1)
+	uplevel{lvl: lvl + 1}.ErrFatal(err, context, function)
 }
 
 // ErrFatalf is used to write an error into the trace with a formatted message then terminate the program.
 func (lvl Uplevel) ErrFatalf(err error, context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: ERROR: %s: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...), err)
-	output(Dev.get(DevError), "%s: %s[%d]: %s: %v: %s: TERMINATING\n", dt, l.prefix, pid, file, context, funcName)
-	Shutdown()
-	os.Exit(1)
+	uplevel{lvl: lvl + 1}.ErrFatalf(err, context, function, format, a...)
 }
 
 // ErrPanic is used to write an error into the trace then panic the program.
 func (lvl Uplevel) ErrPanic(err error, context interface{}, function string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevPanic), "%s: %s[%d]: %s: %v: %s: ERROR: %s", dt, l.prefix, pid, file, context, funcName, err)
-	output(Dev.get(DevPanic), "%s: %s[%d]: %s: %v: %s: TERMINATING\n", dt, l.prefix, pid, file, context, funcName)
-	Shutdown()
-	panic("Terminating Program")
+	uplevel{lvl: lvl + 1}.ErrPanic(err, context, function)
 }
 
 // ErrPanicf is used to write an error into the trace with a formatted message then panic the program.
 func (lvl Uplevel) ErrPanicf(err error, context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevPanic), "%s: %s[%d]: %s: %v: %s: ERROR: %s: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...), err)
-	output(Dev.get(DevPanic), "%s: %s[%d]: %s: %v: %s: TERMINATING\n", dt, l.prefix, pid, file, context, funcName)
-	Shutdown()
-	panic("Terminating Program")
+	uplevel{lvl: lvl + 1}.ErrPanicf(err, context, function, format, a...)
 }
 
 // Tracef is used to write information into the trace with a formatted message.
 func (lvl Uplevel) Tracef(context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevTrace), "%s: %s[%d]: %s: %v: %s: Trace: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...))
+	uplevel{lvl: lvl + 1}.Tracef(context, function, format, a...)
+}
+
+// Trace0 is used to write information into the trace using the context
+// set by PushContext and the name of the calling function.
+func (lvl Uplevel) Trace0(message string) {
+	uplevel{lvl: lvl + 1}.Trace0(message)
+}
+
+// TraceWithBlob is used to write information into the trace with a blob of
+// data stored in its own file in the directory set by SetBlobDir. The line
+// includes blob[ref] and the ref is returned.
+func (lvl Uplevel) TraceWithBlob(context interface{}, function string, message string, blob []byte) string {
+	return uplevel{lvl: lvl + 1}.TraceWithBlob(context, function, message, blob)
+}
+
+// TraceObject is used to write the exported fields of a struct into the
+// trace as Field=value pairs on a single line. It is shallow, use
+// DataBlock for large or nested values.
+func (lvl Uplevel) TraceObject(context interface{}, function string, obj interface{}) {
+	uplevel{lvl: lvl + 1}.TraceObject(context, function, obj)
+}
+
+// Section is used to write a separator line with a title into the trace.
+func (lvl Uplevel) Section(title string) {
+	uplevel{lvl: lvl + 1}.Section(title)
 }
 
 // Warnf is used to write a warning into the trace with a formatted message.
 func (lvl Uplevel) Warnf(context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevWarning), "%s: %s[%d]: %s: %v: %s: Warning: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...))
+	uplevel{lvl: lvl + 1}.Warnf(context, function, format, a...)
 }
 
 // Queryf is used to write a query into the trace with a formatted message.
 func (lvl Uplevel) Queryf(context interface{}, function string, format string, a ...interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevQuery), "%s: %s[%d]: %s: %v: %s: Query: %s", dt, l.prefix, pid, file, context, funcName, fmt.Sprintf(format, a...))
+	uplevel{lvl: lvl + 1}.Queryf(context, function, format, a...)
 }
 
 // DataKV is used to write a key/value pair into the trace.
 func (lvl Uplevel) DataKV(context interface{}, function string, key string, value interface{}) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-	output(Dev.get(DevData), "%s: %s[%d]: %s: %v: %s: DATA: %s: %v", dt, l.prefix, pid, file, context, funcName, key, value)
+	uplevel{lvl: lvl + 1}.DataKV(context, function, key, value)
 }
 
-// DataBlock is used to write a block of data into the trace.
-func (lvl Uplevel) DataBlock(context interface{}, function string, block interface{}) {
-	if v, ok := block.(string); ok {
-		(lvl + 1).DataString(context, function, v)
-		return
-	}
+// DataKVQuoted is used to write a key/value pair into the trace with the
+// value quoted and escaped like logfmt when it contains whitespace or
+// special characters.
+func (lvl Uplevel) DataKVQuoted(context interface{}, function string, key string, value interface{}) {
+	uplevel{lvl: lvl + 1}.DataKVQuoted(context, function, key, value)
+}
 
-	d, err := json.MarshalIndent(block, "", "    ")
-	if err != nil {
-		d = []byte(err.Error())
-	}
+// DataList is used to write a list of values into the trace as
+// key: [a, b, c], formatted the same way as a SplunkValue.
+func (lvl Uplevel) DataList(context interface{}, function string, key string, values ...interface{}) {
+	uplevel{lvl: lvl + 1}.DataList(context, function, key, values...)
+}
 
-	(lvl + 1).DataString(context, function, string(d))
+// DataGroup is used to collect key/value pairs that are written into the
+// trace as a single data block when Emit is called.
+func (lvl Uplevel) DataGroup(context interface{}, function string) *Group {
+	return uplevel{lvl: lvl}.DataGroup(context, function)
+}
+
+// DataBlock is used to write a block of data into the trace.
+func (lvl Uplevel) DataBlock(context interface{}, function string, block interface{}) {
+	uplevel{lvl: lvl + 1}.DataBlock(context, function, block)
 }
 
 // DataString is used to write a string with CRLF each on their own line.
 func (lvl Uplevel) DataString(context interface{}, function string, message string) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
-
-	if message == "" {
-		output(Dev.get(DevData), "%s: %s[%d]: %s: %v: %s: DATA: %%!ds(MISSING)\n", dt, l.prefix, pid, file, context, funcName)
-		return
-	}
-
-	var buf bytes.Buffer
-
-	fmt.Fprintf(&buf, "%s: %s[%d]: %s: %v: %s: DATA:\n", dt, l.prefix, pid, file, context, funcName)
-
-	lines := bytes.Split([]byte(message), []byte{'\n'})
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
-		fmt.Fprintf(&buf, "\t%s\n", line)
-	}
-
-	output(Dev.get(DevData), buf.String())
+	uplevel{lvl: lvl + 1}.DataString(context, function, message)
 }
 
 // DataTrace is used to write a block of data from an io.Stringer respecting each line.
 func (lvl Uplevel) DataTrace(context interface{}, function string, formatters ...Formatter) {
-	dt, file, funcName, pid := dtFile(2+int(lvl), function)
+	uplevel{lvl: lvl + 1}.DataTrace(context, function, formatters...)
+}
 
-	var lines [][]byte
-	for _, f := range formatters {
-		if f != nil {
-			lines = append(lines, bytes.Split([]byte(f.Format()), []byte{'\n'})...)
-		}
-	}
+// DataReader is used to write up to maxBytes read from r into the trace as
+// a block of data. Content that is not valid UTF-8 is written as a hex dump.
+// A maxBytes of 0 or less reads all of r.
+func (lvl Uplevel) DataReader(context interface{}, function string, r io.Reader, maxBytes int) {
+	uplevel{lvl: lvl + 1}.DataReader(context, function, r, maxBytes)
+}
 
-	var buf bytes.Buffer
+// DataBinary is used to write a slice of bytes into the trace as a block
+// of data using the specified dump format.
+func (lvl Uplevel) DataBinary(context interface{}, function string, b []byte, format DumpFormat) {
+	uplevel{lvl: lvl + 1}.DataBinary(context, function, b, format)
+}
 
-	fmt.Fprintf(&buf, "%s: %s[%d]: %s: %v: %s: DATA:\n", dt, l.prefix, pid, file, context, funcName)
+// Group collects key/value pairs to be written as a single data block.
+type Group struct {
+	u        uplevel
+	logger   *Logger
+	context  interface{}
+	function string
+	buf      bytes.Buffer
+	n        int
+}
 
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
-		fmt.Fprintf(&buf, "\t%s\n", line)
+// KV adds a key/value pair to the group.
+func (g *Group) KV(key string, value interface{}) *Group {
+	g.n++
+	if keep, _ := fieldLimit(g.n); keep < g.n {
+		return g
 	}
 
-	message := buf.String()
-	if message == "" {
-		output(Dev.get(DevData), "\t%%!ds(MISSING)\n")
+	fmt.Fprintf(&g.buf, "%s: %s\n", key, dataValue(value))
+	return g
+}
+
+// Emit writes the collected pairs into the trace. Nothing is written
+// if no pairs were added.
+func (g *Group) Emit() {
+	if g.buf.Len() == 0 {
+		return
+	}
+	if g.logger != nil && g.logger.logLevel() < LevelOutput {
 		return
 	}
 
-	output(Dev.get(DevData), message)
+	msg := g.buf.String()
+	if _, more := fieldLimit(g.n); more > 0 {
+		msg += fmt.Sprintf("...(+%d more)\n", more)
+	}
+
+	g.u.DataString(g.context, g.function, msg)
 }
 
 // splunkEncode encodes a value to be splunkable.
 // If a value is a string that contains space character(s), that value will be
-// encompassed within double quotes.
+// encompassed within double quotes. A Duration is written in milliseconds
+// and a value with a formatter set by RegisterValueFormatter is written
+// with it.
 func splunkEncode(ifc interface{}) string {
+	if v, ok := customValue(ifc); ok {
+		ifc = v
+	}
+	if d, ok := ifc.(Duration); ok {
+		return strconv.FormatInt(d.Millis(), 10)
+	}
 	if v, ok := ifc.(string); ok && strings.Contains(v, " ") {
 		return fmt.Sprintf("%q", v)
 	}
@@ -250,23 +304,58 @@ type SplunkPair struct {
 	Value interface{}
 }
 
+// writeSplunkPair writes the pair into the buffer as key=value with
+// a leading space.
+func writeSplunkPair(buf *bytes.Buffer, p SplunkPair) {
+	buf.WriteString(" ")
+	buf.WriteString(splunkEncode(p.Key))
+	buf.WriteString("=")
+	buf.WriteString(splunkEncode(p.Value))
+}
+
 // Splunk is used to write a log message in a splunk-able format.
 func (lvl Uplevel) Splunk(m ...SplunkPair) {
 	var buf bytes.Buffer
 
-	for _, i := range m {
-		buf.WriteString(" ")
-		buf.WriteString(splunkEncode(i.Key))
-		buf.WriteString("=")
-		buf.WriteString(splunkEncode(i.Value))
+	if atomic.LoadInt32(&l.splunkCaller) == 1 {
+		_, file, funcName, _ := dtFile(2+int(lvl), "")
+		if i := strings.LastIndex(file, "#"); i >= 0 {
+			writeSplunkPair(&buf, SplunkPair{Key: "file", Value: file[:i]})
+			writeSplunkPair(&buf, SplunkPair{Key: "line", Value: file[i+1:]})
+		}
+		writeSplunkPair(&buf, SplunkPair{Key: "func", Value: funcName})
 	}
 
+	keep, more := fieldLimit(len(m))
+	for _, i := range m[:keep] {
+		writeSplunkPair(&buf, i)
+	}
+	if more > 0 {
+		fmt.Fprintf(&buf, " ...(+%d more)", more)
+	}
+
+	now := clockNow()
+
 	var dateTime string
-	if atomic.LoadInt32(&l.test) == 1 {
-		dateTime = time.Date(2009, time.November, 10, 15, 0, 0, 0, time.UTC).UTC().Format(layout)
-	} else {
-		dateTime = time.Now().UTC().Format(layout)
+	switch SplunkTimeFormat(atomic.LoadInt32(&l.splunkTime)) {
+	case SplunkTimeEpoch:
+		dateTime = strconv.FormatInt(now.Unix(), 10)
+	case SplunkTimeEpochMillis:
+		dateTime = strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		dateTime = formatTime(now)
+	}
+
+	w := Dev.get(DevSplunk)
+	if w == nil {
+		return
+	}
+
+	ln := dateTime + ":" + buf.String()
+	if !passes(DevSplunk, NoContext, "", ln) {
+		return
 	}
 
-	output(Dev.get(DevSplunk), "%s:%s\n", dateTime, buf.String())
+	countTag(DevSplunk)
+	output(w, "%s\n", ln)
 }
@@ -104,6 +104,33 @@ func ExampleDataKV() {
 	// 2009/11/10 15:00:00.000000000: EXAMPLE[69910]: file.go#512: 1234: Data_KV: Completed:
 }
 
+// ExampleDataGroup provides an example of logging a group of K/V pair data.
+func ExampleDataGroup() {
+	// Init the log system using a buffer for testing.
+	buf := new(log.SafeBuffer)
+	log.InitTest("EXAMPLE", 10, log.DevWriter{Device: log.DevAll, Writer: buf})
+
+	{
+		log.Start("1234", "Data_Group")
+
+		g := log.DataGroup("1234", "Data_Group")
+		g.KV("Value 1", 1)
+		g.KV("Hex Value 2", 0x00000002)
+		g.Emit()
+
+		log.Complete("1234", "Data_Group")
+	}
+
+	log.Shutdown()
+	fmt.Println(buf.String())
+	// Output:
+	// 2009/11/10 15:00:00.000000000: EXAMPLE[69910]: file.go#512: 1234: Data_Group: Started:
+	// 2009/11/10 15:00:00.000000000: EXAMPLE[69910]: file.go#512: 1234: Data_Group: DATA:
+	// 	Value 1: 1
+	// 	Hex Value 2: 2
+	// 2009/11/10 15:00:00.000000000: EXAMPLE[69910]: file.go#512: 1234: Data_Group: Completed:
+}
+
 // ExampleDataBlock provides an example of logging a block of data.
 func ExampleDataBlock() {
 	// Init the log system using a buffer for testing.
@@ -297,6 +324,37 @@ func BenchmarkTracef(b *testing.B) {
 	}
 }
 
+// BenchmarkTracefSourceLocation uses Init instead of InitTest since test mode
+// never looks up the caller.
+func BenchmarkTracefSourceLocation(b *testing.B) {
+	log.Init("BENCHMARK", 10, log.DevWriter{Device: log.DevAll, Writer: ioutil.Discard})
+	for i := 0; i < b.N; i++ {
+		log.Tracef("context", "function", "This is a test %d this is a test %d this is a test %d", i, i, i)
+	}
+}
+
+func BenchmarkTracefNoSourceLocation(b *testing.B) {
+	log.Init("BENCHMARK", 10, log.DevWriter{Device: log.DevAll, Writer: ioutil.Discard})
+	log.SetSourceLocation(false)
+	defer log.SetSourceLocation(true)
+	for i := 0; i < b.N; i++ {
+		log.Tracef("context", "function", "This is a test %d this is a test %d this is a test %d", i, i, i)
+	}
+}
+
+// BenchmarkTracefSustained logs from several goroutines at once with a
+// buffer large enough that lines are not dropped.
+func BenchmarkTracefSustained(b *testing.B) {
+	log.Init("BENCHMARK", 1000, log.DevWriter{Device: log.DevAll, Writer: ioutil.Discard})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			log.Tracef("context", "function", "This is a test %d this is a test %d this is a test %d", i, i, i)
+		}
+	})
+	log.Shutdown()
+}
+
 // ExampleSplunk provides an example of logging a message in a splunk-able format.
 func ExampleSplunk() {
 	// Init the log system using a buffer for testing.
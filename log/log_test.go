@@ -18,9 +18,16 @@ package log_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -39,6 +46,12 @@ const succeed = "\u2713"
 // failed is the Unicode codepoint for an X mark.
 const failed = "\u2717"
 
+// TestMain shortens the waits in Shutdown so the tests run faster.
+func TestMain(m *testing.M) {
+	log.SetTestTimings(0, time.Millisecond, 0)
+	os.Exit(m.Run())
+}
+
 // logdest implements io.Writer and is the log package destination.
 var logdest log.SafeBuffer
 
@@ -148,6 +161,11 @@ func TestLogBlocking(t *testing.T) {
 
 	log.InitTest("LOG", 0, log.DevWriter{Device: log.DevAll, Writer: &bw})
 
+	// The writes are counted by bulk log period, so the first line isn't
+	// flushed early.
+	log.SetIdleFlushDelay(0)
+	defer log.SetIdleFlushDelay(10 * time.Millisecond)
+
 	t.Log("Given the need to make sure the logging doesn't stop the program.")
 	{
 		var wg sync.WaitGroup
@@ -226,6 +244,90 @@ func TestLogBlocking2(t *testing.T) {
 	}
 }
 
+// TestShutdownWhileLogging tests that logging while Shutdown runs doesn't
+// panic and that nothing is written once Shutdown returns.
+func TestShutdownWhileLogging(t *testing.T) {
+	t.Log("Given the need to shutdown while goroutines are still logging.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		var stop int32
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for atomic.LoadInt32(&stop) == 0 {
+					log.Tracef("TEST", "TestShutdownWhileLogging", "Log: %d", i)
+				}
+			}(i)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		log.Shutdown()
+		after := buf.String()
+
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&stop, 1)
+		wg.Wait()
+
+		if got := buf.String(); got == after {
+			t.Log("\tShould not write anything after Shutdown returns.", succeed)
+		} else {
+			t.Errorf("\tShould not write anything after Shutdown returns. %s %d bytes", failed, len(got)-len(after))
+		}
+	}
+}
+
+// TestConcurrentReconfigure tests that logging, changing devices and
+// flushing can all happen at the same time. Run it with -race.
+func TestConcurrentReconfigure(t *testing.T) {
+	t.Log("Given the need to reconfigure devices while logging.")
+	{
+		var buf1, buf2 log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf1})
+
+		var stop int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for atomic.LoadInt32(&stop) == 0 {
+					log.Tracef("TEST", "TestConcurrentReconfigure", "Log: %d", i)
+					log.DataKV("TEST", "TestConcurrentReconfigure", "i", i)
+				}
+			}(i)
+		}
+
+		for i := 0; i < 50; i++ {
+			if i%2 == 0 {
+				log.Dev.Trace(&buf2)
+			} else {
+				log.Dev.All(&buf1)
+			}
+			log.Flush()
+		}
+
+		atomic.StoreInt32(&stop, 1)
+		wg.Wait()
+
+		// Flush first in case the load turned logging off.
+		log.Flush()
+		log.Tracef("TEST", "TestConcurrentReconfigure", "Last")
+		log.Flush()
+		got := buf1.String() + buf2.String()
+		log.Shutdown()
+
+		if strings.Contains(got, "Trace: Last\n") {
+			t.Log("\tShould have written the last line after Flush.", succeed)
+		} else {
+			t.Error("\tShould have written the last line after Flush.", failed)
+		}
+	}
+}
+
 // TestLoggingLevels tests that each logging level is working.
 func TestLoggingLevels(t *testing.T) {
 	t.Log("Given the need to test different logging levels.")
@@ -352,12 +454,57 @@ func TestLoggingUpLevels(t *testing.T) {
 	}
 }
 
+// TestDataAt tests that the leveled data calls respect the passed level.
+func TestDataAt(t *testing.T) {
+	t.Log("Given the need to gate data calls at a specific level.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 0, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		l := log.NewLogger("test", func() int { return log.LevelOutput })
+
+		// Emitted : Total 7
+		l.DataKVAt(log.LevelOutput, "A", "B", "C", "D")                      // 1 line
+		l.DataBlockAt(log.LevelWarning, "A", "B", "C")                       // 2 lines
+		l.Up1.DataStringAt(log.LevelOutput, "A", "B", "C")                   // 2 lines
+		l.DataTraceAt(log.LevelError, "A", "B", Message([]byte{0xEE, 0xEF})) // 2 lines
+
+		// Not emitted
+		l.DataKVAt(log.LevelTrace, "A", "B", "C", "D")
+		l.DataBlockAt(log.LevelTrace, "A", "B", "C")
+		l.DataStringAt(log.LevelTrace, "A", "B", "C")
+		l.Up1.DataTraceAt(log.LevelTrace, "A", "B", Message([]byte{0xEE, 0xEF}))
+
+		log.Shutdown()
+
+		got := strings.Split(buf.String(), "\n")
+		if len(got) == 8 {
+			t.Log("\tShould see 7 trace lines.", succeed)
+		} else {
+			t.Errorf("\tShould see 7 trace lines. %s %d", failed, len(got)-1)
+		}
+	}
+}
+
 type SomeFormatter struct{}
 
 func (SomeFormatter) Format() string {
 	return "42"
 }
 
+// ObjectBase is embedded in Object to test TraceObject.
+type ObjectBase struct {
+	ID int
+}
+
+// Object is used to test TraceObject.
+type Object struct {
+	ObjectBase
+	Name   string
+	Tags   []string
+	secret string
+}
+
 type EmptyFormatter struct{}
 
 func (EmptyFormatter) Format() string {
@@ -378,9 +525,15 @@ func TestLoggingFuncs(t *testing.T) {
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: walrus[500]\n", func() {
 				log.Startf(context, "foo", "walrus[%d]", 500)
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: id[7]: name[bob]\n", func() {
+				log.StartArgs(context, "foo", log.SplunkPair{Key: "id", Value: 7}, log.SplunkPair{Key: "name", Value: "bob"})
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed:\n", func() {
 				log.Complete(context, "bar")
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: ok[true]\n", func() {
+				log.CompleteReturns(context, "bar", log.SplunkPair{Key: "ok", Value: true})
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: horse[3]\n", func() {
 				log.Completef(context, "bar", "horse[%d]", 3)
 			}},
@@ -396,6 +549,12 @@ func TestLoggingFuncs(t *testing.T) {
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: D\n", func() {
 				log.Errf(errors.New("D"), context, "bee", "ip[%s]", "127.0.0.1")
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: ERROR: W\n", func() {
+				log.WrapErr(errors.New("W"), context, "boo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: W\n", func() {
+				log.WrapErrf(errors.New("W"), context, "bee", "ip[%s]", "127.0.0.1")
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: faa: Trace: len[13]\n", func() {
 				log.Tracef(context, "faa", "len[%d]", 13)
 			}},
@@ -405,9 +564,39 @@ func TestLoggingFuncs(t *testing.T) {
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: beer: Query: howmany[0]\n", func() {
 				log.Queryf(context, "beer", "howmany[%d]", 0)
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: obj: Trace: ID=7 Name=\"Jane Doe\" Tags=\"[a b]\"\n", func() {
+				log.TraceObject(context, "obj", &Object{ObjectBase: ObjectBase{ID: 7}, Name: "Jane Doe", Tags: []string{"a", "b"}, secret: "x"})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: obj: Trace: value=42\n", func() {
+				log.TraceObject(context, "obj", 42)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ==== phase " + strings.Repeat("=", 69) + "\n", func() {
+				log.Section("phase")
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: 2b: !2b\n", func() {
 				log.DataKV(context, "oom", "2b", "!2b")
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: k: \"a: b\"\n", func() {
+				log.DataKVQuoted(context, "oom", "k", "a: b")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: k: \"line1\\nline2\"\n", func() {
+				log.DataKVQuoted(context, "oom", "k", "line1\nline2")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: 2b: !2b\n", func() {
+				log.DataKVQuoted(context, "oom", "2b", "!2b")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: ids: [1, 2, \"a b\"]\n", func() {
+				log.DataList(context, "oom", "ids", 1, 2, "a b")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: ids: []\n", func() {
+				log.DataList(context, "oom", "ids")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA:\n\ta: 1\n\tb: two\n", func() {
+				log.DataGroup(context, "oom").KV("a", 1).KV("b", "two").Emit()
+			}},
+			{"", func() {
+				log.DataGroup(context, "oom").Emit()
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\tasdf I'm running out of ideas.\n", func() {
 				log.DataBlock(context, "moo", "asdf I'm running out of ideas.")
 			}},
@@ -426,9 +615,33 @@ func TestLoggingFuncs(t *testing.T) {
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n\t42\n", func() {
 				log.DataTrace(context, "aah", SomeFormatter{})
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: rdr: DATA:\n\tline 1\n\tline 2\n", func() {
+				log.DataReader(context, "rdr", strings.NewReader("line 1\nline 2\n"), 100)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: rdr: DATA:\n\tline\n\tTRUNCATED: more than 4 bytes\n", func() {
+				log.DataReader(context, "rdr", strings.NewReader("line 1\nline 2\n"), 4)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: rdr: DATA:\n\tline 1\n\tline 2\n", func() {
+				log.DataReader(context, "rdr", strings.NewReader("line 1\nline 2\n"), -1)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: rdr: DATA:\n\tcaf\n\tTRUNCATED: more than 4 bytes\n", func() {
+				log.DataReader(context, "rdr", strings.NewReader("café au lait"), 4)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: rdr: DATA:\n\t(0x0000) EE 6E 11 00 00 00 3E EA DE 18 00 00 2D 00 00 00\n\t(0x0010) 3E EA DE\n", func() {
+				log.DataReader(context, "rdr", bytes.NewReader([]byte{0xEE, 0x6E, 0x11, 0x00, 0x00, 0x00, 0x3E, 0xEA, 0xDE, 0x18, 0x00, 0x00, 0x2D, 0x00, 0x00, 0x00, 0x3E, 0xEA, 0xDE}), 100)
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n", func() {
 				log.DataTrace(context, "aah", EmptyFormatter{})
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bin: DATA:\n\t(0x0000) 48 65 6C 6C 6F 2C 20 57 6F 72 6C 64 21 0A\n", func() {
+				log.DataBinary(context, "bin", []byte("Hello, World!\n"), log.DumpHex)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bin: DATA:\n\t00000000  48 65 6c 6c 6f 2c 20 57  6f 72 6c 64 21 0a        |Hello, World!.|\n\t0000000e\n", func() {
+				log.DataBinary(context, "bin", []byte("Hello, World!\n"), log.DumpHexASCII)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bin: DATA:\n\tSGVsbG8sIFdvcmxkIQo=\n", func() {
+				log.DataBinary(context, "bin", []byte("Hello, World!\n"), log.DumpBase64)
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n", func() {
 				log.DataTrace(context, "aah", nil)
 			}},
@@ -450,6 +663,49 @@ func TestLoggingFuncs(t *testing.T) {
 	}
 }
 
+// slowWriter delays each write to simulate a slow device.
+type slowWriter struct {
+	w     io.Writer
+	delay time.Duration
+}
+
+func (sw slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(sw.delay)
+	return sw.w.Write(b)
+}
+
+func TestErrFatalFlush(t *testing.T) {
+	if os.Getenv("LOG_FATAL_CHILD") == "1" {
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevTrace, Writer: slowWriter{w: os.Stdout, delay: 500 * time.Millisecond}},
+			log.DevWriter{Device: log.DevError, Writer: os.Stdout},
+		)
+		log.Tracef("TEST", "TestErrFatalFlush", "pending")
+		log.ErrFatal(errors.New("A"), "TEST", "TestErrFatalFlush")
+		return
+	}
+
+	t.Log("Given the need to keep buffered lines when the program terminates.")
+	{
+		cmd := exec.Command(os.Args[0], "-test.run=^TestErrFatalFlush$")
+		cmd.Env = append(os.Environ(), "LOG_FATAL_CHILD=1")
+		out, err := cmd.Output()
+
+		if ee, ok := err.(*exec.ExitError); ok && !ee.Success() {
+			t.Log("\tShould exit with an error.", succeed)
+		} else {
+			t.Errorf("\tShould exit with an error. %s %v", failed, err)
+		}
+
+		const exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrFatalFlush: Trace: pending\n"
+		if strings.Contains(string(out), exp) {
+			t.Log("\tShould write the pending trace line to its slow device.", succeed)
+		} else {
+			t.Errorf("\tShould write the pending trace line to its slow device. %s %q", failed, out)
+		}
+	}
+}
+
 func TestErrPanic(t *testing.T) {
 	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
 	defer func() {
@@ -494,152 +750,1755 @@ func TestErrPanicf(t *testing.T) {
 	log.ErrPanicf(errors.New("A"), "TEST", "TestErrPanic", "we're doomed -%s-", "bender")
 }
 
-func TestLoggerFuncs(t *testing.T) {
-	t.Log("Given the need to call all different logging calls.")
+func TestPanicAsError(t *testing.T) {
+	t.Log("Given the need to test code that calls ErrPanic without unwinding.")
 	{
-		const context = "TEST"
-		cases := []struct {
-			expected string
-			f        func(*log.Logger)
-		}{
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started:\n", func(ll *log.Logger) {
-				ll.Start(context, "foo")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: walrus[500]\n", func(ll *log.Logger) {
-				ll.Startf(context, "foo", "walrus[%d]", 500)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed:\n", func(ll *log.Logger) {
-				ll.Complete(context, "bar")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: horse[3]\n", func(ll *log.Logger) {
-				ll.Completef(context, "bar", "horse[%d]", 3)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: baz: Completed ERROR: A\n", func(ll *log.Logger) {
-				ll.CompleteErr(errors.New("A"), context, "baz")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: baz: Completed ERROR: puppies[777]: B\n", func(ll *log.Logger) {
-				ll.CompleteErrf(errors.New("B"), context, "baz", "puppies[%d]", 777)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: ERROR: C\n", func(ll *log.Logger) {
-				ll.Err(errors.New("C"), context, "boo")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: D\n", func(ll *log.Logger) {
-				ll.Errf(errors.New("D"), context, "bee", "ip[%s]", "127.0.0.1")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: faa: Trace: len[13]\n", func(ll *log.Logger) {
-				ll.Tracef(context, "faa", "len[%d]", 13)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: fii: Warning: usage[99.900000]\n", func(ll *log.Logger) {
-				ll.Warnf(context, "fii", "usage[%f]", 99.9)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: beer: Query: howmany[0]\n", func(ll *log.Logger) {
-				ll.Queryf(context, "beer", "howmany[%d]", 0)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: 2b: !2b\n", func(ll *log.Logger) {
-				ll.DataKV(context, "oom", "2b", "!2b")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\tasdf I'm running out of ideas.\n", func(ll *log.Logger) {
-				ll.DataBlock(context, "moo", "asdf I'm running out of ideas.")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\t5\n", func(ll *log.Logger) {
-				ll.DataBlock(context, "moo", 5)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\tjson: unsupported value: NaN\n", func(ll *log.Logger) {
-				ll.DataBlock(context, "moo", math.NaN())
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: DATA:\n\tnot\n\tthat\n\tthey\n\twere\n\tvery\n\tgood to start with.\n", func(ll *log.Logger) {
-				ll.DataString(context, "boo", "not\nthat\nthey\nwere\nvery\ngood to start with.")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n\t42\n", func(ll *log.Logger) {
-				ll.DataTrace(context, "aah", SomeFormatter{})
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n", func(ll *log.Logger) {
-				ll.DataTrace(context, "aah", EmptyFormatter{})
-			}},
-		}
-		for _, tt := range cases {
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetPanicAsError(true)
+		defer log.SetPanicAsError(false)
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Error("\tShould return instead of panicking.", failed)
+				} else {
+					t.Log("\tShould return instead of panicking.", succeed)
+				}
+			}()
+			log.ErrPanic(errors.New("A"), "TEST", "foo")
+			log.ErrPanicf(errors.New("B"), "TEST", "foo", "b")
+		}()
 
-			resetLog()
-			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-			ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-			tt.f(ll)
-			log.Shutdown()
+		log.Tracef("TEST", "foo", "still logging")
+		log.Shutdown()
 
-			got := logdest.String()
-			if got != tt.expected {
-				t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-				continue
-			}
-			t.Log("\t\tLog should match expected.", succeed)
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: TERMINATING\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: b: B\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: TERMINATING\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: still logging\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the lines and keep logging.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines and keep logging. %s %q", failed, got)
 		}
 	}
 }
 
-func TestLoggerErrPanic(t *testing.T) {
-	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("\t\tErrPanic should have panicked.", failed)
+func TestSynchronous(t *testing.T) {
+	t.Log("Given the need to see lines on the device as soon as they are logged.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetSynchronous(true)
+		defer log.SetSynchronous(false)
+
+		log.Tracef("TEST", "foo", "right away")
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: right away\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the line before the call returns.", succeed)
 		} else {
-			t.Log("\t\tErrPanic should have panicked.", succeed)
+			t.Errorf("\tShould write the line before the call returns. %s %q", failed, got)
 		}
 
-		got := logdest.String()
-		if got != expected {
-			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-			return
+		log.Shutdown()
+	}
+}
+
+func TestSeveritySampling(t *testing.T) {
+	t.Log("Given the need to keep all errors but only some trace lines.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetSeveritySampling(map[int]float64{log.LevelTrace: 0, log.LevelError: 1})
+		defer log.SetSeveritySampling(nil)
+
+		before := log.SampledOut()
+		for i := 0; i < 10; i++ {
+			log.Tracef("TEST", "foo", "trace %d", i)
 		}
-		t.Log("\t\tLog should match expected.", succeed)
-	}()
+		log.Errf(errors.New("A"), "TEST", "foo", "kept")
+		log.Warnf("TEST", "foo", "kept")
+		log.Shutdown()
 
-	resetLog()
-	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-	ll.ErrPanic(errors.New("A"), "TEST", "TestErrPanic")
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: kept: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: kept\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould drop the trace lines and keep the rest.", succeed)
+		} else {
+			t.Errorf("\tShould drop the trace lines and keep the rest. %s %q", failed, got)
+		}
+
+		if n := log.SampledOut() - before; n == 10 {
+			t.Log("\tShould count the dropped lines.", succeed)
+		} else {
+			t.Errorf("\tShould count the dropped lines. %s %d", failed, n)
+		}
+	}
 }
 
-func TestLoggerErrPanicf(t *testing.T) {
-	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: we're doomed -bender-: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("\t\tErrPanicf should have panicked.", failed)
+func TestFlushPeriod(t *testing.T) {
+	t.Log("Given the need to flush some devices sooner than others.")
+	{
+		var errBuf, traceBuf log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevError, Writer: &errBuf},
+			log.DevWriter{Device: log.DevTrace, Writer: &traceBuf})
+
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		log.SetBulkLogPeriod(time.Hour)
+		log.Dev.SetFlushPeriod(log.DevError, 10*time.Millisecond)
+		defer log.Dev.SetFlushPeriod(log.DevAll, 0)
+		log.SetIdleFlushDelay(0)
+		defer log.SetIdleFlushDelay(10 * time.Millisecond)
+
+		log.Err(errors.New("A"), "TEST", "foo")
+		log.Tracef("TEST", "foo", "later")
+		time.Sleep(200 * time.Millisecond)
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A\n"
+		if got := errBuf.String(); got == exp {
+			t.Log("\tShould flush the error device after its own period.", succeed)
 		} else {
-			t.Log("\t\tErrPanicf should have panicked.", succeed)
+			t.Errorf("\tShould flush the error device after its own period. %s %q", failed, got)
 		}
 
-		got := logdest.String()
-		if got != expected {
-			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-			return
+		if got := traceBuf.String(); got == "" {
+			t.Log("\tShould keep buffering the trace device.", succeed)
+		} else {
+			t.Errorf("\tShould keep buffering the trace device. %s %q", failed, got)
 		}
-		t.Log("\t\tLog should match expected.", succeed)
-	}()
 
-	resetLog()
-	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-	ll.ErrPanicf(errors.New("A"), "TEST", "TestErrPanic", "we're doomed -%s-", "bender")
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("\tShould ignore a device that doesn't exist. %s %v", failed, r)
+				}
+			}()
+			log.Dev.SetFlushPeriod(-1, time.Second)
+			t.Log("\tShould ignore a device that doesn't exist.", succeed)
+		}()
+
+		log.Shutdown()
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: later\n"
+		if got := traceBuf.String(); got == exp {
+			t.Log("\tShould write the trace device on shutdown.", succeed)
+		} else {
+			t.Errorf("\tShould write the trace device on shutdown. %s %q", failed, got)
+		}
+	}
 }
 
-func TestUpLoggerFuncs(t *testing.T) {
-	t.Log("Given the need to call all different logging calls.")
+// stuckWriter blocks every write until release is closed.
+type stuckWriter struct {
+	release chan struct{}
+	buf     log.SafeBuffer
+}
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	t.Log("Given the need to stop waiting on a device that is stuck.")
 	{
-		const context = "TEST"
-		cases := []struct {
-			expected string
-			f        func(*log.UplevelLogger)
-		}{
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started:\n", func(ll *log.UplevelLogger) {
-				ll.Start(context, "foo")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: walrus[500]\n", func(ll *log.UplevelLogger) {
-				ll.Startf(context, "foo", "walrus[%d]", 500)
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed:\n", func(ll *log.UplevelLogger) {
-				ll.Complete(context, "bar")
-			}},
-			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: horse[3]\n", func(ll *log.UplevelLogger) {
-				ll.Completef(context, "bar", "horse[%d]", 3)
+		var errBuf log.SafeBuffer
+		sw := stuckWriter{release: make(chan struct{})}
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevError, Writer: &errBuf},
+			log.DevWriter{Device: log.DevTrace, Writer: &sw})
+
+		log.Dev.SetWriteTimeout(log.DevTrace, 50*time.Millisecond)
+		defer log.Dev.SetWriteTimeout(log.DevAll, 0)
+
+		before := log.WriteTimeouts()
+
+		log.Tracef("TEST", "foo", "A")
+		log.Flush()
+		log.Tracef("TEST", "foo", "B")
+		log.Err(errors.New("E"), "TEST", "foo")
+		log.Flush()
+
+		if got := log.WriteTimeouts() - before; got == 2 {
+			t.Log("\tShould count the abandoned and the dropped flush.", succeed)
+		} else {
+			t.Errorf("\tShould count the abandoned and the dropped flush. %s %d", failed, got)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: E\n"
+		if got := errBuf.String(); got == exp {
+			t.Log("\tShould keep writing the other devices.", succeed)
+		} else {
+			t.Errorf("\tShould keep writing the other devices. %s %q", failed, got)
+		}
+
+		// Once the stuck write returns the device is written again.
+		close(sw.release)
+		time.Sleep(100 * time.Millisecond)
+
+		log.Tracef("TEST", "foo", "C")
+		log.Shutdown()
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: C\n"
+		if got := sw.buf.String(); got == exp {
+			t.Log("\tShould write the device again once the stuck write returns.", succeed)
+		} else {
+			t.Errorf("\tShould write the device again once the stuck write returns. %s %q", failed, got)
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("\tShould ignore a device that doesn't exist. %s %v", failed, r)
+				}
+			}()
+			log.Dev.SetWriteTimeout(-1, time.Second)
+			t.Log("\tShould ignore a device that doesn't exist.", succeed)
+		}()
+	}
+}
+
+func TestNoContext(t *testing.T) {
+	t.Log("Given the need to log without a context.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		log.Start(log.NoContext, "foo")
+		log.Tracef(log.NoContext, "foo", "hello")
+		log.DataString(log.NoContext, "foo", "a\nb")
+		log.Tracef("", "foo", "empty")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: foo: Started:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: foo: Trace: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: foo: DATA:\n" +
+			"\ta\n" +
+			"\tb\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: : foo: Trace: empty\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould leave the context segment out of the line.", succeed)
+		} else {
+			t.Errorf("\tShould leave the context segment out of the line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestCompactTags(t *testing.T) {
+	t.Log("Given the need to shrink the tags of a high volume log.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetCompactTags(true)
+
+		log.Start("TEST", "foo")
+		log.CompleteErr(errors.New("A"), "TEST", "foo")
+		log.Err(errors.New("B"), "TEST", "foo")
+		log.Warnf("TEST", "foo", "w")
+		log.Queryf("TEST", "foo", "q")
+		log.DataKV("TEST", "foo", "k", "v")
+		log.DataString("TEST", "foo", "a")
+		log.SetCompactTags(false)
+		log.Complete("TEST", "foo")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: S:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: X: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: E: B\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: W: w\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Q: q\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: D: k: v\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: D:\n" +
+			"\ta\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed:\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write each tag as a single character.", succeed)
+		} else {
+			t.Errorf("\tShould write each tag as a single character. %s %q", failed, got)
+		}
+	}
+}
+
+func TestFuncNameStyle(t *testing.T) {
+	t.Log("Given the need to shorten the function names that are looked up.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetFuncNameStyle(log.FuncFull)
+
+		trace := func() {
+			log.Tracef("TEST", "", "hello")
+		}
+
+		trace()
+		log.SetFuncNameStyle(log.FuncShort)
+		trace()
+		log.SetFuncNameStyle(log.FuncBase)
+		trace()
+		log.Tracef("TEST", "foo.Bar", "passed in")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: log_test.TestFuncNameStyle.func1: Trace: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestFuncNameStyle.func1: Trace: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: func1: Trace: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo.Bar: Trace: passed in\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the function name in the style set.", succeed)
+		} else {
+			t.Errorf("\tShould write the function name in the style set. %s %q", failed, got)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	t.Log("Given the need to write a duration for people and dashboards.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		d := log.Duration(1200 * time.Millisecond)
+		log.DataKV("TEST", "foo", "elapsed", d)
+		log.Splunk(log.SplunkPair{Key: "elapsed_ms", Value: d})
+		log.Event("done", map[string]interface{}{"elapsed_ms": d})
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: elapsed: 1.2s\n" +
+			"2009/11/10 15:00:00.000000000: elapsed_ms=1200\n" +
+			`{"time":"2009-11-10T15:00:00Z","app":"LOG","pid":69910,"event":"done","fields":{"elapsed_ms":1200}}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write text in the trace line and milliseconds for machines.", succeed)
+		} else {
+			t.Errorf("\tShould write text in the trace line and milliseconds for machines. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSetTestFixture(t *testing.T) {
+	t.Log("Given the need to match golden files written with other fixed values.")
+	{
+		var buf log.SafeBuffer
+		log.SetTestFixture(time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC), "main.go#1", 42)
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		log.Tracef("TEST", "foo", "hello")
+		log.Event("done", nil)
+		log.Shutdown()
+		log.SetTestFixture(time.Date(2009, time.November, 10, 15, 0, 0, 0, time.UTC), "file.go#512", 69910)
+
+		exp := "2020/01/02 03:04:05.000000000: LOG[42]: main.go#1: TEST: foo: Trace: hello\n" +
+			`{"time":"2020-01-02T03:04:05Z","app":"LOG","pid":42,"event":"done"}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the time, file and pid that were set.", succeed)
+		} else {
+			t.Errorf("\tShould write the time, file and pid that were set. %s %q", failed, got)
+		}
+	}
+}
+
+// orderWriter records the name of the writer on a shared list each time it
+// is written.
+type orderWriter struct {
+	name  string
+	mu    *sync.Mutex
+	order *[]string
+}
+
+func (w orderWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	*w.order = append(*w.order, w.name)
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+func TestShutdownOrder(t *testing.T) {
+	t.Log("Given the need to write the last lines in the same order every time.")
+	{
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		defer log.Dev.SetImmediate(log.DevError, true)
+		log.Dev.SetImmediate(log.DevError, false)
+
+		for i := 0; i < 5; i++ {
+			var mu sync.Mutex
+			var order []string
+			log.InitTest("LOG", 10,
+				log.DevWriter{Device: log.DevStart, Writer: orderWriter{"start", &mu, &order}},
+				log.DevWriter{Device: log.DevError, Writer: orderWriter{"error", &mu, &order}},
+				log.DevWriter{Device: log.DevTrace, Writer: orderWriter{"trace", &mu, &order}},
+				log.DevWriter{Device: log.DevData, Writer: orderWriter{"data", &mu, &order}})
+			log.SetBulkLogPeriod(time.Hour)
+
+			log.DataKV("TEST", "foo", "k", "v")
+			log.Tracef("TEST", "foo", "hello")
+			log.Err(errors.New("A"), "TEST", "foo")
+			log.Start("TEST", "foo")
+			log.Shutdown()
+
+			if got := strings.Join(order, ","); got != "start,error,trace,data" {
+				t.Fatalf("\tShould write the devices in device order. %s %q", failed, got)
+			}
+		}
+		t.Log("\tShould write the devices in device order.", succeed)
+	}
+}
+
+func TestDataKVTimes(t *testing.T) {
+	t.Log("Given the need to write durations and times the same way every time.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetDurationUnit(time.Millisecond)
+
+		at := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*3600))
+		log.DataKV("TEST", "foo", "elapsed", 1500*time.Millisecond)
+		log.DataKV("TEST", "foo", "at", at)
+		log.SetDurationUnit(time.Second)
+		log.DataKVQuoted("TEST", "foo", "elapsed", 1500*time.Millisecond)
+		log.SetDurationUnit(3 * time.Second)
+		log.DataKV("TEST", "foo", "elapsed", 250*time.Millisecond)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: elapsed: 1500ms\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: at: 2020/01/02 08:04:05.000000000\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: elapsed: 1.5s\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: elapsed: 0.25s\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write durations in the unit set and times in the line layout.", succeed)
+		} else {
+			t.Errorf("\tShould write durations in the unit set and times in the line layout. %s %q", failed, got)
+		}
+	}
+}
+
+// hexID is written in hex by a registered value formatter.
+type hexID uint64
+
+func TestRegisterValueFormatter(t *testing.T) {
+	t.Log("Given the need to write the values of a type in a special way.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		typ := reflect.TypeOf(hexID(0))
+		log.RegisterValueFormatter(typ, func(v interface{}) string {
+			return fmt.Sprintf("%#x", uint64(v.(hexID)))
+		})
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "id", Value: hexID(255)})
+
+		log.DataKV("TEST", "foo", "id", hexID(255))
+		log.Splunk(log.SplunkPair{Key: "id", Value: hexID(255)})
+		ll.Tracef("TEST", "foo", "fields")
+		log.RegisterValueFormatter(typ, nil)
+		log.DataKV("TEST", "foo", "id", hexID(255))
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: id: 0xff\n" +
+			"2009/11/10 15:00:00.000000000: id=0xff\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: id[0xff]: fields\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: id: 255\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the values with the registered formatter.", succeed)
+		} else {
+			t.Errorf("\tShould write the values with the registered formatter. %s %q", failed, got)
+		}
+	}
+}
+
+func TestIndentNesting(t *testing.T) {
+	t.Log("Given the need to read nested Start and Complete blocks as a call tree.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetIndentNesting(true)
+
+		log.Start("TEST", "Outer")
+		log.Start("TEST", "Inner")
+		log.Tracef("TEST", "Inner", "working")
+		log.Tracef("OTHER", "foo", "flat")
+		log.CompleteErr(errors.New("A"), "TEST", "Inner")
+		log.Complete("TEST", "Outer")
+		log.Complete("TEST", "Extra")
+		log.Tracef("TEST", "Outer", "done")
+		log.SetIndentNesting(false)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: Outer: Started:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST:   Inner: Started:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST:     Inner: Trace: working\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: OTHER: foo: Trace: flat\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST:   Inner: Completed ERROR: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: Outer: Completed:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: Extra: Completed:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: Outer: Trace: done\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould indent the lines of each context by its depth.", succeed)
+		} else {
+			t.Errorf("\tShould indent the lines of each context by its depth. %s %q", failed, got)
+		}
+	}
+
+	t.Log("Given the need to only nest the Start lines that are written.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetIndentNesting(true)
+		log.SetFilter(func(e log.Entry) bool {
+			return e.Function != "Health"
+		})
+		defer log.SetFilter(nil)
+
+		log.Start("TEST", "Health")
+		log.Tracef("TEST", "foo", "flat")
+		log.SetIndentNesting(false)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: flat\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould not indent for a Start the filter drops.", succeed)
+		} else {
+			t.Errorf("\tShould not indent for a Start the filter drops. %s %q", failed, got)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	t.Log("Given the need to see the lines that are about to be written.")
+	{
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		defer log.Dev.SetImmediate(log.DevError, true)
+		log.Dev.SetImmediate(log.DevError, false)
+
+		var errBuf, traceBuf log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevError, Writer: &errBuf},
+			log.DevWriter{Device: log.DevTrace, Writer: &traceBuf},
+			log.DevWriter{Device: log.DevData, Writer: &traceBuf})
+		log.SetBulkLogPeriod(time.Hour)
+
+		log.Tracef("TEST", "foo", "hello")
+		log.DataString("TEST", "foo", "a")
+		log.Err(errors.New("A"), "TEST", "foo")
+
+		var got []string
+		for _, ln := range log.Pending() {
+			got = append(got, string(ln))
+		}
+
+		exp := []string{
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A",
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello",
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:",
+			"\ta",
+		}
+		if strings.Join(got, "\n") == strings.Join(exp, "\n") {
+			t.Log("\tShould return the buffered lines in device order.", succeed)
+		} else {
+			t.Errorf("\tShould return the buffered lines in device order. %s %q", failed, got)
+		}
+
+		if errBuf.String() == "" && traceBuf.String() == "" {
+			t.Log("\tShould not write the lines.", succeed)
+		} else {
+			t.Errorf("\tShould not write the lines. %s %q %q", failed, errBuf.String(), traceBuf.String())
+		}
+
+		log.Shutdown()
+
+		if strings.Count(traceBuf.String(), "\n") == 3 && log.Pending() == nil {
+			t.Log("\tShould still write the lines on Shutdown.", succeed)
+		} else {
+			t.Errorf("\tShould still write the lines on Shutdown. %s %q", failed, traceBuf.String())
+		}
+	}
+}
+
+func TestImmediate(t *testing.T) {
+	t.Log("Given the need to write error lines without waiting for the flush period.")
+	{
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		defer log.Dev.SetImmediate(log.DevWarning, false)
+
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetBulkLogPeriod(time.Hour)
+
+		log.Tracef("TEST", "foo", "before")
+		log.Err(errors.New("A"), "TEST", "foo")
+		log.Tracef("TEST", "foo", "after")
+		time.Sleep(100 * time.Millisecond)
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: before\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould flush the error with the lines before it.", succeed)
+		} else {
+			t.Errorf("\tShould flush the error with the lines before it. %s %q", failed, got)
+		}
+
+		buf.Reset()
+		log.Dev.SetImmediate(log.DevWarning, true)
+		log.Warnf("TEST", "foo", "now")
+		time.Sleep(100 * time.Millisecond)
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: after\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: now\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould flush the devices that are set.", succeed)
+		} else {
+			t.Errorf("\tShould flush the devices that are set. %s %q", failed, got)
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("\tShould ignore a device that doesn't exist. %s %v", failed, r)
+				}
+			}()
+			log.Dev.SetImmediate(-1, true)
+			t.Log("\tShould ignore a device that doesn't exist.", succeed)
+		}()
+
+		log.Shutdown()
+	}
+}
+
+func TestIncludeHostname(t *testing.T) {
+	t.Log("Given the need to know which host wrote a line.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetIncludeHostname(true)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "sub", Value: "db"})
+
+		log.Start("TEST", "foo")
+		log.Tracef("TEST", "foo", "hello")
+		ll.Tracef("TEST", "foo", "fields")
+		log.SetIncludeHostname(false)
+		log.Tracef("TEST", "foo", "off")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: host[testhost]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: host[testhost]: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: host[testhost]: sub[db]: fields\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: off\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the host field in front of the var segment.", succeed)
+		} else {
+			t.Errorf("\tShould write the host field in front of the var segment. %s %q", failed, got)
+		}
+	}
+}
+
+func TestIncludePackage(t *testing.T) {
+	t.Log("Given the need to know which package wrote a line.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetIncludePackage(true)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "sub", Value: "db"})
+
+		log.Start("TEST", "foo")
+		log.Tracef("TEST", "foo", "hello")
+		log.Up1.Tracef("TEST", "foo", "up")
+		ll.TraceObject("TEST", "foo", 42)
+		ll.Up1.DataString("TEST", "foo", "data")
+		log.SetIncludePackage(false)
+		log.Tracef("TEST", "foo", "off")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: pkg[github.com/Comcast/go-log/log_test]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: pkg[github.com/Comcast/go-log/log_test]: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: pkg[testing]: up\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: pkg[github.com/Comcast/go-log/log_test]: sub[db]: value=42\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: pkg[testing]: sub[db]\n\tdata\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: off\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the package of the caller in the var segment.", succeed)
+		} else {
+			t.Errorf("\tShould write the package of the caller in the var segment. %s %q", failed, got)
+		}
+	}
+}
+
+func TestLoggerInfoWarnError(t *testing.T) {
+	t.Log("Given the need to log simple messages without a context.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelWarning })
+		ll.Info("hidden")
+		ll.Warn("low disk")
+		ll.Error(errors.New("failed"))
+		ll.Up1.Warn("up")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: log_test.TestLoggerInfoWarnError: Warning: low disk\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: log_test.TestLoggerInfoWarnError: ERROR: failed\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: testing.tRunner: Warning: up\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the lines with the calling function and no context.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines with the calling function and no context. %s %q", failed, got)
+		}
+
+		buf.Reset()
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll = log.NewLogger("LOG", func() int { return log.LevelOutput })
+		ll.Info("shown")
+		ll.Error(nil)
+		log.Shutdown()
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: log_test.TestLoggerInfoWarnError: Trace: shown\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write Info at the output level and skip a nil error.", succeed)
+		} else {
+			t.Errorf("\tShould write Info at the output level and skip a nil error. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	t.Log("Given the need to undo configuration changes in a test.")
+	{
+		var buf, other log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.Shutdown()
+
+		cfg := log.SaveConfig()
+		period := log.GetBulkLogPeriod()
+
+		log.Dev.All(&other)
+		log.SetCompactTags(true)
+		log.SetVarStyle(log.VarEquals)
+		log.SetMaxDataLines(1)
+		log.SetBulkLogPeriod(time.Hour)
+		log.SetFilter(func(log.Entry) bool { return false })
+		log.SetTemplate("{{.Tag}} {{.Message}}")
+		log.SetArgTransform(func(int, interface{}) interface{} { return "x" })
+		log.SetTestFixture(time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC), "main.go#1", 42)
+		log.RegisterValueFormatter(reflect.TypeOf(hexID(0)), func(interface{}) string { return "x" })
+		log.SetSynchronous(true)
+
+		log.RestoreConfig(cfg)
+
+		if got := log.GetBulkLogPeriod(); got == period {
+			t.Log("\tShould restore the bulk log period.", succeed)
+		} else {
+			t.Errorf("\tShould restore the bulk log period. %s %v", failed, got)
+		}
+
+		log.Tracef("TEST", "foo", "hello %s", "world")
+		log.DataString("TEST", "foo", "a\nb")
+		log.DataKV("TEST", "foo", "id", hexID(255))
+		if got := buf.String(); got == "" {
+			t.Log("\tShould go back to queuing the lines.", succeed)
+		} else {
+			t.Errorf("\tShould go back to queuing the lines. %s %q", failed, got)
+		}
+		log.Flush()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello world\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta\n\tb\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: id: 255\n"
+		if got := buf.String(); got == exp && other.String() == "" {
+			t.Log("\tShould write with the saved writers and settings.", succeed)
+		} else {
+			t.Errorf("\tShould write with the saved writers and settings. %s %q %q", failed, got, other.String())
+		}
+
+		buf.Reset()
+		log.RestoreConfig(nil)
+		log.RestoreConfig(&log.Config{})
+		log.Tracef("TEST", "foo", "kept")
+		log.Flush()
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: kept\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould ignore a configuration not taken by SaveConfig.", succeed)
+		} else {
+			t.Errorf("\tShould ignore a configuration not taken by SaveConfig. %s %q", failed, got)
+		}
+	}
+}
+
+func TestTraceEnter(t *testing.T) {
+	t.Log("Given the need to write matching Started and Completed lines.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "id", Value: 7})
+
+		func() {
+			defer log.TraceEnter("TEST", "foo")()
+		}()
+		ll.TraceEnter("TEST", "bar")(42, errors.New("A"))
+		log.NewLogger("LOG", func() int { return log.LevelOutput }).TraceEnter("TEST", "off")()
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Started: id[7]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: id[7]: [42, A]\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the Completed line with the results.", succeed)
+		} else {
+			t.Errorf("\tShould write the Completed line with the results. %s %q", failed, got)
+		}
+	}
+
+	t.Log("Given the need to know where the function was entered.")
+	{
+		var buf log.SafeBuffer
+		log.Init("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		func() {
+			defer log.TraceEnter("TEST", "foo")()
+			log.Tracef("TEST", "foo", "between")
+		}()
+		log.Shutdown()
+
+		re := regexp.MustCompile(`#(\d+): TEST: foo: (\w+):`)
+		nums := make(map[string]string)
+		for _, m := range re.FindAllStringSubmatch(buf.String(), -1) {
+			nums[m[2]] = m[1]
+		}
+		if len(nums) == 3 && nums["Started"] == nums["Completed"] && nums["Trace"] != nums["Started"] {
+			t.Log("\tShould write the line of the call to TraceEnter on both lines.", succeed)
+		} else {
+			t.Errorf("\tShould write the line of the call to TraceEnter on both lines. %s %v", failed, nums)
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	t.Log("Given the need to control the time outside of test mode.")
+	{
+		var buf log.SafeBuffer
+		log.Init("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		at := time.Date(2020, time.February, 3, 4, 5, 6, 7, time.UTC)
+		log.SetClock(func() time.Time { return at })
+		defer log.SetClock(nil)
+
+		log.Tracef("TEST", "foo", "hello")
+		at = at.Add(time.Second)
+		log.Splunk(log.SplunkPair{Key: "Key1", Value: "Value1"})
+		log.Shutdown()
+
+		lines := strings.Split(buf.String(), "\n")
+		if len(lines) == 3 &&
+			strings.HasPrefix(lines[0], "2020/02/03 04:05:06.000000007: LOG[") &&
+			lines[1] == "2020/02/03 04:05:07.000000007: Key1=Value1" {
+			t.Log("\tShould use the clock for the timestamps.", succeed)
+		} else {
+			t.Errorf("\tShould use the clock for the timestamps. %s %q", failed, lines)
+		}
+	}
+
+	t.Log("Given the need to keep a clock that is set around test mode.")
+	{
+		at := time.Date(2020, time.February, 3, 4, 5, 6, 7, time.UTC)
+		log.SetClock(func() time.Time { return at })
+		defer log.SetClock(nil)
+
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.Tracef("TEST", "foo", "hello")
+		log.Shutdown()
+
+		exp := "2020/02/03 04:05:06.000000007: LOG[69910]: file.go#512: TEST: foo: Trace: hello\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould keep the clock set before InitTest.", succeed)
+		} else {
+			t.Errorf("\tShould keep the clock set before InitTest. %s %q", failed, got)
+		}
+
+		buf.Reset()
+		log.SetClock(nil)
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetClock(func() time.Time { return at })
+		log.Shutdown()
+
+		log.Init("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.Tracef("TEST", "foo", "hello")
+		log.Shutdown()
+
+		if got := buf.String(); strings.HasPrefix(got, "2020/02/03 04:05:06.000000007: LOG[") {
+			t.Log("\tShould keep the clock set after InitTest.", succeed)
+		} else {
+			t.Errorf("\tShould keep the clock set after InitTest. %s %q", failed, got)
+		}
+	}
+}
+
+func TestBulkLogPeriodChange(t *testing.T) {
+	t.Log("Given the need to shorten the bulk log period while lines are buffered.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		log.SetBulkLogPeriod(time.Hour)
+
+		log.Tracef("TEST", "foo", "hello")
+		time.Sleep(50 * time.Millisecond)
+		log.SetBulkLogPeriod(10 * time.Millisecond)
+		time.Sleep(200 * time.Millisecond)
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould flush by the new period.", succeed)
+		} else {
+			t.Errorf("\tShould flush by the new period. %s %q", failed, got)
+		}
+
+		log.Shutdown()
+	}
+}
+
+func TestNewLoggerFromEnv(t *testing.T) {
+	t.Log("Given the need to set the level of a logger from the environment.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		const env = "GO_LOG_TEST_LEVEL"
+		defer os.Unsetenv(env)
+		os.Unsetenv(env)
+		ll := log.NewLoggerFromEnv("LOG", env)
+
+		ll.Tracef("TEST", "foo", "unset")
+		ll.Warnf("TEST", "foo", "unset")
+		os.Setenv(env, "TRACE")
+		ll.Tracef("TEST", "foo", "trace")
+		os.Setenv(env, "1")
+		ll.Warnf("TEST", "foo", "one")
+		ll.Errf(errors.New("A"), "TEST", "foo", "one")
+		os.Setenv(env, "warn")
+		ll.Warnf("TEST", "foo", "warn")
+		os.Setenv(env, "bogus")
+		ll.Tracef("TEST", "foo", "bogus")
+		ll.DataKV("TEST", "foo", "bogus", 1)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: unset\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: trace\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: one: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: warn\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: bogus: 1\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould read the level from the variable on every call.", succeed)
+		} else {
+			t.Errorf("\tShould read the level from the variable on every call. %s %q", failed, got)
+		}
+	}
+}
+
+func TestWrapErr(t *testing.T) {
+	t.Log("Given the need to log an error and return it in one line.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		errA := errors.New("A")
+		off := log.NewLogger("LOG", func() int { return log.LevelOff })
+
+		if err := log.WrapErr(errA, "TEST", "foo"); err == errA {
+			t.Log("\tShould return the error unchanged.", succeed)
+		} else {
+			t.Errorf("\tShould return the error unchanged. %s %v", failed, err)
+		}
+
+		if err := log.WrapErrf(errA, "TEST", "foo", "id[%d]", 7); errors.Is(err, errA) && err.Error() == "id[7]: A" {
+			t.Log("\tShould return the error annotated with the message.", succeed)
+		} else {
+			t.Errorf("\tShould return the error annotated with the message. %s %v", failed, err)
+		}
+
+		if err := off.WrapErrf(errA, "TEST", "foo", "id[%d]", 7); errors.Is(err, errA) && err.Error() == "id[7]: A" {
+			t.Log("\tShould return the error when the logger is off.", succeed)
+		} else {
+			t.Errorf("\tShould return the error when the logger is off. %s %v", failed, err)
+		}
+
+		if log.WrapErr(nil, "TEST", "foo") == nil && log.WrapErrf(nil, "TEST", "foo", "x") == nil {
+			t.Log("\tShould return nil for a nil error.", succeed)
+		} else {
+			t.Errorf("\tShould return nil for a nil error. %s", failed)
+		}
+
+		log.Shutdown()
+
+		if got := strings.Count(buf.String(), ": ERROR: "); got == 2 {
+			t.Log("\tShould log each error that is not nil once.", succeed)
+		} else {
+			t.Errorf("\tShould log each error that is not nil once. %s %q", failed, buf.String())
+		}
+	}
+}
+
+func TestNewLoggerWithService(t *testing.T) {
+	t.Log("Given the need to tag every line of a logger with its service.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll := log.NewLoggerWithService("LOG", "billing", func() int { return log.LevelTrace })
+		ll.Tracef("TEST", "foo", "hello")
+		ll.Start("TEST", "foo")
+		ll.WithFields(log.SplunkPair{Key: "id", Value: 7}).Warnf("TEST", "foo", "derived")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: service[billing]: hello\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: service[billing]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: service[billing]: id[7]: derived\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the service field on every line.", succeed)
+		} else {
+			t.Errorf("\tShould write the service field on every line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestWaitForWrites(t *testing.T) {
+	t.Log("Given the need to wait for lines to be written in tests.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.Shutdown()
+
+		log.Tracef("TEST", "foo", "one")
+		log.Complete("TEST", "foo")
+		log.CompleteErr(errors.New("failed"), "TEST", "foo")
+		if err := log.WaitForWrites(3, time.Second); err == nil {
+			t.Log("\tShould wait for the lines to be written.", succeed)
+		} else {
+			t.Errorf("\tShould wait for the lines to be written. %s %v", failed, err)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: one\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed:\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed ERROR: failed\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould have the lines in the buffer when it returns.", succeed)
+		} else {
+			t.Errorf("\tShould have the lines in the buffer when it returns. %s %q", failed, got)
+		}
+
+		if err := log.WaitForWrites(1, 10*time.Millisecond); err != nil {
+			t.Log("\tShould time out when no lines are written.", succeed)
+		} else {
+			t.Errorf("\tShould time out when no lines are written. %s", failed)
+		}
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	t.Log("Given the need to call Shutdown from several goroutines.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		log.Tracef("TEST", "foo", "hello")
+
+		var wg sync.WaitGroup
+		panics := make(chan interface{}, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if r := recover(); r != nil {
+						panics <- r
+					}
+				}()
+				log.Shutdown()
+			}()
+		}
+		wg.Wait()
+		log.Shutdown()
+		close(panics)
+
+		if r, ok := <-panics; !ok {
+			t.Log("\tShould not panic when called more than once.", succeed)
+		} else {
+			t.Errorf("\tShould not panic when called more than once. %s %v", failed, r)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the lines once.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines once. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSection(t *testing.T) {
+	t.Log("Given the need to mark the phases of a run with separator lines.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetSectionWidth(20)
+		defer log.SetSectionWidth(0)
+
+		log.Section("load")
+		log.Section("a title longer than the width")
+		log.Section("")
+		log.NewLogger("LOG", func() int { return log.LevelTrace }).Section("trace")
+		log.NewLogger("LOG", func() int { return log.LevelWarning }).Section("warning")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ==== load ==========\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ==== a title longer than the width ====\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ====================\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ==== trace =========\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the separators padded to the width.", succeed)
+		} else {
+			t.Errorf("\tShould write the separators padded to the width. %s %q", failed, got)
+		}
+	}
+}
+
+// upperEncoder is used to test SetEncoder with an encoder of its own.
+type upperEncoder struct{}
+
+func (upperEncoder) Encode(f log.LineFields) []byte {
+	return []byte(strings.ToUpper(f.Tag+" "+f.Message) + "\n")
+}
+
+func TestSetEncoder(t *testing.T) {
+	t.Log("Given the need to write the trace lines in a format of our own.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetEncoder(nil)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "id", Value: 7})
+
+		lines := func() {
+			log.Tracef("TEST", "foo", "hello")
+			ll.Start("TEST", "foo")
+			ll.Errf(errors.New("A"), "TEST", "foo", "failed")
+			log.DataString(log.NoContext, "foo", "a\nb")
+		}
+
+		lines()
+		log.Flush()
+		builtIn := buf.String()
+		buf.Reset()
+
+		log.SetEncoder(log.TextEncoder{})
+		lines()
+		log.Flush()
+		if got := buf.String(); got == builtIn {
+			t.Log("\tShould write the built in layout with TextEncoder.", succeed)
+		} else {
+			t.Errorf("\tShould write the built in layout with TextEncoder. %s %q %q", failed, got, builtIn)
+		}
+		buf.Reset()
+
+		log.SetEncoder(log.JSONEncoder{})
+		lines()
+		log.Flush()
+		exp := `{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Trace","message":"hello"}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Started","message":"","fields":{"id":7}}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"ERROR","message":"failed: A","fields":{"id":7}}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"func":"foo","tag":"DATA","message":"","data":"a\nb"}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write each line as a JSON object with JSONEncoder.", succeed)
+		} else {
+			t.Errorf("\tShould write each line as a JSON object with JSONEncoder. %s %q", failed, got)
+		}
+		buf.Reset()
+
+		log.SetEncoder(upperEncoder{})
+		ll.Tracef("TEST", "foo", "hello")
+		log.SetEncoder(nil)
+		log.Tracef("TEST", "foo", "back")
+		log.Shutdown()
+
+		exp = "TRACE HELLO\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: back\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould use an encoder of our own until it is removed.", succeed)
+		} else {
+			t.Errorf("\tShould use an encoder of our own until it is removed. %s %q", failed, got)
+		}
+	}
+}
+
+func TestJSONEncoderDataBlock(t *testing.T) {
+	t.Log("Given the need to write a DATA line with a block of many lines as JSON.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetEncoder(nil)
+
+		log.SetEncoder(log.JSONEncoder{})
+		log.DataBlock("TEST", "foo", "first\nsecond")
+		log.Tracef("TEST", "foo", "after")
+		log.Shutdown()
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		valid := len(lines) == 2
+		for _, ln := range lines {
+			valid = valid && json.Valid([]byte(ln))
+		}
+		if valid {
+			t.Log("\tShould write one valid JSON object per line.", succeed)
+		} else {
+			t.Errorf("\tShould write one valid JSON object per line. %s %q", failed, buf.String())
+		}
+
+		var data struct {
+			Tag  string
+			Data string
+		}
+		if err := json.Unmarshal([]byte(lines[0]), &data); err == nil && data.Tag == "DATA" && data.Data == "first\nsecond" {
+			t.Log("\tShould keep the lines of the block in the data string.", succeed)
+		} else {
+			t.Errorf("\tShould keep the lines of the block in the data string. %s %v %+v", failed, err, data)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	t.Log("Given the need to tell the versions of the JSON layout apart.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetEncoder(nil)
+
+		log.SetEncoder(log.JSONEncoder{})
+		cfg := log.SaveConfig()
+		log.SetSchemaVersion(2)
+		log.Tracef("TEST", "foo", "new")
+		log.RestoreConfig(cfg)
+		log.Tracef("TEST", "foo", "old")
+		log.Shutdown()
+
+		exp := `{"v":2,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Trace","message":"new"}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"LOG","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Trace","message":"old"}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the version set and go back to 1 with RestoreConfig.", succeed)
+		} else {
+			t.Errorf("\tShould write the version set and go back to 1 with RestoreConfig. %s %q", failed, got)
+		}
+	}
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+	t.Log("Given the need to write the trace lines as logfmt with the message last.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetEncoder(nil)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "zone", Value: "a b"}, log.SplunkPair{Key: "id", Value: 7})
+
+		log.SetEncoder(log.LogfmtEncoder{})
+		ll.Errf(errors.New("A"), "TEST", "foo", "failed")
+		log.Flush()
+
+		exp := `time="2009/11/10 15:00:00.000000000" app=LOG pid=69910 file=file.go line=512 context=TEST func=foo tag=ERROR msg="failed: A" zone="a b" id=7` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the message after the tag by default.", succeed)
+		} else {
+			t.Errorf("\tShould write the message after the tag by default. %s %q", failed, got)
+		}
+		buf.Reset()
+
+		log.SetEncoder(log.LogfmtEncoder{Mode: log.LogfmtMessageLast})
+		for i := 0; i < 20; i++ {
+			ll.Errf(errors.New("A"), "TEST", "foo", "failed")
+		}
+		log.DataString(log.NoContext, "foo", "a\nb")
+		log.Shutdown()
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		exp = `time="2009/11/10 15:00:00.000000000" app=LOG pid=69910 file=file.go line=512 context=TEST func=foo tag=ERROR zone="a b" id=7 msg="failed: A"`
+		stable := len(lines) == 21
+		for _, ln := range lines[:len(lines)-1] {
+			stable = stable && ln == exp
+		}
+		if stable {
+			t.Log("\tShould write the metadata, then the fields, then the message, in the same order every time.", succeed)
+		} else {
+			t.Errorf("\tShould write the metadata, then the fields, then the message, in the same order every time. %s %q", failed, lines)
+		}
+
+		exp = `time="2009/11/10 15:00:00.000000000" app=LOG pid=69910 file=file.go line=512 func=foo tag=DATA data="a\nb" msg=""`
+		if got := lines[len(lines)-1]; got == exp {
+			t.Log("\tShould write the message after the data of a DATA line.", succeed)
+		} else {
+			t.Errorf("\tShould write the message after the data of a DATA line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestOTLPEncoder(t *testing.T) {
+	t.Log("Given the need to send the trace lines to an OpenTelemetry collector.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetEncoder(nil)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "id", Value: 7}, log.SplunkPair{Key: "ok", Value: true})
+
+		log.SetEncoder(log.OTLPEncoder{})
+		log.Tracef("TEST", "foo", "hello")
+		ll.Errf(errors.New("A"), "TEST", "foo", "failed")
+		log.DataString(log.NoContext, "foo", "a\nb")
+		log.Shutdown()
+
+		exp := `{"timeUnixNano":"1257865200000000000","severityNumber":1,"severityText":"Trace","body":{"stringValue":"hello"},"attributes":[{"key":"context","value":{"stringValue":"TEST"}},{"key":"func","value":{"stringValue":"foo"}},{"key":"file","value":{"stringValue":"file.go"}},{"key":"line","value":{"intValue":"512"}}]}` + "\n" +
+			`{"timeUnixNano":"1257865200000000000","severityNumber":17,"severityText":"ERROR","body":{"stringValue":"failed: A"},"attributes":[{"key":"context","value":{"stringValue":"TEST"}},{"key":"func","value":{"stringValue":"foo"}},{"key":"file","value":{"stringValue":"file.go"}},{"key":"line","value":{"intValue":"512"}},{"key":"id","value":{"intValue":"7"}},{"key":"ok","value":{"boolValue":true}}]}` + "\n" +
+			`{"timeUnixNano":"1257865200000000000","severityNumber":5,"severityText":"DATA","body":{"stringValue":""},"attributes":[{"key":"func","value":{"stringValue":"foo"}},{"key":"file","value":{"stringValue":"file.go"}},{"key":"line","value":{"intValue":"512"}},{"key":"data","value":{"stringValue":"a\nb"}}]}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write each line as an OTLP log record.", succeed)
+		} else {
+			t.Errorf("\tShould write each line as an OTLP log record. %s %q", failed, got)
+		}
+
+		valid := true
+		for _, ln := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			valid = valid && json.Valid([]byte(ln))
+		}
+		if valid {
+			t.Log("\tShould write one valid JSON object per line.", succeed)
+		} else {
+			t.Errorf("\tShould write one valid JSON object per line. %s %q", failed, buf.String())
+		}
+	}
+}
+
+func TestLoggerFuncs(t *testing.T) {
+	t.Log("Given the need to call all different logging calls.")
+	{
+		const context = "TEST"
+		cases := []struct {
+			expected string
+			f        func(*log.Logger)
+		}{
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started:\n", func(ll *log.Logger) {
+				ll.Start(context, "foo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: walrus[500]\n", func(ll *log.Logger) {
+				ll.Startf(context, "foo", "walrus[%d]", 500)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: id[7]: name[bob]\n", func(ll *log.Logger) {
+				ll.StartArgs(context, "foo", log.SplunkPair{Key: "id", Value: 7}, log.SplunkPair{Key: "name", Value: "bob"})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed:\n", func(ll *log.Logger) {
+				ll.Complete(context, "bar")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: ok[true]\n", func(ll *log.Logger) {
+				ll.CompleteReturns(context, "bar", log.SplunkPair{Key: "ok", Value: true})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: horse[3]\n", func(ll *log.Logger) {
+				ll.Completef(context, "bar", "horse[%d]", 3)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: baz: Completed ERROR: A\n", func(ll *log.Logger) {
+				ll.CompleteErr(errors.New("A"), context, "baz")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: baz: Completed ERROR: puppies[777]: B\n", func(ll *log.Logger) {
+				ll.CompleteErrf(errors.New("B"), context, "baz", "puppies[%d]", 777)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: ERROR: C\n", func(ll *log.Logger) {
+				ll.Err(errors.New("C"), context, "boo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: D\n", func(ll *log.Logger) {
+				ll.Errf(errors.New("D"), context, "bee", "ip[%s]", "127.0.0.1")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: ERROR: W\n", func(ll *log.Logger) {
+				ll.WrapErr(errors.New("W"), context, "boo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: W\n", func(ll *log.Logger) {
+				ll.WrapErrf(errors.New("W"), context, "bee", "ip[%s]", "127.0.0.1")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: faa: Trace: len[13]\n", func(ll *log.Logger) {
+				ll.Tracef(context, "faa", "len[%d]", 13)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: fii: Warning: usage[99.900000]\n", func(ll *log.Logger) {
+				ll.Warnf(context, "fii", "usage[%f]", 99.9)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: beer: Query: howmany[0]\n", func(ll *log.Logger) {
+				ll.Queryf(context, "beer", "howmany[%d]", 0)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: oom: DATA: 2b: !2b\n", func(ll *log.Logger) {
+				ll.DataKV(context, "oom", "2b", "!2b")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\tasdf I'm running out of ideas.\n", func(ll *log.Logger) {
+				ll.DataBlock(context, "moo", "asdf I'm running out of ideas.")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\t5\n", func(ll *log.Logger) {
+				ll.DataBlock(context, "moo", 5)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA:\n\tjson: unsupported value: NaN\n", func(ll *log.Logger) {
+				ll.DataBlock(context, "moo", math.NaN())
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: DATA:\n\tnot\n\tthat\n\tthey\n\twere\n\tvery\n\tgood to start with.\n", func(ll *log.Logger) {
+				ll.DataString(context, "boo", "not\nthat\nthey\nwere\nvery\ngood to start with.")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n\t42\n", func(ll *log.Logger) {
+				ll.DataTrace(context, "aah", SomeFormatter{})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA:\n", func(ll *log.Logger) {
+				ll.DataTrace(context, "aah", EmptyFormatter{})
+			}},
+		}
+		for _, tt := range cases {
+
+			resetLog()
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+			ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+			tt.f(ll)
+			log.Shutdown()
+
+			got := logdest.String()
+			if got != tt.expected {
+				t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+				continue
+			}
+			t.Log("\t\tLog should match expected.", succeed)
+		}
+	}
+}
+
+// TestLoggerWithFields tests that fields bound to a logger are written
+// into the var segment without changing the parent logger.
+func TestLoggerWithFields(t *testing.T) {
+	t.Log("Given the need to bind fields to a logger.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		parent := log.NewLogger("LOG", func() int { return log.LevelTrace })
+		req := parent.WithFields(log.SplunkPair{Key: "req", Value: 7})
+		user := req.WithFields(log.SplunkPair{Key: "user", Value: "bob"})
+
+		user.Start("TEST", "foo")
+		user.Tracef("TEST", "foo", "len[%d]", 13)
+		user.Err(errors.New("A"), "TEST", "foo")
+		user.Errf(errors.New("B"), "TEST", "foo", "ip[%s]", "127.0.0.1")
+		user.DataKV("TEST", "foo", "2b", "!2b")
+		user.Up1.DataString("TEST", "foo", "a\nb")
+		req.Complete("TEST", "foo")
+		parent.Complete("TEST", "foo")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: req[7]: user[bob]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: req[7]: user[bob]: len[13]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: req[7]: user[bob]: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: req[7]: user[bob]: ip[127.0.0.1]: B\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: req[7]: user[bob]: 2b: !2b\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: req[7]: user[bob]\n\ta\n\tb\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed: req[7]\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Completed:\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould log the accumulated fields for each logger.", succeed)
+		} else {
+			t.Errorf("\tShould log the accumulated fields for each logger. %s %q", failed, got)
+		}
+	}
+}
+
+func TestLoggerBoost(t *testing.T) {
+	t.Log("Given the need to raise the level of a logger for a scope.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelError })
+
+		ll.Tracef("TEST", "foo", "before")
+		restore := ll.Boost()
+		ll.Tracef("TEST", "foo", "during")
+		restore()
+		restore()
+		ll.Tracef("TEST", "foo", "after")
+		ll.Err(errors.New("A"), "TEST", "foo")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: during\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: A\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould only trace while the logger is boosted.", succeed)
+		} else {
+			t.Errorf("\tShould only trace while the logger is boosted. %s %q", failed, got)
+		}
+	}
+}
+
+func TestLoggerWithLevel(t *testing.T) {
+	t.Log("Given the need to clone a logger with a different level.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		base := log.NewLogger("LOG", func() int { return log.LevelError }).
+			WithFields(log.SplunkPair{Key: "sub", Value: "db"})
+		verbose := base.WithLevel(func() int { return log.LevelTrace })
+
+		base.Tracef("TEST", "foo", "base")
+		verbose.Tracef("TEST", "foo", "verbose")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: sub[db]: verbose\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould only trace from the clone and keep its fields.", succeed)
+		} else {
+			t.Errorf("\tShould only trace from the clone and keep its fields. %s %q", failed, got)
+		}
+	}
+}
+
+func TestLoggerWithError(t *testing.T) {
+	t.Log("Given the need to log several lines about the same failure.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		base := log.NewLogger("LOG", func() int { return log.LevelTrace }).
+			WithFields(log.SplunkPair{Key: "sub", Value: "db"})
+		failing := base.WithError(errors.New("timeout"))
+
+		failing.Errf(errors.New("A"), "TEST", "foo", "query failed")
+		failing.Warnf("TEST", "foo", "retrying")
+		failing.Tracef("TEST", "foo", "no error")
+		base.Warnf("TEST", "foo", "recovered")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: sub[db]: err[timeout]: query failed: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: sub[db]: err[timeout]: retrying\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: sub[db]: no error\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: sub[db]: recovered\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould add the error to Errf and Warnf lines of the child only.", succeed)
+		} else {
+			t.Errorf("\tShould add the error to Errf and Warnf lines of the child only. %s %q", failed, got)
+		}
+	}
+}
+
+func TestIncludeLevel(t *testing.T) {
+	t.Log("Given the need to know the level that let a line through.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetIncludeLevel(true)
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelWarning })
+		ll.Warnf("TEST", "foo", "warn")
+		ll.WithFields(log.SplunkPair{Key: "sub", Value: "db"}).Warnf("TEST", "foo", "fields")
+		restore := ll.Boost()
+		ll.Tracef("TEST", "foo", "boosted")
+		restore()
+		log.Tracef("TEST", "foo", "package")
+		log.SetIncludeLevel(false)
+		ll.Warnf("TEST", "foo", "off")
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: level[Warning]: warn\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: level[Warning]: sub[db]: fields\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: level[Trace]: boosted\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: package\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: off\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the logger's level on its lines.", succeed)
+		} else {
+			t.Errorf("\tShould write the logger's level on its lines. %s %q", failed, got)
+		}
+	}
+}
+
+func TestVarStyle(t *testing.T) {
+	t.Log("Given the need to write the var segment for different parsers.")
+	{
+		cases := []struct {
+			style    log.VarStyle
+			expected string
+		}{
+			{log.VarBrackets, "Warning: level[Warning]: sub[db]: id[7]: err[no route]: retrying\n"},
+			{log.VarEquals, "Warning: level=Warning sub=db id=7 err=\"no route\": retrying\n"},
+			{log.VarJSON, "Warning: {\"level\":\"Warning\",\"sub\":\"db\",\"id\":7,\"err\":\"no route\"}: retrying\n"},
+		}
+
+		for _, tc := range cases {
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetIncludeLevel(true)
+			log.SetVarStyle(tc.style)
+
+			log.NewLogger("LOG", func() int { return log.LevelWarning }).
+				WithFields(log.SplunkPair{Key: "sub", Value: "db"}, log.SplunkPair{Key: "id", Value: 7}).
+				WithError(errors.New("no route")).
+				Warnf("TEST", "foo", "retrying")
+
+			log.SetVarStyle(log.VarBrackets)
+			log.SetIncludeLevel(false)
+			log.Shutdown()
+
+			exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: " + tc.expected
+			if got := buf.String(); got == exp {
+				t.Logf("\tShould write the fields in style %d. %s", tc.style, succeed)
+			} else {
+				t.Errorf("\tShould write the fields in style %d. %s %q", tc.style, failed, got)
+			}
+		}
+	}
+}
+
+func TestPushLevel(t *testing.T) {
+	t.Log("Given the need to raise the level of every logger for a scope.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		ll := log.NewLogger("LOG", func() int { return log.LevelError })
+
+		ll.Warnf("TEST", "foo", "before")
+		outer := log.PushLevel(log.LevelWarning)
+		inner := log.PushLevel(log.LevelTrace)
+		ll.Tracef("TEST", "foo", "inner")
+		inner.Pop()
+		inner.Pop()
+		ll.Tracef("TEST", "foo", "outer")
+		ll.Warnf("TEST", "foo", "outer")
+		outer.Pop()
+		ll.Warnf("TEST", "foo", "after")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: inner\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: outer\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould use the highest level still pushed.", succeed)
+		} else {
+			t.Errorf("\tShould use the highest level still pushed. %s %q", failed, got)
+		}
+	}
+}
+
+func TestDataEnabled(t *testing.T) {
+	t.Log("Given the need to know if data lines are written.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.Shutdown()
+
+		trace := log.NewLogger("LOG", func() int { return log.LevelTrace })
+		errs := log.NewLogger("LOG", func() int { return log.LevelError })
+
+		if log.DevEnabled(log.DevData) && trace.DataEnabled() && !errs.DataEnabled() {
+			t.Log("\tShould be enabled only at LevelOutput or above.", succeed)
+		} else {
+			t.Error("\tShould be enabled only at LevelOutput or above.", failed)
+		}
+
+		log.Dev.Data(nil)
+		if !log.DevEnabled(log.DevData) && !trace.DataEnabled() && log.DevEnabled(log.DevTrace) {
+			t.Log("\tShould be disabled when the device is nil.", succeed)
+		} else {
+			t.Error("\tShould be disabled when the device is nil.", failed)
+		}
+	}
+}
+
+func TestLoggerErrPanic(t *testing.T) {
+	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("\t\tErrPanic should have panicked.", failed)
+		} else {
+			t.Log("\t\tErrPanic should have panicked.", succeed)
+		}
+
+		got := logdest.String()
+		if got != expected {
+			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+			return
+		}
+		t.Log("\t\tLog should match expected.", succeed)
+	}()
+
+	resetLog()
+	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+	ll.ErrPanic(errors.New("A"), "TEST", "TestErrPanic")
+}
+
+func TestLoggerErrPanicf(t *testing.T) {
+	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: we're doomed -bender-: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("\t\tErrPanicf should have panicked.", failed)
+		} else {
+			t.Log("\t\tErrPanicf should have panicked.", succeed)
+		}
+
+		got := logdest.String()
+		if got != expected {
+			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+			return
+		}
+		t.Log("\t\tLog should match expected.", succeed)
+	}()
+
+	resetLog()
+	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+	ll.ErrPanicf(errors.New("A"), "TEST", "TestErrPanic", "we're doomed -%s-", "bender")
+}
+
+func TestUpLoggerFuncs(t *testing.T) {
+	t.Log("Given the need to call all different logging calls.")
+	{
+		const context = "TEST"
+		cases := []struct {
+			expected string
+			f        func(*log.UplevelLogger)
+		}{
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started:\n", func(ll *log.UplevelLogger) {
+				ll.Start(context, "foo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: walrus[500]\n", func(ll *log.UplevelLogger) {
+				ll.Startf(context, "foo", "walrus[%d]", 500)
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Started: id[7]: name[bob]\n", func(ll *log.UplevelLogger) {
+				ll.StartArgs(context, "foo", log.SplunkPair{Key: "id", Value: 7}, log.SplunkPair{Key: "name", Value: "bob"})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed:\n", func(ll *log.UplevelLogger) {
+				ll.Complete(context, "bar")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: ok[true]\n", func(ll *log.UplevelLogger) {
+				ll.CompleteReturns(context, "bar", log.SplunkPair{Key: "ok", Value: true})
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bar: Completed: horse[3]\n", func(ll *log.UplevelLogger) {
+				ll.Completef(context, "bar", "horse[%d]", 3)
 			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: baz: Completed ERROR: A\n", func(ll *log.UplevelLogger) {
 				ll.CompleteErr(errors.New("A"), context, "baz")
@@ -653,6 +2512,12 @@ func TestUpLoggerFuncs(t *testing.T) {
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: D\n", func(ll *log.UplevelLogger) {
 				ll.Errf(errors.New("D"), context, "bee", "ip[%s]", "127.0.0.1")
 			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: ERROR: W\n", func(ll *log.UplevelLogger) {
+				ll.WrapErr(errors.New("W"), context, "boo")
+			}},
+			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: bee: ERROR: ip[127.0.0.1]: W\n", func(ll *log.UplevelLogger) {
+				ll.WrapErrf(errors.New("W"), context, "bee", "ip[%s]", "127.0.0.1")
+			}},
 			{"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: faa: Trace: len[13]\n", func(ll *log.UplevelLogger) {
 				ll.Tracef(context, "faa", "len[%d]", 13)
 			}},
@@ -684,83 +2549,1241 @@ func TestUpLoggerFuncs(t *testing.T) {
 				ll.DataTrace(context, "aah", EmptyFormatter{})
 			}},
 		}
-		for _, tt := range cases {
+		for _, tt := range cases {
+
+			resetLog()
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+			ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+			tt.f(&ll.Up1)
+			log.Shutdown()
+
+			got := logdest.String()
+			if got != tt.expected {
+				t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+				continue
+			}
+			t.Log("\t\tLog should match expected.", succeed)
+		}
+	}
+}
+
+func TestUpLoggerErrPanic(t *testing.T) {
+	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("\t\tErrPanic should have panicked.", failed)
+		} else {
+			t.Log("\t\tErrPanic should have panicked.", succeed)
+		}
+
+		got := logdest.String()
+		if got != expected {
+			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+			return
+		}
+		t.Log("\t\tLog should match expected.", succeed)
+	}()
+
+	resetLog()
+	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+	ll.Up1.ErrPanic(errors.New("A"), "TEST", "TestErrPanic")
+}
+
+func TestUpLoggerErrPanicf(t *testing.T) {
+	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: we're doomed -bender-: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("\t\tErrPanicf should have panicked.", failed)
+		} else {
+			t.Log("\t\tErrPanicf should have panicked.", succeed)
+		}
+
+		got := logdest.String()
+		if got != expected {
+			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
+			return
+		}
+		t.Log("\t\tLog should match expected.", succeed)
+	}()
+
+	resetLog()
+	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
+	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
+	ll.Up1.ErrPanicf(errors.New("A"), "TEST", "TestErrPanic", "we're doomed -%s-", "bender")
+}
+
+func TestDoubleInit(t *testing.T) {
+	log.InitTest("TEST", 0, log.DevWriter{Device: log.DevAll, Writer: new(bytes.Buffer)})
+
+	var buf log.SafeBuffer
+	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+	log.Warnf("ctx", "ExampleLog", "Hola, mundo")
+
+	log.Shutdown()
+
+	expected := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ctx: ExampleLog: Warning: Hola, mundo\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("Got:\n%q\nExpected:\n%q", got, expected)
+	}
+}
+
+// TestPushContext tests that Trace0 uses the context pushed by the goroutine.
+func TestPushContext(t *testing.T) {
+	t.Log("Given the need to log without passing a context.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		pop1 := log.PushContext("1234")
+		log.Trace0("A")
+		pop2 := log.PushContext("5678")
+		log.Trace0("B")
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			log.Trace0("C")
+			wg.Done()
+		}()
+		wg.Wait()
+
+		pop2()
+		log.Trace0("D")
+		pop1()
+		log.Trace0("E")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: 1234: log_test.TestPushContext: Trace: A\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: 5678: log_test.TestPushContext: Trace: B\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: : log_test.TestPushContext.func1: Trace: C\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: 1234: log_test.TestPushContext: Trace: D\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: : log_test.TestPushContext: Trace: E\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould log the pushed context for each goroutine.", succeed)
+		} else {
+			t.Errorf("\tShould log the pushed context for each goroutine. %s %q", failed, got)
+		}
+	}
+}
+
+// TestArgTransform tests that format arguments can be transformed.
+func TestArgTransform(t *testing.T) {
+	t.Log("Given the need to summarize large format arguments.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetArgTransform(func(i int, v interface{}) interface{} {
+			if err, ok := v.(error); ok && len(err.Error()) > 5 {
+				return err.Error()[:5] + "..."
+			}
+			return v
+		})
+
+		log.Errf(errors.New("E"), "TEST", "TestArgTransform", "resp[%v] code[%d]", errors.New("a very long dump"), 500)
+		log.SetArgTransform(nil)
+		log.Errf(errors.New("E"), "TEST", "TestArgTransform", "resp[%v]", errors.New("a very long dump"))
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestArgTransform: ERROR: resp[a ver...] code[500]: E\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestArgTransform: ERROR: resp[a very long dump]: E\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould only transform arguments while the transform is set.", succeed)
+		} else {
+			t.Errorf("\tShould only transform arguments while the transform is set. %s %q", failed, got)
+		}
+	}
+}
+
+// TestDataSingleLine tests that data blocks can be folded onto one line.
+func TestDataSingleLine(t *testing.T) {
+	t.Log("Given the need to write each data block as one physical line.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetDataSingleLine(true)
+
+		log.DataString("TEST", "boo", "not\nthat\n\nthey")
+		log.DataBlock("TEST", "moo", map[string]int{"a": 1, "b": 2})
+		log.DataTrace("TEST", "aah", SomeFormatter{}, SomeFormatter{})
+		log.DataString("TEST", "boo", "\n\n")
+
+		log.SetDataSingleLine(false)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: DATA: not\\nthat\\nthey\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: moo: DATA: {\"a\":1,\"b\":2}\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: aah: DATA: 42\\n42\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: boo: DATA:\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould fold each data block onto the header line.", succeed)
+		} else {
+			t.Errorf("\tShould fold each data block onto the header line. %s %q", failed, got)
+		}
+	}
+}
+
+// TestCollapseConsecutive tests that duplicate lines are counted.
+func TestCollapseConsecutive(t *testing.T) {
+	t.Log("Given the need to collapse consecutive duplicate lines.")
+	{
+		var buf1, buf2 log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf1})
+		log.Dev.Warning(&buf2)
+		log.SetCollapseConsecutive(true)
+
+		for i := 0; i < 3; i++ {
+			log.Tracef("TEST", "retry", "connect failed")
+			log.Warnf("TEST", "retry", "slow")
+		}
+		log.Tracef("TEST", "retry", "connected")
+		log.Tracef("TEST", "retry", "connected")
+
+		log.Shutdown()
+		log.SetCollapseConsecutive(false)
+
+		exp1 := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: retry: Trace: connect failed\n" +
+			"... (repeated 2 times)\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: retry: Trace: connected\n" +
+			"... (repeated 1 times)\n"
+		if got := buf1.String(); got == exp1 {
+			t.Log("\tShould collapse the duplicate trace lines.", succeed)
+		} else {
+			t.Errorf("\tShould collapse the duplicate trace lines. %s %q", failed, got)
+		}
+
+		exp2 := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: retry: Warning: slow\n" +
+			"... (repeated 2 times)\n"
+		if got := buf2.String(); got == exp2 {
+			t.Log("\tShould track duplicates for each device.", succeed)
+		} else {
+			t.Errorf("\tShould track duplicates for each device. %s %q", failed, got)
+		}
+	}
+}
+
+func TestEvent(t *testing.T) {
+	t.Log("Given the need to write structured events.")
+	{
+		var trace, events log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevAll, Writer: &trace},
+			log.DevWriter{Device: log.DevEvent, Writer: &events},
+		)
+
+		log.Event("login", map[string]interface{}{"user": "bob", "ok": true, "tries": 2})
+		log.Event("logout", nil)
+		log.Event("broken", map[string]interface{}{"user": "bob smith", "ch": make(chan int)})
+		cyclic := map[string]interface{}{}
+		cyclic["self"] = cyclic
+		log.Event("cyclic", map[string]interface{}{"m": cyclic})
+		log.Tracef("TEST", "foo", "trace")
+
+		log.Shutdown()
+
+		exp := `{"time":"2009-11-10T15:00:00Z","app":"LOG","pid":69910,"event":"login","fields":{"ok":true,"tries":2,"user":"bob"}}` + "\n" +
+			`{"time":"2009-11-10T15:00:00Z","app":"LOG","pid":69910,"event":"logout"}` + "\n" +
+			`2009/11/10 15:00:00.000000000: LOG[69910]: EVENT FALLBACK: broken: ch=<chan int> user="bob smith": json: unsupported type: chan int` + "\n"
+		got := events.String()
+		if strings.HasPrefix(got, exp) && strings.Contains(got, "EVENT FALLBACK: cyclic: m=<map[string]interface {}>: json: unsupported value") {
+			t.Log("\tShould write each event as a line of JSON.", succeed)
+		} else {
+			t.Errorf("\tShould write each event as a line of JSON. %s %q", failed, got)
+		}
+
+		if got := trace.String(); !strings.Contains(got, "event") && strings.Contains(got, "Trace: trace") {
+			t.Log("\tShould keep events out of the trace lines.", succeed)
+		} else {
+			t.Errorf("\tShould keep events out of the trace lines. %s %q", failed, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Log("Given the need to drop lines before they are queued.")
+	{
+		var buf, audit log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevAll, Writer: &buf},
+			log.DevWriter{Device: log.DevAudit, Writer: &audit},
+		)
+
+		var entries []log.Entry
+		log.SetFilter(func(e log.Entry) bool {
+			entries = append(entries, e)
+			return e.Function != "health" && !strings.Contains(e.Line, "secret")
+		})
+		defer log.SetFilter(nil)
+
+		log.Tracef("TEST", "health", "ok")
+		log.Tracef("TEST", "foo", "the secret is %d", 42)
+		log.Warnf("TEST", "foo", "kept")
+		log.Splunk(log.SplunkPair{Key: "secret", Value: 1})
+		log.Event("secret", nil)
+		log.Audit("bob", "read", "secret", "success")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: kept\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould only write the lines the filter keeps.", succeed)
+		} else {
+			t.Errorf("\tShould only write the lines the filter keeps. %s %q", failed, got)
+		}
+
+		if len(entries) == 5 && entries[0].Device == log.DevTrace && entries[0].Context == "TEST" && entries[2].Device == log.DevWarning &&
+			entries[3].Device == log.DevSplunk && entries[4].Device == log.DevEvent {
+			t.Log("\tShould pass the device, context and function to the filter.", succeed)
+		} else {
+			t.Errorf("\tShould pass the device, context and function to the filter. %s %+v", failed, entries)
+		}
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: actor=bob action=read resource=secret outcome=success\n"
+		if got := audit.String(); got == exp {
+			t.Log("\tShould not filter the audit records.", succeed)
+		} else {
+			t.Errorf("\tShould not filter the audit records. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSplunkTimeFormat(t *testing.T) {
+	t.Log("Given the need to write Splunk timestamps as epoch time.")
+	{
+		defer log.SetSplunkTimeFormat(log.SplunkTimeISO)
+
+		formats := []struct {
+			n   string
+			f   log.SplunkTimeFormat
+			exp string
+		}{
+			{"ISO", log.SplunkTimeISO, "2009/11/10 15:00:00.000000000: k=v\n"},
+			{"epoch", log.SplunkTimeEpoch, "1257865200: k=v\n"},
+			{"epoch millis", log.SplunkTimeEpochMillis, "1257865200000: k=v\n"},
+		}
+
+		for _, f := range formats {
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetSplunkTimeFormat(f.f)
+
+			log.Splunk(log.SplunkPair{Key: "k", Value: "v"})
+
+			log.Shutdown()
+
+			if got := buf.String(); got == f.exp {
+				t.Logf("\tShould write the %s timestamp. %v", f.n, succeed)
+			} else {
+				t.Errorf("\tShould write the %s timestamp. %s %q", f.n, failed, got)
+			}
+		}
+	}
+}
+
+func TestPriorityWriter(t *testing.T) {
+	t.Log("Given the need to write important lines first in a bulk write.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		high := log.NewPriorityWriter(log.DevTrace, 10)
+		low := log.NewPriorityWriter(log.DevTrace, -1)
+
+		fmt.Fprint(low, "low 1")
+		log.Tracef("TEST", "foo", "trace 1")
+		fmt.Fprint(high, "high 1")
+		log.Tracef("TEST", "foo", "trace 2")
+		fmt.Fprint(high, "high 2")
+		fmt.Fprint(low, "low 2")
+
+		log.Shutdown()
+
+		exp := "high 1\nhigh 2\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: trace 1\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: trace 2\n" +
+			"low 1\nlow 2\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould order the lines by priority.", succeed)
+		} else {
+			t.Errorf("\tShould order the lines by priority. %s %q", failed, got)
+		}
+	}
+}
+
+func TestTraceWithBlob(t *testing.T) {
+	t.Log("Given the need to keep large payloads out of the trace.")
+	{
+		dir, err := ioutil.TempDir("", "blobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		noRef := log.TraceWithBlob("TEST", "foo", "no dir", []byte("hello"))
+
+		log.SetBlobDir(dir)
+		defer log.SetBlobDir("")
+
+		ref := log.TraceWithBlob("TEST", "foo", "payload", []byte("hello"))
+
+		log.Shutdown()
+
+		if noRef == "" && ref == "2cf24dba5fb0a30e" {
+			t.Log("\tShould return the reference of the stored blob.", succeed)
+		} else {
+			t.Errorf("\tShould return the reference of the stored blob. %s %q %q", failed, noRef, ref)
+		}
+
+		if b, err := ioutil.ReadFile(filepath.Join(dir, ref+".bin")); err == nil && string(b) == "hello" {
+			t.Log("\tShould write the blob to the blob directory.", succeed)
+		} else {
+			t.Errorf("\tShould write the blob to the blob directory. %s %q %v", failed, b, err)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: no dir: blob ERROR: no blob directory is set\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: payload: blob[2cf24dba5fb0a30e]\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould reference the blob in the trace line.", succeed)
+		} else {
+			t.Errorf("\tShould reference the blob in the trace line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	t.Log("Given the need to limit the number of fields on a line.")
+	{
+		var buf, audit log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevAll, Writer: &buf},
+			log.DevWriter{Device: log.DevAudit, Writer: &audit},
+		)
+		log.SetMaxFields(2)
+		defer log.SetMaxFields(0)
+
+		log.Splunk(log.SplunkPair{Key: "a", Value: 1}, log.SplunkPair{Key: "b", Value: 2}, log.SplunkPair{Key: "c", Value: 3}, log.SplunkPair{Key: "d", Value: 4})
+		log.Splunk(log.SplunkPair{Key: "a", Value: 1}, log.SplunkPair{Key: "b", Value: 2})
+		log.Event("e", map[string]interface{}{"a": 1, "b": 2, "c": 3})
+		log.DataGroup("TEST", "foo").KV("a", 1).KV("b", 2).KV("c", 3).Emit()
+		log.Audit("bob", "delete", "user/7", "success", log.SplunkPair{Key: "ip", Value: "127.0.0.1"})
+		log.AuditFields(map[string]interface{}{"a": 1, "b": 2, "c": 3})
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: a=1 b=2 ...(+2 more)\n" +
+			"2009/11/10 15:00:00.000000000: a=1 b=2\n" +
+			`{"time":"2009-11-10T15:00:00Z","app":"LOG","pid":69910,"event":"e","fields":{"a":1,"b":2,"...":"(+1 more)"}}` + "\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta: 1\n\tb: 2\n\t...(+1 more)\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould replace the extra fields with a marker.", succeed)
+		} else {
+			t.Errorf("\tShould replace the extra fields with a marker. %s %q", failed, got)
+		}
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: actor=bob action=delete resource=user/7 outcome=success ip=127.0.0.1\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: a=1 b=2 c=3\n"
+		if got := audit.String(); got == exp {
+			t.Log("\tShould write every field of the audit records.", succeed)
+		} else {
+			t.Errorf("\tShould write every field of the audit records. %s %q", failed, got)
+		}
+	}
+}
+
+func TestMaxDataLines(t *testing.T) {
+	t.Log("Given the need to limit the number of lines in a data block.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetMaxDataLines(2)
+		defer log.SetMaxDataLines(0)
+
+		log.DataString("TEST", "foo", "a\nb\n\nc\nd\n")
+		log.DataString("TEST", "foo", "a\nb\n")
+		log.DataTrace("TEST", "foo", SomeFormatter{}, SomeFormatter{}, SomeFormatter{})
+		log.SetDataSingleLine(true)
+		log.DataString("TEST", "foo", "a\nb\nc")
+		log.SetDataSingleLine(false)
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta\n\tb\n\t... (2 more lines omitted)\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta\n\tb\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\t42\n\t42\n\t... (1 more lines omitted)\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA: a\\nb\\n... (1 more lines omitted)\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould replace the extra lines with a marker.", succeed)
+		} else {
+			t.Errorf("\tShould replace the extra lines with a marker. %s %q", failed, got)
+		}
+	}
+}
+
+func TestDataMarshalErrorBehavior(t *testing.T) {
+	t.Log("Given the need to choose what happens to a data block that can't be encoded.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetDataMarshalErrorBehavior(log.MarshalErrorInline)
+
+		log.DataBlock("TEST", "foo", math.NaN())
+		log.SetDataMarshalErrorBehavior(log.MarshalErrorWarn)
+		log.DataBlock("TEST", "foo", math.NaN())
+		log.SetDataMarshalErrorBehavior(log.MarshalErrorSkip)
+		log.DataBlock("TEST", "foo", math.NaN())
+		log.DataBlock("TEST", "foo", 42)
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\tjson: unsupported value: NaN\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: DataBlock: json: unsupported value: NaN\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\t42\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould inline, warn about or skip the error.", succeed)
+		} else {
+			t.Errorf("\tShould inline, warn about or skip the error. %s %q", failed, got)
+		}
+	}
+}
+
+func TestApplyRouting(t *testing.T) {
+	t.Log("Given the need to route the devices from a config file.")
+	{
+		dir, err := ioutil.TempDir("", "routing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		all := filepath.Join(dir, "all.log")
+		errs := filepath.Join(dir, "error.log")
+		err = log.ApplyRouting([]log.Route{
+			{Tag: "all", Dest: all},
+			{Tag: "ERROR", Dest: errs},
+			{Tag: "warning", Dest: errs},
+		})
+		if err == nil {
+			t.Log("\tShould apply the routes.", succeed)
+		} else {
+			t.Errorf("\tShould apply the routes. %s %v", failed, err)
+		}
+
+		log.Tracef("TEST", "foo", "hello")
+		log.Errf(errors.New("A"), "TEST", "foo", "failed")
+		log.Warnf("TEST", "foo", "careful")
+		log.Shutdown()
+
+		exp := map[string]string{
+			all: "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello\n",
+			errs: "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: ERROR: failed: A\n" +
+				"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Warning: careful\n",
+		}
+		for name, want := range exp {
+			got, err := ioutil.ReadFile(name)
+			if err == nil && string(got) == want {
+				t.Logf("\tShould write the lines routed to %s. %v", filepath.Base(name), succeed)
+			} else {
+				t.Errorf("\tShould write the lines routed to %s. %s %q %v", filepath.Base(name), failed, got, err)
+			}
+		}
+		if buf.String() == "" {
+			t.Log("\tShould not write to the writers set before.", succeed)
+		} else {
+			t.Errorf("\tShould not write to the writers set before. %s %q", failed, buf.String())
+		}
+
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		bad := [][]log.Route{
+			{{Tag: "trace", Dest: "stderr"}, {Tag: "bogus", Dest: "stdout"}},
+			{{Tag: "trace", Dest: ""}},
+			{{Tag: "trace", Dest: filepath.Join(dir, "missing", "trace.log")}},
+		}
+		for _, routes := range bad {
+			if err := log.ApplyRouting(routes); err != nil {
+				t.Logf("\tShould reject %v. %v %v", routes, succeed, err)
+			} else {
+				t.Errorf("\tShould reject %v. %s", routes, failed)
+			}
+		}
+
+		log.Tracef("TEST", "foo", "kept")
+		log.Shutdown()
+		if buf.String() == "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: kept\n" {
+			t.Log("\tShould change nothing when a route is rejected.", succeed)
+		} else {
+			t.Errorf("\tShould change nothing when a route is rejected. %s %q", failed, buf.String())
+		}
+	}
+}
+
+func TestIdleFlushDelay(t *testing.T) {
+	t.Log("Given the need to write the first line on a quiet device promptly.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		defer log.SetBulkLogPeriod(log.GetBulkLogPeriod())
+		log.SetBulkLogPeriod(time.Hour)
+
+		log.Tracef("TEST", "foo", "first")
+		if err := log.WaitForWrites(1, time.Second); err == nil {
+			t.Log("\tShould write the first line without waiting for the bulk log period.", succeed)
+		} else {
+			t.Errorf("\tShould write the first line without waiting for the bulk log period. %s %v", failed, err)
+		}
+
+		log.Tracef("TEST", "foo", "second")
+		if err := log.WaitForWrites(1, 100*time.Millisecond); err != nil {
+			t.Log("\tShould buffer the lines that follow for the bulk log period.", succeed)
+		} else {
+			t.Error("\tShould buffer the lines that follow for the bulk log period.", failed)
+		}
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: first\n" +
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: second\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write both lines in order.", succeed)
+		} else {
+			t.Errorf("\tShould write both lines in order. %s %q", failed, got)
+		}
+	}
+}
+
+func TestTagCounts(t *testing.T) {
+	t.Log("Given the need to count the lines written for each tag.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		before := log.TagCounts()
+
+		log.Err(errors.New("A"), "TEST", "foo")
+		log.Errf(errors.New("B"), "TEST", "foo", "b")
+		log.Tracef("TEST", "foo", "trace")
+		log.Start("TEST", "foo")
+		log.Complete("TEST", "foo")
+		log.Splunk(log.SplunkPair{Key: "k", Value: "v"})
+
+		log.Shutdown()
+
+		after := log.TagCounts()
+		exp := map[string]uint64{"ERROR": 2, "TRACE": 1, "START": 2, "SPLUNK": 1, "DATA": 0, "WARNING": 0}
+		for tag, n := range exp {
+			if got := after[tag] - before[tag]; got == n {
+				t.Logf("\tShould count %d %s lines. %v", n, tag, succeed)
+			} else {
+				t.Errorf("\tShould count %d %s lines. %s %d", n, tag, failed, got)
+			}
+		}
+
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.Shutdown()
+		if got := log.TagCounts(); got["ERROR"] == 0 && got["START"] == 0 {
+			t.Log("\tShould reset the counts on Init.", succeed)
+		} else {
+			t.Errorf("\tShould reset the counts on Init. %s %v", failed, got)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	t.Log("Given the need to write timestamps as epoch milliseconds.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.SetTimeFormat(log.FormatTimeEpochMillis)
+		defer log.SetTimeFormat(log.FormatTimeDefault)
+
+		log.Tracef("TEST", "foo", "trace")
+		log.Splunk(log.SplunkPair{Key: "k", Value: "v"})
+
+		log.Shutdown()
+
+		exp := "1257865200000: LOG[69910]: file.go#512: TEST: foo: Trace: trace\n" +
+			"1257865200000: k=v\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the frozen time in epoch milliseconds.", succeed)
+		} else {
+			t.Errorf("\tShould write the frozen time in epoch milliseconds. %s %q", failed, got)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	t.Log("Given the need to check what was logged without comparing strings.")
+	{
+		rec := log.NewRecorder()
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: rec})
+
+		log.Start("1234", "foo")
+		log.Err(errors.New("A"), "1234", "foo")
+		log.DataString("1234", "foo", "a\nb")
+		log.Splunk(log.SplunkPair{Key: "k", Value: "v"})
+
+		log.Shutdown()
+
+		exp := []log.Record{
+			{Tag: "Started", Context: "1234", Func: "foo"},
+			{Tag: "ERROR", Context: "1234", Func: "foo", Message: "A"},
+			{Tag: "DATA", Context: "1234", Func: "foo", Message: "a\nb"},
+			{Message: "k=v"},
+		}
+
+		got := rec.Entries()
+		if len(got) != len(exp) {
+			t.Fatalf("\tShould capture %d records. %s %+v", len(exp), failed, got)
+		}
+		for i, e := range exp {
+			g := got[i]
+			g.Line = ""
+			if g == e {
+				t.Logf("\tShould parse record %d. %v", i, succeed)
+			} else {
+				t.Errorf("\tShould parse record %d. %s %+v", i, failed, got[i])
+			}
+		}
+
+		rec.Reset()
+		if len(rec.Entries()) == 0 {
+			t.Log("\tShould discard the records on Reset.", succeed)
+		} else {
+			t.Error("\tShould discard the records on Reset.", failed)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	t.Log("Given the need to write audit records to their own device.")
+	{
+		var trace, audit log.SafeBuffer
+		log.InitTest("LOG", 10,
+			log.DevWriter{Device: log.DevAll, Writer: &trace},
+			log.DevWriter{Device: log.DevAudit, Writer: &audit},
+		)
+
+		log.Audit("bob", "delete", "user/7", "success", log.SplunkPair{Key: "ip", Value: "127.0.0.1"})
+		log.Audit("bob", "", "user/7", "success")
+
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: actor=bob action=delete resource=user/7 outcome=success ip=127.0.0.1\n"
+		if got := audit.String(); got == exp {
+			t.Log("\tShould write complete records to DevAudit.", succeed)
+		} else {
+			t.Errorf("\tShould write complete records to DevAudit. %s %q", failed, got)
+		}
+
+		exp = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: log_test.TestAudit: Warning: audit record dropped: missing action\n"
+		if got := trace.String(); got == exp {
+			t.Log("\tShould warn about records missing a required field.", succeed)
+		} else {
+			t.Errorf("\tShould warn about records missing a required field. %s %q", failed, got)
+		}
+	}
+
+	t.Log("Given the need to share a writer between audit records and trace lines.")
+	{
+		// A bytes.Buffer isn't safe for concurrent writes, so the race
+		// detector catches a record written during a flush.
+		var buf bytes.Buffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					log.Errf(errors.New("A"), "TEST", "foo", "n[%d]", j)
+					log.Audit("bob", "delete", "user/7", "success")
+				}
+			}()
+		}
+		wg.Wait()
+		log.Shutdown()
+
+		if got := strings.Count(buf.String(), "\n"); got == 400 {
+			t.Log("\tShould write every record and line.", succeed)
+		} else {
+			t.Errorf("\tShould write every record and line. %s %d", failed, got)
+		}
+	}
+}
+
+func TestFramedWriter(t *testing.T) {
+	t.Log("Given the need to write each line as a length-prefixed frame.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: log.NewFramedWriter(&buf)})
+
+		log.Tracef("TEST", "foo", "one")
+		log.DataString("TEST", "foo", "a\nb")
+		log.Tracef("TEST", "foo", "two")
+
+		log.Shutdown()
+
+		exp := []string{
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: one",
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n\ta\n\tb",
+			"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: two",
+		}
+
+		r := strings.NewReader(buf.String())
+		var got []string
+		for {
+			f, err := log.ReadFrame(r)
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("\tShould read every frame. %s %v", failed, err)
+				}
+				break
+			}
+			got = append(got, string(f))
+		}
+
+		if strings.Join(got, "|") == strings.Join(exp, "|") {
+			t.Log("\tShould write one frame per trace line.", succeed)
+		} else {
+			t.Errorf("\tShould write one frame per trace line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSplunkIncludeCaller(t *testing.T) {
+	t.Log("Given the need to locate where a Splunk line was written.")
+	{
+		t.Log("\tWhen caller pairs are turned on in test mode.")
+		{
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetSplunkIncludeCaller(true)
+			log.Splunk(log.SplunkPair{Key: "Key1", Value: "Value1"})
+			log.SetSplunkIncludeCaller(false)
+			log.Splunk(log.SplunkPair{Key: "Key1", Value: "Value1"})
+			log.Shutdown()
+
+			exp := "2009/11/10 15:00:00.000000000: file=file.go line=512 func=log_test.TestSplunkIncludeCaller Key1=Value1\n" +
+				"2009/11/10 15:00:00.000000000: Key1=Value1\n"
+			if got := buf.String(); got == exp {
+				t.Log("\t\tShould write the caller pairs first.", succeed)
+			} else {
+				t.Errorf("\t\tShould write the caller pairs first. %s %q", failed, got)
+			}
+		}
+
+		t.Log("\tWhen caller pairs are turned on.")
+		{
+			var buf log.SafeBuffer
+			log.Init("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetSplunkIncludeCaller(true)
+			_, _, line, _ := runtime.Caller(0)
+			log.Splunk(log.SplunkPair{Key: "Key1", Value: "Value1"})
+			log.SetSplunkIncludeCaller(false)
+			log.Shutdown()
+
+			pairs := fmt.Sprintf(" file=log_test.go line=%d func=log_test.TestSplunkIncludeCaller Key1=Value1\n", line+1)
+			if got := buf.String(); strings.HasSuffix(got, pairs) {
+				t.Log("\t\tShould write the file and line of the call.", succeed)
+			} else {
+				t.Errorf("\t\tShould write the file and line of the call. %s %q", failed, got)
+			}
+		}
+	}
+}
 
-			resetLog()
-			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-			ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-			tt.f(&ll.Up1)
-			log.Shutdown()
+// safeNode is a linked list node used to build values that refer back to
+// themselves.
+type safeNode struct {
+	Name string
+	Next *safeNode
+	Tags map[string]interface{}
+}
 
-			got := logdest.String()
-			if got != tt.expected {
-				t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-				continue
+func TestSafe(t *testing.T) {
+	t.Log("Given the need to log values that might be huge or cyclic.")
+	{
+		a := &safeNode{Name: "a"}
+		b := &safeNode{Name: "b", Next: a}
+		a.Next = b
+
+		tags := map[string]interface{}{"z": 1, "a": []int{1, 2}}
+		tags["self"] = tags
+		c := &safeNode{Name: "c", Tags: tags}
+
+		cases := []struct {
+			name     string
+			v        interface{}
+			maxDepth int
+			maxLen   int
+			expected string
+		}{
+			{"a cycle of pointers", a, 0, 0, "&{Name:a Next:&{Name:b Next:<cycle *log_test.safeNode> Tags:map[]} Tags:map[]}"},
+			{"a map holding itself", c, 0, 0, "&{Name:c Next:<nil> Tags:map[a:[1 2] self:<cycle map[string]interface {}> z:1]}"},
+			{"a depth limit", a, 2, 0, "&{Name:a Next:&... Tags:map[]}"},
+			{"a length limit", []string{"walrus", "walrus", "walrus"}, 0, 10, "[walrus wa..."},
+			{"a nil value", nil, 0, 0, "<nil>"},
+		}
+
+		for _, tc := range cases {
+			if got := log.Safe(tc.v, tc.maxDepth, tc.maxLen).Format(); got == tc.expected {
+				t.Logf("\tShould bound the output of %s. %s", tc.name, succeed)
+			} else {
+				t.Errorf("\tShould bound the output of %s. %s %q", tc.name, failed, got)
 			}
-			t.Log("\t\tLog should match expected.", succeed)
+		}
+
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.DataTrace("TEST", "foo", log.Safe(a, 2, 0))
+		log.Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n" +
+			"\t&{Name:a Next:&... Tags:map[]}\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the bounded value with DataTrace.", succeed)
+		} else {
+			t.Errorf("\tShould write the bounded value with DataTrace. %s %q", failed, got)
 		}
 	}
 }
 
-func TestUpLoggerErrPanic(t *testing.T) {
-	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("\t\tErrPanic should have panicked.", failed)
+func TestCallbackWriter(t *testing.T) {
+	t.Log("Given the need to pass each finished line to a function.")
+	{
+		var lines []string
+		w := log.CallbackWriter(func(line string) {
+			lines = append(lines, line)
+		})
+
+		io.WriteString(w, "one\ntwo\nthr")
+		io.WriteString(w, "ee\n")
+		io.WriteString(w, "four")
+
+		if got := strings.Join(lines, "|"); got == "one|two|three" {
+			t.Log("\tShould call the function once for each complete line.", succeed)
 		} else {
-			t.Log("\t\tErrPanic should have panicked.", succeed)
+			t.Errorf("\tShould call the function once for each complete line. %s %q", failed, lines)
 		}
 
-		got := logdest.String()
-		if got != expected {
-			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-			return
+		lines = nil
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: w})
+		log.Tracef("TEST", "foo", "hello")
+		log.Shutdown()
+
+		exp := "four2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: hello"
+		if len(lines) == 1 && lines[0] == exp {
+			t.Log("\tShould work as a device writer.", succeed)
+		} else {
+			t.Errorf("\tShould work as a device writer. %s %q", failed, lines)
 		}
-		t.Log("\t\tLog should match expected.", succeed)
-	}()
+	}
+}
 
-	resetLog()
-	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-	ll.Up1.ErrPanic(errors.New("A"), "TEST", "TestErrPanic")
+func TestPrefixWriter(t *testing.T) {
+	t.Log("Given the need to mark every line written to a shared file.")
+	{
+		var buf bytes.Buffer
+		w := log.NewPrefixWriter(&buf, "s3 ")
+
+		io.WriteString(w, "one\ntwo\nthr")
+		io.WriteString(w, "ee\n")
+
+		if got := buf.String(); got == "s3 one\ns3 two\ns3 three\n" {
+			t.Log("\tShould write the prefix once at the start of each line.", succeed)
+		} else {
+			t.Errorf("\tShould write the prefix once at the start of each line. %s %q", failed, got)
+		}
+
+		var out log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: log.NewPrefixWriter(&out, "s3 ")})
+		log.DataString("TEST", "foo", "a\nb")
+		log.Shutdown()
+
+		exp := "s3 2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: DATA:\n" +
+			"s3 \ta\n" +
+			"s3 \tb\n"
+		if got := out.String(); got == exp {
+			t.Log("\tShould prefix each line of a DATA block.", succeed)
+		} else {
+			t.Errorf("\tShould prefix each line of a DATA block. %s %q", failed, got)
+		}
+	}
 }
 
-func TestUpLoggerErrPanicf(t *testing.T) {
-	const expected = "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: ERROR: we're doomed -bender-: A\n2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: TestErrPanic: TERMINATING\n"
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("\t\tErrPanicf should have panicked.", failed)
+func TestStripANSIWriter(t *testing.T) {
+	t.Log("Given the need to write a colorized stream to a file.")
+	{
+		var buf bytes.Buffer
+		w := log.StripANSIWriter(&buf)
+
+		io.WriteString(w, "\x1b[31mERROR\x1b[0m: A\n")
+		io.WriteString(w, "\x1b[1;3")
+		io.WriteString(w, "2mok\x1b")
+		io.WriteString(w, "[0m\n")
+
+		if got := buf.String(); got == "ERROR: A\nok\n" {
+			t.Log("\tShould remove the escape sequences.", succeed)
 		} else {
-			t.Log("\t\tErrPanicf should have panicked.", succeed)
+			t.Errorf("\tShould remove the escape sequences. %s %q", failed, got)
 		}
+	}
+}
 
-		got := logdest.String()
-		if got != expected {
-			t.Errorf("\t\tLog should match expected. %s %q", failed, got)
-			return
+func TestTemplate(t *testing.T) {
+	t.Log("Given the need to change the layout of the trace lines.")
+	{
+		logAll := func() string {
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.Start("TEST", "foo")
+			log.Tracef("TEST", "foo", "hello %d", 1)
+			log.Err(errors.New("A"), "TEST", "foo")
+			log.DataString("TEST", "foo", "line 1\nline 2")
+			log.Shutdown()
+			return buf.String()
 		}
-		t.Log("\t\tLog should match expected.", succeed)
-	}()
 
-	resetLog()
-	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &logdest})
-	ll := log.NewLogger("LOG", func() int { return log.LevelTrace })
-	ll.Up1.ErrPanicf(errors.New("A"), "TEST", "TestErrPanic", "we're doomed -%s-", "bender")
+		builtin := logAll()
+
+		if err := log.SetTemplate(log.DefaultTemplate); err != nil {
+			t.Fatal("\tShould parse the default template.", failed, err)
+		}
+		if got := logAll(); got == builtin {
+			t.Log("\tShould write the same lines with the default template.", succeed)
+		} else {
+			t.Errorf("\tShould write the same lines with the default template. %s %q", failed, got)
+		}
+
+		if err := log.SetTemplate("{{.Time}} {{.App}} {{.Context}} {{.Tag}} {{.Message}}"); err != nil {
+			t.Fatal("\tShould parse the template.", failed, err)
+		}
+		got := logAll()
+		log.SetTemplate("")
+
+		exp := "2009/11/10 15:00:00.000000000 LOG TEST Started \n" +
+			"2009/11/10 15:00:00.000000000 LOG TEST Trace hello 1\n" +
+			"2009/11/10 15:00:00.000000000 LOG TEST ERROR A\n" +
+			"2009/11/10 15:00:00.000000000 LOG TEST DATA \n" +
+			"\tline 1\n" +
+			"\tline 2\n"
+		if got == exp {
+			t.Log("\tShould write the lines with the template.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines with the template. %s %q", failed, got)
+		}
+
+		if err := log.SetTemplate("{{.Time"); err != nil {
+			t.Log("\tShould fail for a template that can't be parsed.", succeed)
+		} else {
+			t.Error("\tShould fail for a template that can't be parsed.", failed)
+		}
+	}
 }
 
-func TestDoubleInit(t *testing.T) {
-	log.InitTest("TEST", 0, log.DevWriter{Device: log.DevAll, Writer: new(bytes.Buffer)})
+func TestAuditFields(t *testing.T) {
+	t.Log("Given the need to write an audit record from a map of fields.")
+	{
+		var buf log.SafeBuffer
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.AuditFields(map[string]interface{}{"actor": "bob", "action": "delete", "count": 2})
+		log.Flush()
+
+		exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: AUDIT: action=delete actor=bob count=2\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the fields in key order once flushed.", succeed)
+		} else {
+			t.Errorf("\tShould write the fields in key order once flushed. %s %q", failed, got)
+		}
 
-	var buf log.SafeBuffer
-	log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+		log.Shutdown()
+	}
+}
 
-	log.Warnf("ctx", "ExampleLog", "Hola, mundo")
+// brokenWriter fails every write.
+type brokenWriter struct{}
 
-	log.Shutdown()
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
 
-	expected := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ctx: ExampleLog: Warning: Hola, mundo\n"
-	if got := buf.String(); got != expected {
-		t.Errorf("Got:\n%q\nExpected:\n%q", got, expected)
+// panicWriter panics on every write.
+type panicWriter struct{}
+
+func (panicWriter) Write(p []byte) (int, error) {
+	panic("device broke")
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Log("Given the need to check the device writers at startup.")
+	{
+		t.Log("\tWhen every writer accepts the probe.")
+		{
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+
+			if err := log.SelfTest(); err == nil {
+				t.Log("\t\tShould report no error.", succeed)
+			} else {
+				t.Errorf("\t\tShould report no error. %s %v", failed, err)
+			}
+
+			if got := buf.String(); strings.Count(got, ": SELFTEST: ") == 1 {
+				t.Log("\t\tShould write one probe line for the shared writer.", succeed)
+			} else {
+				t.Errorf("\t\tShould write one probe line for the shared writer. %s %q", failed, got)
+			}
+
+			log.Shutdown()
+		}
+
+		t.Log("\tWhen a writer fails.")
+		{
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10,
+				log.DevWriter{Device: log.DevAll, Writer: &buf},
+				log.DevWriter{Device: log.DevError, Writer: brokenWriter{}},
+			)
+
+			err := log.SelfTest()
+			if err != nil && err.Error() == "SelfTest: unhealthy devices: ERROR[disk full]" {
+				t.Log("\t\tShould report the device that failed.", succeed)
+			} else {
+				t.Errorf("\t\tShould report the device that failed. %s %v", failed, err)
+			}
+
+			log.Shutdown()
+		}
+
+		t.Log("\tWhen a writer panics.")
+		{
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10,
+				log.DevWriter{Device: log.DevAll, Writer: &buf},
+				log.DevWriter{Device: log.DevError, Writer: panicWriter{}},
+			)
+
+			err := log.SelfTest()
+			if err != nil && err.Error() == "SelfTest: unhealthy devices: ERROR[write panicked: device broke]" {
+				t.Log("\t\tShould report the device that panicked.", succeed)
+			} else {
+				t.Errorf("\t\tShould report the device that panicked. %s %v", failed, err)
+			}
+
+			buf.Reset()
+			log.Err(errors.New("A"), "TEST", "foo")
+			log.Tracef("TEST", "foo", "after")
+			log.Shutdown()
+
+			exp := "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: TEST: foo: Trace: after\n"
+			if got := buf.String(); got == exp {
+				t.Log("\t\tShould keep writing the other devices.", succeed)
+			} else {
+				t.Errorf("\t\tShould keep writing the other devices. %s %q", failed, got)
+			}
+		}
+
+		t.Log("\tWhen logging is not initialized.")
+		{
+			if err := log.SelfTest(); err != nil {
+				t.Log("\t\tShould report an error.", succeed)
+			} else {
+				t.Errorf("\t\tShould report an error. %s", failed)
+			}
+		}
+	}
+}
+
+// TestSourceLocation tests that the file slot can be turned off.
+func TestSourceLocation(t *testing.T) {
+	t.Log("Given the need to drop the file and line number from trace lines.")
+	{
+		t.Log("\tWhen source locations are turned off in test mode.")
+		{
+			var buf log.SafeBuffer
+			log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetSourceLocation(false)
+			log.Tracef("TEST", "TestSourceLocation", "Hello")
+			log.SetSourceLocation(true)
+			log.Shutdown()
+
+			exp := "2009/11/10 15:00:00.000000000: LOG[69910]: -: TEST: TestSourceLocation: Trace: Hello\n"
+			if got := buf.String(); got == exp {
+				t.Log("\t\tShould log a \"-\" in the file slot.", succeed)
+			} else {
+				t.Errorf("\t\tShould log a \"-\" in the file slot. %s %q", failed, got)
+			}
+		}
+
+		t.Log("\tWhen source locations are turned off.")
+		{
+			var buf log.SafeBuffer
+			log.Init("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: &buf})
+			log.SetSourceLocation(false)
+			log.Tracef("TEST", "TestSourceLocation", "Hello")
+			log.SetSourceLocation(true)
+			log.Shutdown()
+
+			if got := buf.String(); strings.Contains(got, "]: -: TEST: TestSourceLocation: Trace: Hello\n") {
+				t.Log("\t\tShould log a \"-\" in the file slot.", succeed)
+			} else {
+				t.Errorf("\t\tShould log a \"-\" in the file slot. %s %q", failed, got)
+			}
+		}
+	}
+}
+
+// TestShardedWriter tests that each context is written to its own file.
+func TestShardedWriter(t *testing.T) {
+	t.Log("Given the need to write each context to its own file.")
+	{
+		dir, err := ioutil.TempDir("", "sharded")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sw := log.ShardedWriter(dir, log.ContextKey)
+		log.InitTest("LOG", 10, log.DevWriter{Device: log.DevAll, Writer: sw})
+
+		log.Tracef("tenant1", "foo", "one")
+		log.DataString("tenant2", "foo", "a\nb")
+		log.Tracef("tenant1", "foo", "two")
+		log.Tracef("../escape", "foo", "three")
+
+		log.Shutdown()
+		if err := sw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]string{
+			"tenant1.log": "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: tenant1: foo: Trace: one\n" +
+				"2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: tenant1: foo: Trace: two\n",
+			"tenant2.log":   "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: tenant2: foo: DATA:\n\ta\n\tb\n",
+			".._escape.log": "2009/11/10 15:00:00.000000000: LOG[69910]: file.go#512: ../escape: foo: Trace: three\n",
+		}
+		for name, want := range exp {
+			got, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err == nil && string(got) == want {
+				t.Logf("\tShould write the lines for %s. %v", name, succeed)
+			} else {
+				t.Errorf("\tShould write the lines for %s. %s %q %v", name, failed, got, err)
+			}
+		}
+
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) == len(exp) {
+			t.Log("\tShould only create one file per context.", succeed)
+		} else {
+			t.Errorf("\tShould only create one file per context. %s %d", failed, len(files))
+		}
 	}
 }
 
@@ -805,6 +3828,30 @@ func TestLineNumbers(t *testing.T) {
 	log.DataKV(context, str, str, nil)
 	testLineNumber(t, "log.DataKV", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	log.DataKVQuoted(context, str, str, nil)
+	testLineNumber(t, "log.DataKVQuoted", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.DataList(context, str, str, nil)
+	testLineNumber(t, "log.DataList", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.DataGroup(context, str).KV(str, str).Emit()
+	testLineNumber(t, "log.DataGroup", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.DataReader(context, str, strings.NewReader(str), 100)
+	testLineNumber(t, "log.DataReader", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.TraceWithBlob(context, str, str, nil)
+	testLineNumber(t, "log.TraceWithBlob", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.DataBinary(context, str, []byte(str), log.DumpHexASCII)
+	testLineNumber(t, "log.DataBinary", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	log.DataString(context, str, str)
 	testLineNumber(t, "log.DataString", &buf, thisLineNum)
@@ -821,6 +3868,14 @@ func TestLineNumbers(t *testing.T) {
 	log.Errf(dummyErr, context, str, str)
 	testLineNumber(t, "log.Errf", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	log.WrapErr(dummyErr, context, str)
+	testLineNumber(t, "log.WrapErr", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.WrapErrf(dummyErr, context, str, "%s", str)
+	testLineNumber(t, "log.WrapErrf", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	log.Queryf(context, str, str)
 	testLineNumber(t, "log.Queryf", &buf, thisLineNum)
@@ -833,10 +3888,34 @@ func TestLineNumbers(t *testing.T) {
 	log.Startf(context, str, str)
 	testLineNumber(t, "log.Startf", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	log.StartArgs(context, str)
+	testLineNumber(t, "log.StartArgs", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.CompleteReturns(context, str)
+	testLineNumber(t, "log.CompleteReturns", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	log.Tracef(context, str, str)
 	testLineNumber(t, "log.Tracef", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	log.Trace0(str)
+	testLineNumber(t, "log.Trace0", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.TraceObject(context, str, str)
+	testLineNumber(t, "log.TraceObject", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.Section(str)
+	testLineNumber(t, "log.Section", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	log.TraceEnter(context, str)
+	testLineNumber(t, "log.TraceEnter", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	log.Warnf(context, str, str)
 	testLineNumber(t, "log.Warnf", &buf, thisLineNum)
@@ -865,6 +3944,18 @@ func TestLineNumbers(t *testing.T) {
 	logger.DataKV(context, str, str, nil)
 	testLineNumber(t, "logger.DataKV", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	logger.DataKVQuoted(context, str, str, nil)
+	testLineNumber(t, "logger.DataKVQuoted", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.DataList(context, str, str, nil)
+	testLineNumber(t, "logger.DataList", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.DataGroup(context, str).KV(str, str).Emit()
+	testLineNumber(t, "logger.DataGroup", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	logger.DataString(context, str, str)
 	testLineNumber(t, "logger.DataString", &buf, thisLineNum)
@@ -881,6 +3972,14 @@ func TestLineNumbers(t *testing.T) {
 	logger.Errf(dummyErr, context, str, str)
 	testLineNumber(t, "logger.Errf", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	logger.WrapErr(dummyErr, context, str)
+	testLineNumber(t, "logger.WrapErr", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.WrapErrf(dummyErr, context, str, "%s", str)
+	testLineNumber(t, "logger.WrapErrf", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	logger.Queryf(context, str, str)
 	testLineNumber(t, "logger.Queryf", &buf, thisLineNum)
@@ -893,10 +3992,46 @@ func TestLineNumbers(t *testing.T) {
 	logger.Startf(context, str, str)
 	testLineNumber(t, "logger.Startf", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	logger.StartArgs(context, str)
+	testLineNumber(t, "logger.StartArgs", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.CompleteReturns(context, str)
+	testLineNumber(t, "logger.CompleteReturns", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	logger.Tracef(context, str, str)
 	testLineNumber(t, "logger.Tracef", &buf, thisLineNum)
 
+	thisLineNum += lineDiff
+	logger.Trace0(str)
+	testLineNumber(t, "logger.Trace0", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.TraceObject(context, str, str)
+	testLineNumber(t, "logger.TraceObject", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.Section(str)
+	testLineNumber(t, "logger.Section", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.TraceEnter(context, str)
+	testLineNumber(t, "logger.TraceEnter", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.Info(str)
+	testLineNumber(t, "logger.Info", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.Warn(str)
+	testLineNumber(t, "logger.Warn", &buf, thisLineNum)
+
+	thisLineNum += lineDiff
+	logger.Error(dummyErr)
+	testLineNumber(t, "logger.Error", &buf, thisLineNum)
+
 	thisLineNum += lineDiff
 	logger.Warnf(context, str, str)
 	testLineNumber(t, "logger.Warnf", &buf, thisLineNum)
@@ -931,6 +4066,15 @@ func testLoggerUp1(t *testing.T, logger *log.Logger, buf *log.SafeBuffer, expect
 	logger.Up1.DataKV(context, str, str, nil)
 	testLineNumber(t, "logger.Up1.DataKV", buf, expectedLineNumber)
 
+	logger.Up1.DataKVQuoted(context, str, str, nil)
+	testLineNumber(t, "logger.Up1.DataKVQuoted", buf, expectedLineNumber)
+
+	logger.Up1.DataList(context, str, str, nil)
+	testLineNumber(t, "logger.Up1.DataList", buf, expectedLineNumber)
+
+	logger.Up1.DataGroup(context, str).KV(str, str).Emit()
+	testLineNumber(t, "logger.Up1.DataGroup", buf, expectedLineNumber)
+
 	logger.Up1.DataString(context, str, str)
 	testLineNumber(t, "logger.Up1.DataString", buf, expectedLineNumber)
 
@@ -943,6 +4087,12 @@ func testLoggerUp1(t *testing.T, logger *log.Logger, buf *log.SafeBuffer, expect
 	logger.Up1.Errf(dummyErr, context, str, str)
 	testLineNumber(t, "logger.Up1.Errf", buf, expectedLineNumber)
 
+	logger.Up1.WrapErr(dummyErr, context, str)
+	testLineNumber(t, "logger.Up1.WrapErr", buf, expectedLineNumber)
+
+	logger.Up1.WrapErrf(dummyErr, context, str, "%s", str)
+	testLineNumber(t, "logger.Up1.WrapErrf", buf, expectedLineNumber)
+
 	logger.Up1.Queryf(context, str, str)
 	testLineNumber(t, "logger.Up1.Queryf", buf, expectedLineNumber)
 
@@ -952,9 +4102,36 @@ func testLoggerUp1(t *testing.T, logger *log.Logger, buf *log.SafeBuffer, expect
 	logger.Up1.Startf(context, str, str)
 	testLineNumber(t, "logger.Up1.Startf", buf, expectedLineNumber)
 
+	logger.Up1.StartArgs(context, str)
+	testLineNumber(t, "logger.Up1.StartArgs", buf, expectedLineNumber)
+
+	logger.Up1.CompleteReturns(context, str)
+	testLineNumber(t, "logger.Up1.CompleteReturns", buf, expectedLineNumber)
+
 	logger.Up1.Tracef(context, str, str)
 	testLineNumber(t, "logger.Up1.Tracef", buf, expectedLineNumber)
 
+	logger.Up1.Trace0(str)
+	testLineNumber(t, "logger.Up1.Trace0", buf, expectedLineNumber)
+
+	logger.Up1.TraceObject(context, str, str)
+	testLineNumber(t, "logger.Up1.TraceObject", buf, expectedLineNumber)
+
+	logger.Up1.Section(str)
+	testLineNumber(t, "logger.Up1.Section", buf, expectedLineNumber)
+
+	logger.Up1.TraceEnter(context, str)
+	testLineNumber(t, "logger.Up1.TraceEnter", buf, expectedLineNumber)
+
+	logger.Up1.Info(str)
+	testLineNumber(t, "logger.Up1.Info", buf, expectedLineNumber)
+
+	logger.Up1.Warn(str)
+	testLineNumber(t, "logger.Up1.Warn", buf, expectedLineNumber)
+
+	logger.Up1.Error(dummyErr)
+	testLineNumber(t, "logger.Up1.Error", buf, expectedLineNumber)
+
 	logger.Up1.Warnf(context, str, str)
 	testLineNumber(t, "logger.Up1.Warnf", buf, expectedLineNumber)
 }
@@ -962,8 +4139,10 @@ func testLoggerUp1(t *testing.T, logger *log.Logger, buf *log.SafeBuffer, expect
 // testLineNumber processes the logging line, extracts the line number and compares it against what
 // is expected.
 func testLineNumber(t *testing.T, testCall string, buf *log.SafeBuffer, expectedLineNumber int) {
-	// sleep a little longer than the bulkLogPeriod before reading to make sure the string gets pushed in the buffer.
-	time.Sleep(log.GetBulkLogPeriod() + 10*time.Millisecond)
+	// wait for the line to be written before reading the buffer.
+	if err := log.WaitForWrites(1, log.GetBulkLogPeriod()+time.Second); err != nil {
+		t.Errorf("%s: %v", testCall, err)
+	}
 
 	str := buf.String()
 	buf.Reset() // done with the buffer, clean it
@@ -18,8 +18,12 @@ package log
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"regexp"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -250,6 +254,23 @@ func TestOutput(t *testing.T) {
 		}
 		t.Log("\tempty format should generate error message.", succeed)
 
+		SetEmptyMessage(`{"diag":"empty"}`)
+		defer SetEmptyMessage("")
+
+		buf.Reset()
+		Init("TEST", 0, DevWriter{
+			Device: DevAll,
+			Writer: &buf,
+		})
+		output(&buf, "")
+		Shutdown()
+
+		if buf.String() != "{\"diag\":\"empty\"}\n" {
+			t.Errorf("\tempty format should generate the custom message. %s %q", failed, buf.String())
+		} else {
+			t.Log("\tempty format should generate the custom message.", succeed)
+		}
+
 	}
 	t.Log("Given no format passed to output.")
 	{
@@ -272,6 +293,36 @@ func TestOutput(t *testing.T) {
 	}
 }
 
+func TestDiagnosticEncoded(t *testing.T) {
+	t.Log("Given the need to parse the diagnostics of the logger with an encoder set.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+		SetEncoder(JSONEncoder{})
+		defer SetEncoder(nil)
+
+		output(&buf, "")
+		Flush()
+
+		// Make it look like logging was turned off and the buffer has
+		// been written since.
+		l.mu.Lock()
+		l.loggingOff = true
+		l.mu.Unlock()
+		Tracef("TEST", "foo", "back")
+		Shutdown()
+
+		exp := `{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"TEST","pid":69910,"file":"-","line":0,"func":"log","tag":"LOG ERROR","message":"**** LOG ERROR: MESSAGE IS EMPTY - PLEASE REPORT ****"}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"TEST","pid":69910,"file":"-","line":0,"func":"log","tag":"LOG WARNING","message":"**** LOG WARNING: LOGGING WAS OFF - PLEASE REPORT ****"}` + "\n" +
+			`{"v":1,"time":"2009/11/10 15:00:00.000000000","app":"TEST","pid":69910,"file":"file.go","line":512,"context":"TEST","func":"foo","tag":"Trace","message":"back"}` + "\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the diagnostics as JSON objects.", succeed)
+		} else {
+			t.Errorf("\tShould write the diagnostics as JSON objects. %s %q", failed, got)
+		}
+	}
+}
+
 // This test doesn't actually work because if you pass nil to output another
 // goroutine panics, not this one. I need a second opinion on this. However, I
 // have added a check for nil in output to prevent it from panicking.
@@ -297,3 +348,308 @@ func TestOutputNilWriter(t *testing.T) {
 		output(nil, "Asdf %d", 2)
 	}
 }
+
+func TestSafeWritePanic(t *testing.T) {
+	t.Log("Given the need to keep logging when the writer goroutine panics.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+
+		// A line without a buffer makes the writer panic.
+		l.mu.Lock()
+		atomic.AddInt32(&l.pendingWrites, 1)
+		l.write <- line{w: &buf}
+		l.mu.Unlock()
+
+		Tracef("TEST", "foo", "after")
+
+		done := make(chan struct{})
+		go func() {
+			Shutdown()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Log("\tShould shut down after the writer panics.", succeed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("\tShould shut down after the writer panics.", failed)
+		}
+
+		if atomic.LoadInt32(&l.panicked) == 1 {
+			t.Log("\tShould record that the writer panicked.", succeed)
+		} else {
+			t.Error("\tShould record that the writer panicked.", failed)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: after\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the lines logged after the panic.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines logged after the panic. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSafeWriteFallback(t *testing.T) {
+	t.Log("Given the need to keep logging when the writer goroutine keeps panicking.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+		defer SetSynchronous(false)
+
+		// Each line without a buffer makes the writer panic.
+		for i := 0; i <= maxWriterRestarts; i++ {
+			l.mu.Lock()
+			atomic.AddInt32(&l.pendingWrites, 1)
+			l.write <- line{w: &buf}
+			l.mu.Unlock()
+			Flush()
+		}
+
+		if atomic.LoadInt32(&l.synchronous) == 1 {
+			t.Log("\tShould write synchronously once the restarts run out.", succeed)
+		} else {
+			t.Error("\tShould write synchronously once the restarts run out.", failed)
+		}
+
+		Tracef("TEST", "foo", "after")
+
+		done := make(chan struct{})
+		go func() {
+			Shutdown()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Log("\tShould shut down after the restarts run out.", succeed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("\tShould shut down after the restarts run out.", failed)
+		}
+
+		exp := "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: after\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the lines logged after the restarts run out.", succeed)
+		} else {
+			t.Errorf("\tShould write the lines logged after the restarts run out. %s %q", failed, got)
+		}
+	}
+}
+
+func TestSampledRate(t *testing.T) {
+	t.Log("Given the need to keep about the asked for share of lines.")
+	{
+		SetSeveritySampling(map[int]float64{LevelTrace: 0.25})
+		defer SetSeveritySampling(nil)
+
+		const n = 100000
+		kept := 0
+		for i := 0; i < n; i++ {
+			if !sampled(DevTrace) {
+				kept++
+			}
+		}
+
+		if kept > n/5 && kept < n*3/10 {
+			t.Log("\tShould keep about a quarter of the trace lines.", succeed)
+		} else {
+			t.Errorf("\tShould keep about a quarter of the trace lines. %s %d of %d", failed, kept, n)
+		}
+
+		if !sampled(DevError) {
+			t.Log("\tShould keep the error lines.", succeed)
+		} else {
+			t.Error("\tShould keep the error lines.", failed)
+		}
+
+		SetSeveritySampling(map[int]float64{LevelTrace: 0})
+		if sampled(DevQuery) && !sampled(DevData) {
+			t.Log("\tShould sample the query lines at the trace level.", succeed)
+		} else {
+			t.Error("\tShould sample the query lines at the trace level.", failed)
+		}
+	}
+}
+
+func TestContextSampled(t *testing.T) {
+	t.Log("Given the need to keep all or none of the lines of a context.")
+	{
+		SetContextSampling(0.25)
+		defer SetContextSampling(1)
+
+		const n = 10000
+		kept, mixed := 0, 0
+		for i := 0; i < n; i++ {
+			ctx := fmt.Sprintf("req-%d", i)
+			drop := contextSampled(DevTrace, ctx)
+			if !drop {
+				kept++
+			}
+			if contextSampled(DevError, ctx) != drop || contextSampled(DevStart, ctx) != drop {
+				mixed++
+			}
+		}
+
+		if kept > n/5 && kept < n*3/10 {
+			t.Log("\tShould keep about a quarter of the contexts.", succeed)
+		} else {
+			t.Errorf("\tShould keep about a quarter of the contexts. %s %d of %d", failed, kept, n)
+		}
+
+		if mixed == 0 {
+			t.Log("\tShould keep or drop every line of a context.", succeed)
+		} else {
+			t.Errorf("\tShould keep or drop every line of a context. %s %d", failed, mixed)
+		}
+
+		if !contextSampled(DevTrace, NoContext) && !contextSampled(DevAudit, "req-1") {
+			t.Log("\tShould keep the lines with no context and the audit records.", succeed)
+		} else {
+			t.Error("\tShould keep the lines with no context and the audit records.", failed)
+		}
+
+		SetContextSampling(0)
+		if contextSampled(DevError, "req-1") {
+			t.Log("\tShould drop every context at zero.", succeed)
+		} else {
+			t.Error("\tShould drop every context at zero.", failed)
+		}
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	t.Log("Given the need to keep the newest lines when the writer falls behind.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+		SetOverflowPolicy(PolicyDropOldest)
+		defer SetOverflowPolicy(PolicyTurnOff)
+
+		// Swap in a buffer nothing reads from so it fills up. The flush
+		// makes sure the writer has taken the real one first.
+		Flush()
+		l.mu.Lock()
+		write := l.write
+		l.write = make(chan line, 2)
+		l.mu.Unlock()
+
+		before := DroppedOldest()
+		for _, msg := range []string{"one", "two", "three", "four"} {
+			Tracef("TEST", "foo", msg)
+		}
+
+		l.mu.Lock()
+		full := l.write
+		l.write = write
+		off := l.loggingOff
+		l.mu.Unlock()
+
+		var got []string
+		for len(full) > 0 {
+			ln := <-full
+			got = append(got, ln.b.String())
+			atomic.AddInt32(&l.pendingWrites, -1)
+		}
+		Shutdown()
+
+		exp := []string{
+			"2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: three\n",
+			"2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: four\n",
+		}
+		if reflect.DeepEqual(got, exp) && !off {
+			t.Log("\tShould drop the oldest lines and keep logging on.", succeed)
+		} else {
+			t.Errorf("\tShould drop the oldest lines and keep logging on. %s %q %v", failed, got, off)
+		}
+
+		if n := DroppedOldest() - before; n == 2 {
+			t.Log("\tShould count the dropped lines.", succeed)
+		} else {
+			t.Errorf("\tShould count the dropped lines. %s %d", failed, n)
+		}
+	}
+}
+
+func TestRestoreConfigBeforeInit(t *testing.T) {
+	t.Log("Given the need to restore a configuration saved before Init.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+
+		// Make it look like Init had not been called yet.
+		l.destMu.Lock()
+		dest := l.dest
+		l.dest = map[int8]io.Writer{}
+		l.destMu.Unlock()
+		cfg := SaveConfig()
+		l.destMu.Lock()
+		l.dest = dest
+		l.destMu.Unlock()
+
+		RestoreConfig(cfg)
+		Tracef("TEST", "foo", "kept")
+		Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: TEST: foo: Trace: kept\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould keep the writers set with Init.", succeed)
+		} else {
+			t.Errorf("\tShould keep the writers set with Init. %s %q", failed, got)
+		}
+	}
+}
+
+func TestAuditNotDropped(t *testing.T) {
+	t.Log("Given the need to keep audit records while trace lines are dropped.")
+	{
+		var buf SafeBuffer
+		InitTest("TEST", 10, DevWriter{Device: DevAll, Writer: &buf})
+
+		// Make it look like the writer fell behind so lines are dropped.
+		l.mu.Lock()
+		l.loggingOff = true
+		atomic.AddInt32(&l.pendingWrites, 1)
+		l.mu.Unlock()
+
+		Tracef("TEST", "foo", "dropped")
+		Audit("bob", "delete", "user/7", "success")
+
+		l.mu.Lock()
+		l.loggingOff = false
+		atomic.AddInt32(&l.pendingWrites, -1)
+		l.mu.Unlock()
+
+		Shutdown()
+
+		exp := "2009/11/10 15:00:00.000000000: TEST[69910]: file.go#512: AUDIT: actor=bob action=delete resource=user/7 outcome=success\n"
+		if got := buf.String(); got == exp {
+			t.Log("\tShould write the audit record and drop the trace line.", succeed)
+		} else {
+			t.Errorf("\tShould write the audit record and drop the trace line. %s %q", failed, got)
+		}
+	}
+}
+
+func TestDepthsBounded(t *testing.T) {
+	t.Log("Given the need to bound the contexts kept indented.")
+	{
+		SetIndentNesting(true)
+		defer SetIndentNesting(false)
+
+		for i := 0; i < maxDepths+10; i++ {
+			_, move := nesting(i, "Started")
+			moveDepth(i, move)
+		}
+
+		depths.mu.Lock()
+		n := len(depths.m)
+		depths.mu.Unlock()
+
+		if n == maxDepths {
+			t.Log("\tShould forget contexts past the limit.", succeed)
+		} else {
+			t.Errorf("\tShould forget contexts past the limit. %s %d", failed, n)
+		}
+	}
+}
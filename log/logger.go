@@ -16,6 +16,15 @@
 
 package log
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
 // Set of levels that are compared for filtering tracing to
 // the specific log levels.
 const (
@@ -26,12 +35,24 @@ const (
 	LevelTrace   = 4
 )
 
+// levelNames maps each level to the name written in the level field.
+var levelNames = [...]string{
+	LevelOff:     "Off",
+	LevelError:   "Error",
+	LevelWarning: "Warning",
+	LevelOutput:  "Output",
+	LevelTrace:   "Trace",
+}
+
 // Logger represents an individual logger with logging
 // level permissions.
 type Logger struct {
 	Up1   UplevelLogger
 	name  string
 	level func() int
+	vars  []SplunkPair
+	err   error
+	boost int32
 }
 
 // NewLogger creates a logger for use of writting logs
@@ -49,154 +70,597 @@ func NewLogger(name string, level func() int) *Logger {
 	return l
 }
 
+// NewLoggerWithService creates a logger that writes service[name] first in
+// the var segment of every trace line, for binaries that run several
+// services. It is the same as WithFields with a single service pair, and
+// loggers derived from it keep the field.
+//
+//	lgr := log.NewLoggerWithService("app", "billing", level)
+//	... TEST: foo: Trace: service[billing]: hello
+func NewLoggerWithService(name string, service string, level func() int) *Logger {
+	l := NewLogger(name, level)
+	l.vars = []SplunkPair{{Key: "service", Value: service}}
+
+	return l
+}
+
+// NewLoggerFromEnv creates a logger whose level is read from the
+// environment variable on every call, so it can be changed while the
+// program runs, say by a signal handler calling os.Setenv. The value can be
+// the name of a level, off, error, warn or warning, output or trace in any
+// case, or its number from 0 to 4. When the variable is not set the logger
+// is at LevelOutput. A value that can't be parsed also gives LevelOutput
+// and a warning on stderr, once for each logger.
+//
+//	LOG_LEVEL=trace ./app
+//	lgr := log.NewLoggerFromEnv("app", "LOG_LEVEL")
+func NewLoggerFromEnv(name string, envVar string) *Logger {
+	var warn sync.Once
+
+	return NewLogger(name, func() int {
+		v := os.Getenv(envVar)
+		if v == "" {
+			return LevelOutput
+		}
+
+		level, ok := parseLevel(v)
+		if !ok {
+			warn.Do(func() {
+				fmt.Fprintf(os.Stderr, "NewLoggerFromEnv WARNING: %s=%q is not a level, using Output\n", envVar, v)
+			})
+			return LevelOutput
+		}
+
+		return level
+	})
+}
+
+// parseLevel returns the level for a level name or number.
+func parseLevel(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "warn":
+		return LevelWarning, true
+	case "0", "1", "2", "3", "4":
+		return int(s[0] - '0'), true
+	}
+
+	for level, name := range levelNames {
+		if s == strings.ToLower(name) {
+			return level, true
+		}
+	}
+
+	return 0, false
+}
+
+// WithFields returns a copy of the logger that writes the specified
+// key/value pairs into the var segment of every trace line, as key[value]
+// by default or in the style set with SetVarStyle. The logger it is called
+// on is not changed.
+func (l *Logger) WithFields(kvs ...SplunkPair) *Logger {
+	nl := NewLogger(l.name, l.level)
+
+	nl.vars = make([]SplunkPair, 0, len(l.vars)+len(kvs))
+	nl.vars = append(nl.vars, l.vars...)
+	nl.vars = append(nl.vars, kvs...)
+	nl.err = l.err
+
+	return nl
+}
+
+// WithLevel returns a copy of the logger that uses the specified level
+// function. The name and any fields bound with WithFields or WithError
+// are kept.
+func (l *Logger) WithLevel(level func() int) *Logger {
+	nl := NewLogger(l.name, level)
+	nl.vars = l.vars
+	nl.err = l.err
+
+	return nl
+}
+
+// WithError returns a copy of the logger that writes the error as an
+// err[...] field on every Errf and Warnf line, after any fields bound with
+// WithFields. The logger it is called on is not changed, so the error is
+// dropped by going back to it. A nil error returns a copy with no error.
+func (l *Logger) WithError(err error) *Logger {
+	nl := NewLogger(l.name, l.level)
+	nl.vars = l.vars
+	nl.err = err
+
+	return nl
+}
+
+// Boost forces the logger to LevelTrace until the returned function is
+// called. It can be used to capture a detailed trace around a single
+// operation without changing the configured level. Boosts only apply to
+// this logger, not to loggers derived from it.
+func (l *Logger) Boost() func() {
+	atomic.AddInt32(&l.boost, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt32(&l.boost, -1)
+		})
+	}
+}
+
+// DataEnabled reports whether the logger's Data calls write anything. It
+// can be used to skip building an expensive data block when they don't.
+func (l *Logger) DataEnabled() bool {
+	return l.logLevel() >= LevelOutput && DevEnabled(DevData)
+}
+
+// logLevel returns the level the logger is currently logging at.
+func (l *Logger) logLevel() int {
+	if atomic.LoadInt32(&l.boost) > 0 {
+		return LevelTrace
+	}
+
+	level := l.level()
+	if pushed := int(atomic.LoadInt32(&levels.max)); pushed > level {
+		return pushed
+	}
+
+	return level
+}
+
+// pushedLevels counts the scopes that are active for each pushed level.
+type pushedLevels struct {
+	mu     sync.Mutex
+	counts [LevelTrace + 1]int
+	max    int32
+}
+
+// levels holds the scopes pushed with PushLevel.
+var levels pushedLevels
+
+// update recalculates the highest pushed level. The caller must hold mu.
+func (p *pushedLevels) update() {
+	max := LevelOff
+	for level, count := range p.counts {
+		if count > 0 {
+			max = level
+		}
+	}
+	atomic.StoreInt32(&p.max, int32(max))
+}
+
+// LevelScope is returned by PushLevel to restore the level.
+type LevelScope struct {
+	level int
+	once  sync.Once
+}
+
+// PushLevel raises the level of every Logger to at least the specified
+// level until Pop is called on the returned scope.
+//
+//	scope := log.PushLevel(log.LevelTrace)
+//	defer scope.Pop()
+//
+// The level is global so it affects all goroutines while the scope is
+// active, not just the one that pushed it. When scopes overlap the highest
+// level that is still pushed is used. Package level calls like Tracef are
+// not filtered by level and are not affected.
+func PushLevel(level int) *LevelScope {
+	if level < LevelOff {
+		level = LevelOff
+	}
+	if level > LevelTrace {
+		level = LevelTrace
+	}
+
+	levels.mu.Lock()
+	{
+		levels.counts[level]++
+		levels.update()
+	}
+	levels.mu.Unlock()
+
+	return &LevelScope{level: level}
+}
+
+// Pop restores the level that was in place before the scope was pushed.
+// Calling Pop more than once has no effect.
+func (s *LevelScope) Pop() {
+	s.once.Do(func() {
+		levels.mu.Lock()
+		{
+			levels.counts[s.level]--
+			levels.update()
+		}
+		levels.mu.Unlock()
+	})
+}
+
+// segment returns the var segment for the logger's lines, with the level
+// field in front of the bound fields when SetIncludeLevel is on.
+func (l *Logger) segment() []SplunkPair {
+	if !levelIncluded() {
+		return l.vars
+	}
+
+	level := l.logLevel()
+	switch {
+	case level < LevelOff:
+		level = LevelOff
+	case level > LevelTrace:
+		level = LevelTrace
+	}
+	return append([]SplunkPair{{Key: "level", Value: levelNames[level]}}, l.vars...)
+}
+
+// lines returns the trace line writer for the logger's calls.
+func (l *Logger) lines() uplevel {
+	return uplevel{Up1, l.segment()}
+}
+
+// errLines is lines with the error bound by WithError added to the var
+// segment.
+func (l *Logger) errLines(up Uplevel) uplevel {
+	vars := l.segment()
+	if l.err == nil {
+		return uplevel{up, vars}
+	}
+
+	// Copy the fields so the error isn't added to the logger's own.
+	withErr := make([]SplunkPair, 0, len(vars)+1)
+	withErr = append(withErr, vars...)
+	withErr = append(withErr, SplunkPair{Key: "err", Value: l.err.Error()})
+
+	return uplevel{up, withErr}
+}
+
 // Start is used for the entry into a function.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Start(context interface{}, function string) {
-	if l.level() >= LevelTrace {
-		Up1.Start(context, function)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Start(context, function)
 	}
 }
 
 // Startf is used for the entry into a function with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Startf(context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelTrace {
-		Up1.Startf(context, function, format, a...)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Startf(context, function, format, a...)
+	}
+}
+
+// StartArgs is used for the entry into a function with its arguments
+// written as pairs in the var segment, in order.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) StartArgs(context interface{}, function string, args ...SplunkPair) {
+	if l.logLevel() >= LevelTrace {
+		l.lines().StartArgs(context, function, args...)
 	}
 }
 
 // Complete is used for the exit of a function.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Complete(context interface{}, function string) {
-	if l.level() >= LevelTrace {
-		Up1.Complete(context, function)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Complete(context, function)
 	}
 }
 
+// TraceEnter is used for the entry into a function. It returns a function
+// for the exit that writes the values passed to it.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) TraceEnter(context interface{}, function string) func(...interface{}) {
+	if l.logLevel() >= LevelTrace {
+		return l.lines().TraceEnter(context, function)
+	}
+
+	return func(...interface{}) {}
+}
+
 // Completef is used for the exit of a function with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Completef(context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelTrace {
-		Up1.Completef(context, function, format, a...)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Completef(context, function, format, a...)
+	}
+}
+
+// CompleteReturns is used for the exit of a function with its return
+// values written as pairs in the var segment, in order.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) CompleteReturns(context interface{}, function string, returns ...SplunkPair) {
+	if l.logLevel() >= LevelTrace {
+		l.lines().CompleteReturns(context, function, returns...)
 	}
 }
 
 // CompleteErr is used to write an error with complete into the trace.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) CompleteErr(err error, context interface{}, function string) {
-	if l.level() >= LevelError {
-		Up1.CompleteErr(err, context, function)
+	if l.logLevel() >= LevelError {
+		l.lines().CompleteErr(err, context, function)
 	}
 }
 
 // CompleteErrf is used to write an error with complete into the trace with a formatted message.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) CompleteErrf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelError {
-		Up1.CompleteErrf(err, context, function, format, a...)
+	if l.logLevel() >= LevelError {
+		l.lines().CompleteErrf(err, context, function, format, a...)
 	}
 }
 
 // Err is used to write an error into the trace.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) Err(err error, context interface{}, function string) {
-	if l.level() >= LevelError {
-		Up1.Err(err, context, function)
+	if l.logLevel() >= LevelError {
+		l.lines().Err(err, context, function)
 	}
 }
 
 // Errf is used to write an error into the trace with a formatted message.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) Errf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelError {
-		Up1.Errf(err, context, function, format, a...)
+	if l.logLevel() >= LevelError {
+		l.errLines(Up1).Errf(err, context, function, format, a...)
 	}
 }
 
+// WrapErr is used to write an error into the trace and return it, so an
+// error can be logged and returned in one line. A nil error writes nothing
+// and returns nil.
+// Min logLevel required for logging: LevelError(1)
+func (l *Logger) WrapErr(err error, context interface{}, function string) error {
+	if err == nil || l.logLevel() < LevelError {
+		return err
+	}
+
+	return l.lines().WrapErr(err, context, function)
+}
+
+// WrapErrf is used to write an error into the trace with a formatted
+// message and return it annotated with the message, like
+// fmt.Errorf("message: %w", err). A nil error writes nothing and returns
+// nil.
+// Min logLevel required for logging: LevelError(1)
+func (l *Logger) WrapErrf(err error, context interface{}, function string, format string, a ...interface{}) error {
+	if err == nil || l.logLevel() < LevelError {
+		return wrapErr(err, format, a...)
+	}
+
+	return l.errLines(Up1).WrapErrf(err, context, function, format, a...)
+}
+
 // ErrFatal is used to write an error into the trace then terminate the program.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) ErrFatal(err error, context interface{}, function string) {
-	if l.level() >= LevelError {
-		Up1.ErrFatal(err, context, function)
+	if l.logLevel() >= LevelError {
+		l.lines().ErrFatal(err, context, function)
 	}
 }
 
 // ErrFatalf is used to write an error into the trace with a formatted message then terminate the program.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) ErrFatalf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelError {
-		Up1.ErrFatalf(err, context, function, format, a...)
+	if l.logLevel() >= LevelError {
+		l.lines().ErrFatalf(err, context, function, format, a...)
 	}
 }
 
 // ErrPanic is used to write an error into the trace then panic the program.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) ErrPanic(err error, context interface{}, function string) {
-	if l.level() >= LevelError {
-		Up1.ErrPanic(err, context, function)
+	if l.logLevel() >= LevelError {
+		l.lines().ErrPanic(err, context, function)
 	}
 }
 
 // ErrPanicf is used to write an error into the trace with a formatted message then panic the program.
 // Min logLevel required for logging: LevelError(1)
 func (l *Logger) ErrPanicf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelError {
-		Up1.ErrPanicf(err, context, function, format, a...)
+	if l.logLevel() >= LevelError {
+		l.lines().ErrPanicf(err, context, function, format, a...)
 	}
 }
 
 // Tracef is used to write information into the trace with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Tracef(context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelTrace {
-		Up1.Tracef(context, function, format, a...)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Tracef(context, function, format, a...)
+	}
+}
+
+// Trace0 is used to write information into the trace using the context
+// set by PushContext and the name of the calling function.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) Trace0(message string) {
+	if l.logLevel() >= LevelTrace {
+		l.lines().Trace0(message)
+	}
+}
+
+// TraceWithBlob is used to write information into the trace with a blob of
+// data stored in its own file in the directory set by SetBlobDir. The line
+// includes blob[ref] and the ref is returned.
+// Nothing is stored when the level is below LevelTrace.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) TraceWithBlob(context interface{}, function string, message string, blob []byte) string {
+	if l.logLevel() >= LevelTrace {
+		return l.lines().TraceWithBlob(context, function, message, blob)
+	}
+	return ""
+}
+
+// TraceObject is used to write the exported fields of a struct into the
+// trace as Field=value pairs on a single line. It is shallow, use
+// DataBlock for large or nested values.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) TraceObject(context interface{}, function string, obj interface{}) {
+	if l.logLevel() >= LevelTrace {
+		l.lines().TraceObject(context, function, obj)
+	}
+}
+
+// Section is used to write a separator line with a title into the trace.
+// Min logLevel required for logging: LevelTrace(4)
+func (l *Logger) Section(title string) {
+	if l.logLevel() >= LevelTrace {
+		l.lines().Section(title)
 	}
 }
 
 // Warnf is used to write a warning into the trace with a formatted message.
 // Min logLevel required for logging: LevelWarning(2)
 func (l *Logger) Warnf(context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelWarning {
-		Up1.Warnf(context, function, format, a...)
+	if l.logLevel() >= LevelWarning {
+		l.errLines(Up1).Warnf(context, function, format, a...)
+	}
+}
+
+// Info is used to write a message into the trace as a Trace line with no
+// context and the name of the calling function looked up, for code that
+// has no context to give. Unlike Tracef it is written at LevelOutput.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) Info(msg string) {
+	if l.logLevel() >= LevelOutput {
+		l.lines().Tracef(NoContext, "", "%s", msg)
+	}
+}
+
+// Warn is used to write a warning into the trace with no context and the
+// name of the calling function looked up.
+// Min logLevel required for logging: LevelWarning(2)
+func (l *Logger) Warn(msg string) {
+	if l.logLevel() >= LevelWarning {
+		l.errLines(Up1).Warnf(NoContext, "", "%s", msg)
+	}
+}
+
+// Error is used to write an error into the trace with no context and the
+// name of the calling function looked up. A nil error writes nothing.
+// Min logLevel required for logging: LevelError(1)
+func (l *Logger) Error(err error) {
+	if err != nil && l.logLevel() >= LevelError {
+		l.lines().Err(err, NoContext, "")
 	}
 }
 
 // Queryf is used to write a query into the trace with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (l *Logger) Queryf(context interface{}, function string, format string, a ...interface{}) {
-	if l.level() >= LevelTrace {
-		Up1.Queryf(context, function, format, a...)
+	if l.logLevel() >= LevelTrace {
+		l.lines().Queryf(context, function, format, a...)
 	}
 }
 
 // DataKV is used to write a key/value pair into the trace.
 // Min logLevel required for logging: LevelOutput(3)
 func (l *Logger) DataKV(context interface{}, function string, key string, value interface{}) {
-	if l.level() >= LevelOutput {
-		Up1.DataKV(context, function, key, value)
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataKV(context, function, key, value)
 	}
 }
 
+// DataKVQuoted is used to write a key/value pair into the trace with the
+// value quoted and escaped like logfmt when it contains whitespace or
+// special characters.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) DataKVQuoted(context interface{}, function string, key string, value interface{}) {
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataKVQuoted(context, function, key, value)
+	}
+}
+
+// DataList is used to write a list of values into the trace as
+// key: [a, b, c], formatted the same way as a SplunkValue.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) DataList(context interface{}, function string, key string, values ...interface{}) {
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataList(context, function, key, values...)
+	}
+}
+
+// DataGroup is used to collect key/value pairs that are written into the
+// trace as a single data block when Emit is called.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) DataGroup(context interface{}, function string) *Group {
+	g := l.lines().DataGroup(context, function)
+	g.logger = l
+	return g
+}
+
 // DataBlock is used to write a block of data into the trace.
 // Min logLevel required for logging: LevelOutput(3)
 func (l *Logger) DataBlock(context interface{}, function string, block interface{}) {
-	if l.level() >= LevelOutput {
-		Up1.DataBlock(context, function, block)
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataBlock(context, function, block)
 	}
 }
 
 // DataString is used to write a string with CRLF each on their own line.
 // Min logLevel required for logging: LevelOutput(3)
 func (l *Logger) DataString(context interface{}, function string, message string) {
-	if l.level() >= LevelOutput {
-		Up1.DataString(context, function, message)
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataString(context, function, message)
 	}
 }
 
 // DataTrace is used to write a block of data from an io.Stringer respecting each line.
 // Min logLevel required for logging: LevelOutput(3)
 func (l *Logger) DataTrace(context interface{}, function string, formatters ...Formatter) {
-	if l.level() >= LevelOutput {
-		Up1.DataTrace(context, function, formatters...)
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataTrace(context, function, formatters...)
+	}
+}
+
+// DataReader is used to write up to maxBytes read from r into the trace as
+// a block of data. Content that is not valid UTF-8 is written as a hex dump.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) DataReader(context interface{}, function string, r io.Reader, maxBytes int) {
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataReader(context, function, r, maxBytes)
+	}
+}
+
+// DataBinary is used to write a slice of bytes into the trace as a block
+// of data using the specified dump format.
+// Min logLevel required for logging: LevelOutput(3)
+func (l *Logger) DataBinary(context interface{}, function string, b []byte, format DumpFormat) {
+	if l.logLevel() >= LevelOutput {
+		l.lines().DataBinary(context, function, b, format)
+	}
+}
+
+// DataKVAt is used to write a key/value pair into the trace when the
+// logging level is at least the specified level.
+func (l *Logger) DataKVAt(level int, context interface{}, function string, key string, value interface{}) {
+	if l.logLevel() >= level {
+		l.lines().DataKV(context, function, key, value)
+	}
+}
+
+// DataBlockAt is used to write a block of data into the trace when the
+// logging level is at least the specified level.
+func (l *Logger) DataBlockAt(level int, context interface{}, function string, block interface{}) {
+	if l.logLevel() >= level {
+		l.lines().DataBlock(context, function, block)
+	}
+}
+
+// DataStringAt is used to write a string with CRLF each on their own line
+// when the logging level is at least the specified level.
+func (l *Logger) DataStringAt(level int, context interface{}, function string, message string) {
+	if l.logLevel() >= level {
+		l.lines().DataString(context, function, message)
+	}
+}
+
+// DataTraceAt is used to write a block of data from an io.Stringer respecting
+// each line when the logging level is at least the specified level.
+func (l *Logger) DataTraceAt(level int, context interface{}, function string, formatters ...Formatter) {
+	if l.logLevel() >= level {
+		l.lines().DataTrace(context, function, formatters...)
 	}
 }
@@ -16,6 +16,8 @@
 
 package log
 
+import "io"
+
 // UplevelLogger controls the stack frame level for file name, line number
 // and function name.  It can be used to embed logging calls in helper
 // functions that report the file name, line number and function name of
@@ -25,154 +27,358 @@ type UplevelLogger struct {
 	up Uplevel
 }
 
+// lines returns the trace line writer for the logger's calls.
+func (lvl UplevelLogger) lines() uplevel {
+	return uplevel{lvl.up, lvl.l.segment()}
+}
+
 // Start is used for the entry into a function.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Start(context interface{}, function string) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Start(context, function)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Start(context, function)
 	}
 }
 
 // Startf is used for the entry into a function with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Startf(context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Startf(context, function, format, a...)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Startf(context, function, format, a...)
+	}
+}
+
+// StartArgs is used for the entry into a function with its arguments
+// written as pairs in the var segment, in order.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) StartArgs(context interface{}, function string, args ...SplunkPair) {
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().StartArgs(context, function, args...)
 	}
 }
 
 // Complete is used for the exit of a function.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Complete(context interface{}, function string) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Complete(context, function)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Complete(context, function)
+	}
+}
+
+// TraceEnter is used for the entry into a function. It returns a function
+// for the exit that writes the values passed to it.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) TraceEnter(context interface{}, function string) func(...interface{}) {
+	if lvl.l.logLevel() >= LevelTrace {
+		return lvl.lines().TraceEnter(context, function)
 	}
+
+	return func(...interface{}) {}
 }
 
 // Completef is used for the exit of a function with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Completef(context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Completef(context, function, format, a...)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Completef(context, function, format, a...)
+	}
+}
+
+// CompleteReturns is used for the exit of a function with its return
+// values written as pairs in the var segment, in order.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) CompleteReturns(context interface{}, function string, returns ...SplunkPair) {
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().CompleteReturns(context, function, returns...)
 	}
 }
 
 // CompleteErr is used to write an error with complete into the trace.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) CompleteErr(err error, context interface{}, function string) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.CompleteErr(err, context, function)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().CompleteErr(err, context, function)
 	}
 }
 
 // CompleteErrf is used to write an error with complete into the trace with a formatted message.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) CompleteErrf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.CompleteErrf(err, context, function, format, a...)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().CompleteErrf(err, context, function, format, a...)
 	}
 }
 
 // Err is used to write an error into the trace.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) Err(err error, context interface{}, function string) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.Err(err, context, function)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().Err(err, context, function)
 	}
 }
 
 // Errf is used to write an error into the trace with a formatted message.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) Errf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.Errf(err, context, function, format, a...)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.l.errLines(lvl.up).Errf(err, context, function, format, a...)
 	}
 }
 
+// WrapErr is used to write an error into the trace and return it, so an
+// error can be logged and returned in one line. A nil error writes nothing
+// and returns nil.
+// Min logLevel required for logging: LevelError(1)
+func (lvl UplevelLogger) WrapErr(err error, context interface{}, function string) error {
+	if err == nil || lvl.l.logLevel() < LevelError {
+		return err
+	}
+
+	return lvl.lines().WrapErr(err, context, function)
+}
+
+// WrapErrf is used to write an error into the trace with a formatted
+// message and return it annotated with the message, like
+// fmt.Errorf("message: %w", err). A nil error writes nothing and returns
+// nil.
+// Min logLevel required for logging: LevelError(1)
+func (lvl UplevelLogger) WrapErrf(err error, context interface{}, function string, format string, a ...interface{}) error {
+	if err == nil || lvl.l.logLevel() < LevelError {
+		return wrapErr(err, format, a...)
+	}
+
+	return lvl.l.errLines(lvl.up).WrapErrf(err, context, function, format, a...)
+}
+
 // ErrFatal is used to write an error into the trace then terminate the program.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) ErrFatal(err error, context interface{}, function string) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.ErrFatal(err, context, function)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().ErrFatal(err, context, function)
 	}
 }
 
 // ErrFatalf is used to write an error into the trace with a formatted message then terminate the program.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) ErrFatalf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.ErrFatalf(err, context, function, format, a...)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().ErrFatalf(err, context, function, format, a...)
 	}
 }
 
 // ErrPanic is used to write an error into the trace then panic the program.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) ErrPanic(err error, context interface{}, function string) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.ErrPanic(err, context, function)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().ErrPanic(err, context, function)
 	}
 }
 
 // ErrPanicf is used to write an error into the trace with a formatted message then panic the program.
 // Min logLevel required for logging: LevelError(1)
 func (lvl UplevelLogger) ErrPanicf(err error, context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelError {
-		lvl.up.ErrPanicf(err, context, function, format, a...)
+	if lvl.l.logLevel() >= LevelError {
+		lvl.lines().ErrPanicf(err, context, function, format, a...)
 	}
 }
 
 // Tracef is used to write information into the trace with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Tracef(context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Tracef(context, function, format, a...)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Tracef(context, function, format, a...)
+	}
+}
+
+// Trace0 is used to write information into the trace using the context
+// set by PushContext and the name of the calling function.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) Trace0(message string) {
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Trace0(message)
+	}
+}
+
+// TraceWithBlob is used to write information into the trace with a blob of
+// data stored in its own file in the directory set by SetBlobDir. The line
+// includes blob[ref] and the ref is returned.
+// Nothing is stored when the level is below LevelTrace.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) TraceWithBlob(context interface{}, function string, message string, blob []byte) string {
+	if lvl.l.logLevel() >= LevelTrace {
+		return lvl.lines().TraceWithBlob(context, function, message, blob)
+	}
+	return ""
+}
+
+// TraceObject is used to write the exported fields of a struct into the
+// trace as Field=value pairs on a single line. It is shallow, use
+// DataBlock for large or nested values.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) TraceObject(context interface{}, function string, obj interface{}) {
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().TraceObject(context, function, obj)
+	}
+}
+
+// Section is used to write a separator line with a title into the trace.
+// Min logLevel required for logging: LevelTrace(4)
+func (lvl UplevelLogger) Section(title string) {
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Section(title)
 	}
 }
 
 // Warnf is used to write a warning into the trace with a formatted message.
 // Min logLevel required for logging: LevelWarning(2)
 func (lvl UplevelLogger) Warnf(context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelWarning {
-		lvl.up.Warnf(context, function, format, a...)
+	if lvl.l.logLevel() >= LevelWarning {
+		lvl.l.errLines(lvl.up).Warnf(context, function, format, a...)
+	}
+}
+
+// Info is used to write a message into the trace as a Trace line with no
+// context and the name of the calling function looked up, for code that
+// has no context to give. Unlike Tracef it is written at LevelOutput.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) Info(msg string) {
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().Tracef(NoContext, "", "%s", msg)
+	}
+}
+
+// Warn is used to write a warning into the trace with no context and the
+// name of the calling function looked up.
+// Min logLevel required for logging: LevelWarning(2)
+func (lvl UplevelLogger) Warn(msg string) {
+	if lvl.l.logLevel() >= LevelWarning {
+		lvl.l.errLines(lvl.up).Warnf(NoContext, "", "%s", msg)
+	}
+}
+
+// Error is used to write an error into the trace with no context and the
+// name of the calling function looked up. A nil error writes nothing.
+// Min logLevel required for logging: LevelError(1)
+func (lvl UplevelLogger) Error(err error) {
+	if err != nil && lvl.l.logLevel() >= LevelError {
+		lvl.lines().Err(err, NoContext, "")
 	}
 }
 
 // Queryf is used to write a query into the trace with a formatted message.
 // Min logLevel required for logging: LevelTrace(4)
 func (lvl UplevelLogger) Queryf(context interface{}, function string, format string, a ...interface{}) {
-	if lvl.l.level() >= LevelTrace {
-		lvl.up.Queryf(context, function, format, a...)
+	if lvl.l.logLevel() >= LevelTrace {
+		lvl.lines().Queryf(context, function, format, a...)
 	}
 }
 
 // DataKV is used to write a key/value pair into the trace.
 // Min logLevel required for logging: LevelOutput(3)
 func (lvl UplevelLogger) DataKV(context interface{}, function string, key string, value interface{}) {
-	if lvl.l.level() >= LevelOutput {
-		lvl.up.DataKV(context, function, key, value)
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataKV(context, function, key, value)
 	}
 }
 
+// DataKVQuoted is used to write a key/value pair into the trace with the
+// value quoted and escaped like logfmt when it contains whitespace or
+// special characters.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) DataKVQuoted(context interface{}, function string, key string, value interface{}) {
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataKVQuoted(context, function, key, value)
+	}
+}
+
+// DataList is used to write a list of values into the trace as
+// key: [a, b, c], formatted the same way as a SplunkValue.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) DataList(context interface{}, function string, key string, values ...interface{}) {
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataList(context, function, key, values...)
+	}
+}
+
+// DataGroup is used to collect key/value pairs that are written into the
+// trace as a single data block when Emit is called.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) DataGroup(context interface{}, function string) *Group {
+	g := lvl.lines().DataGroup(context, function)
+	g.logger = lvl.l
+	return g
+}
+
 // DataBlock is used to write a block of data into the trace.
 // Min logLevel required for logging: LevelOutput(3)
 func (lvl UplevelLogger) DataBlock(context interface{}, function string, block interface{}) {
-	if lvl.l.level() >= LevelOutput {
-		lvl.up.DataBlock(context, function, block)
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataBlock(context, function, block)
 	}
 }
 
 // DataString is used to write a string with CRLF each on their own line.
 // Min logLevel required for logging: LevelOutput(3)
 func (lvl UplevelLogger) DataString(context interface{}, function string, message string) {
-	if lvl.l.level() >= LevelOutput {
-		lvl.up.DataString(context, function, message)
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataString(context, function, message)
 	}
 }
 
 // DataTrace is used to write a block of data from an io.Stringer respecting each line.
 // Min logLevel required for logging: LevelOutput(3)
 func (lvl UplevelLogger) DataTrace(context interface{}, function string, formatters ...Formatter) {
-	if lvl.l.level() >= LevelOutput {
-		lvl.up.DataTrace(context, function, formatters...)
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataTrace(context, function, formatters...)
+	}
+}
+
+// DataReader is used to write up to maxBytes read from r into the trace as
+// a block of data. Content that is not valid UTF-8 is written as a hex dump.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) DataReader(context interface{}, function string, r io.Reader, maxBytes int) {
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataReader(context, function, r, maxBytes)
+	}
+}
+
+// DataBinary is used to write a slice of bytes into the trace as a block
+// of data using the specified dump format.
+// Min logLevel required for logging: LevelOutput(3)
+func (lvl UplevelLogger) DataBinary(context interface{}, function string, b []byte, format DumpFormat) {
+	if lvl.l.logLevel() >= LevelOutput {
+		lvl.lines().DataBinary(context, function, b, format)
+	}
+}
+
+// DataKVAt is used to write a key/value pair into the trace when the
+// logging level is at least the specified level.
+func (lvl UplevelLogger) DataKVAt(level int, context interface{}, function string, key string, value interface{}) {
+	if lvl.l.logLevel() >= level {
+		lvl.lines().DataKV(context, function, key, value)
+	}
+}
+
+// DataBlockAt is used to write a block of data into the trace when the
+// logging level is at least the specified level.
+func (lvl UplevelLogger) DataBlockAt(level int, context interface{}, function string, block interface{}) {
+	if lvl.l.logLevel() >= level {
+		lvl.lines().DataBlock(context, function, block)
+	}
+}
+
+// DataStringAt is used to write a string with CRLF each on their own line
+// when the logging level is at least the specified level.
+func (lvl UplevelLogger) DataStringAt(level int, context interface{}, function string, message string) {
+	if lvl.l.logLevel() >= level {
+		lvl.lines().DataString(context, function, message)
+	}
+}
+
+// DataTraceAt is used to write a block of data from an io.Stringer respecting
+// each line when the logging level is at least the specified level.
+func (lvl UplevelLogger) DataTraceAt(level int, context interface{}, function string, formatters ...Formatter) {
+	if lvl.l.logLevel() >= level {
+		lvl.lines().DataTrace(context, function, formatters...)
 	}
 }
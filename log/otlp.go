@@ -0,0 +1,142 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// otlpSeverity is the OpenTelemetry severity number of a tag.
+type otlpSeverity int
+
+// The severity numbers of OpenTelemetry the tags are mapped to.
+const (
+	otlpTrace otlpSeverity = 1
+	otlpDebug otlpSeverity = 5
+	otlpInfo  otlpSeverity = 9
+	otlpWarn  otlpSeverity = 13
+	otlpError otlpSeverity = 17
+	otlpFatal otlpSeverity = 21
+)
+
+// otlpSeverities maps the tag of a trace line, verbose or compact, to its
+// severity number.
+var otlpSeverities = map[string]otlpSeverity{
+	"TERMINATING":     otlpFatal,
+	"ERROR":           otlpError,
+	"E":               otlpError,
+	"Completed ERROR": otlpError,
+	"X":               otlpError,
+	"LOG ERROR":       otlpError,
+	"Warning":         otlpWarn,
+	"W":               otlpWarn,
+	"LOG WARNING":     otlpWarn,
+	"Started":         otlpInfo,
+	"S":               otlpInfo,
+	"Completed":       otlpInfo,
+	"C":               otlpInfo,
+	"Query":           otlpDebug,
+	"Q":               otlpDebug,
+	"DATA":            otlpDebug,
+	"D":               otlpDebug,
+	"Trace":           otlpTrace,
+	"T":               otlpTrace,
+}
+
+// OTLPEncoder writes each trace line as an OpenTelemetry log record in
+// the JSON encoding of OTLP:
+//
+//	{"timeUnixNano":"1257865200000000000","severityNumber":17,"severityText":"ERROR","body":{"stringValue":"failed"},"attributes":[{"key":"context","value":{"stringValue":"TEST"}},{"key":"func","value":{"stringValue":"foo"}},{"key":"file","value":{"stringValue":"file.go"}},{"key":"line","value":{"intValue":"512"}}]}
+//
+// The severity text is the tag. ERROR is mapped to 17, Warning to 13,
+// Started and Completed to 9, Query and DATA to 5, Trace to 1 and
+// TERMINATING to 21, other tags to 0. The fields of the var segment follow
+// the context, function, file and line in the attributes, and the lines of
+// the block of a DATA line are joined with newlines into a data attribute.
+// The context is left out for NoContext. The app and pid are left out,
+// they describe the resource the collector gets the records from.
+type OTLPEncoder struct{}
+
+// Encode implements the Encoder interface.
+func (OTLPEncoder) Encode(f LineFields) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, `{"timeUnixNano":"%d","severityNumber":%d,"severityText":`, f.Stamp.UnixNano(), otlpSeverities[f.Tag])
+	writeJSON(&buf, f.Tag)
+	buf.WriteString(`,"body":{"stringValue":`)
+	writeJSON(&buf, f.Message)
+	buf.WriteString(`},"attributes":[`)
+
+	if f.Context != NoContext {
+		writeOTLPAttribute(&buf, "context", fmt.Sprintf("%v", f.Context))
+		buf.WriteByte(',')
+	}
+	writeOTLPAttribute(&buf, "func", f.Func)
+	buf.WriteByte(',')
+	writeOTLPAttribute(&buf, "file", f.File)
+	buf.WriteByte(',')
+	writeOTLPAttribute(&buf, "line", f.Line)
+	for _, kv := range f.Fields {
+		buf.WriteByte(',')
+		writeOTLPAttribute(&buf, kv.Key, kv.Value)
+	}
+	if f.Data != nil {
+		buf.WriteByte(',')
+		writeOTLPAttribute(&buf, "data", strings.Join(f.Data, "\n"))
+	}
+	buf.WriteString(`]}`)
+
+	return buf.Bytes()
+}
+
+// writeOTLPAttribute writes a key and value of the attributes of a log
+// record. Integers, floats and booleans keep their type, other values are
+// written as strings.
+func writeOTLPAttribute(buf *bytes.Buffer, key string, v interface{}) {
+	buf.WriteString(`{"key":`)
+	writeJSON(buf, key)
+	buf.WriteString(`,"value":{`)
+
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	if cv, ok := customValue(v); ok {
+		v = cv
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// OTLP writes 64 bit integers as strings in JSON.
+		buf.WriteString(`"intValue":"` + strconv.FormatInt(rv.Int(), 10) + `"`)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteString(`"intValue":"` + strconv.FormatUint(rv.Uint(), 10) + `"`)
+	case reflect.Float32, reflect.Float64:
+		buf.WriteString(`"doubleValue":` + strconv.FormatFloat(rv.Float(), 'g', -1, 64))
+	case reflect.Bool:
+		buf.WriteString(`"boolValue":` + strconv.FormatBool(rv.Bool()))
+	default:
+		buf.WriteString(`"stringValue":`)
+		writeJSON(buf, fmt.Sprintf("%v", v))
+	}
+
+	buf.WriteString(`}}`)
+}
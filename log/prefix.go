@@ -0,0 +1,76 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter writes a prefix at the start of every line.
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix []byte
+	mid    bool
+	buf    bytes.Buffer
+}
+
+// NewPrefixWriter returns a writer that writes prefix at the start of every
+// line before passing it on to w. A write with many lines, like a bulk
+// write or a DATA block, gets the prefix on each of them and a line split
+// across writes gets it once. It gives a quick grep key when several
+// loggers share one file.
+//
+//	log.Dev.All(log.NewPrefixWriter(f, "shard-3 "))
+//	shard-3 2009/11/10 15:00:00.000000000: LOG[69910]: ...
+func NewPrefixWriter(w io.Writer, prefix string) io.Writer {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write implements the io.Writer interface.
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	pw.buf.Reset()
+
+	rest := p
+	for len(rest) > 0 {
+		if !pw.mid {
+			pw.buf.Write(pw.prefix)
+		}
+
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			pw.buf.Write(rest)
+			pw.mid = true
+			break
+		}
+
+		pw.buf.Write(rest[:i+1])
+		pw.mid = false
+		rest = rest[i+1:]
+	}
+
+	if _, err := pw.w.Write(pw.buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// Record is a trace line captured by a Recorder and split into its fields.
+type Record struct {
+	Tag     string
+	Context string
+	Func    string
+	Message string
+	Line    string
+}
+
+// Recorder is a device writer that keeps the lines written to it so tests
+// can check what was logged without comparing whole strings.
+//
+//	rec := log.NewRecorder()
+//	log.Dev.All(rec)
+//	...
+//	for _, e := range rec.Entries() {
+//		if e.Tag == "ERROR" && e.Context == "1234" {
+//			...
+//		}
+//	}
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Write implements the io.Writer interface.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ln := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(ln) == 0 {
+			continue
+		}
+		s := strings.TrimSuffix(string(ln), "\n")
+
+		// The indented lines of a DATA block belong to the line before.
+		if s != "" && s[0] == '\t' && len(r.records) > 0 {
+			rec := &r.records[len(r.records)-1]
+			if rec.Message != "" {
+				rec.Message += "\n"
+			}
+			rec.Message += s[1:]
+			rec.Line += "\n" + s
+			continue
+		}
+
+		r.records = append(r.records, parseRecord(s))
+	}
+
+	return len(p), nil
+}
+
+// Entries returns a copy of the records captured so far.
+func (r *Recorder) Entries() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]Record, len(r.records))
+	copy(records, r.records)
+
+	return records
+}
+
+// Reset discards the records captured so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.records = nil
+	r.mu.Unlock()
+}
+
+// parseRecord splits a trace line into its fields. Lines that don't have
+// the trace line layout, like Splunk lines, only have the Message and Line
+// fields set.
+func parseRecord(s string) Record {
+	rec := Record{Line: s}
+
+	fields := strings.SplitN(s, ": ", 6)
+	if len(fields) < 6 {
+		if i := strings.Index(s, ": "); i >= 0 {
+			rec.Message = s[i+2:]
+		}
+		return rec
+	}
+
+	rec.Context = fields[3]
+	rec.Func = fields[4]
+
+	rest := fields[5]
+	if i := strings.Index(rest, ":"); i >= 0 {
+		rec.Tag = rest[:i]
+		rec.Message = strings.TrimPrefix(rest[i+1:], " ")
+	} else {
+		rec.Tag = rest
+	}
+
+	return rec
+}
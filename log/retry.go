@@ -0,0 +1,57 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy sets how a network writer tries again when it can't reach
+// the other end. The wait after a failed attempt starts at InitialBackoff
+// and doubles with each failure in a row, up to MaxBackoff. A random part
+// of up to half the wait is taken off, so writers that lost the same
+// receiver don't all come back at once. After MaxAttempts failures in a
+// row the writer gives up. A MaxAttempts of 0 or less never gives up.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// wait returns how long to wait after the failed attempt n, counting
+// from 1.
+func (p RetryPolicy) wait(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// exhausted reports whether n failed attempts in a row are enough to give
+// up.
+func (p RetryPolicy) exhausted(n int) bool {
+	return p.MaxAttempts > 0 && n >= p.MaxAttempts
+}
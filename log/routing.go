@@ -0,0 +1,105 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Route sends the lines of a device to a destination. Tag is the name of
+// the device as counted by TagCounts, like "error" or "trace", or "all"
+// for every device, in any case. Dest is "stderr", "stdout" or the path
+// of a file that is appended to.
+type Route struct {
+	Tag  string `json:"tag" yaml:"tag"`
+	Dest string `json:"dest" yaml:"dest"`
+}
+
+// routeSetters maps the tag of a route to the Dev method that sets the
+// device.
+var routeSetters = map[string]func(io.Writer){
+	"all":     Dev.All,
+	"start":   Dev.Start,
+	"error":   Dev.Error,
+	"panic":   Dev.Panic,
+	"trace":   Dev.Trace,
+	"warning": Dev.Warning,
+	"query":   Dev.Query,
+	"data":    Dev.Data,
+	"splunk":  Dev.Splunk,
+	"event":   Dev.Event,
+	"audit":   Dev.Audit,
+}
+
+// ApplyRouting sets the devices from a list of routes, so the routing can
+// be described in a config file. The routes are applied in order, so an
+// "all" route can be followed by the devices that go somewhere else.
+// Routes to the same path share one file, which stays open for the life
+// of the program. Every route is checked and every file opened before
+// any device is changed, so on error nothing is changed.
+//
+//	err := log.ApplyRouting([]log.Route{
+//		{Tag: "all", Dest: "stdout"},
+//		{Tag: "error", Dest: "stderr"},
+//		{Tag: "audit", Dest: "/var/log/app/audit.log"},
+//	})
+func ApplyRouting(routes []Route) error {
+	files := make(map[string]*os.File)
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	dests := make([]io.Writer, len(routes))
+	for i, r := range routes {
+		if _, ok := routeSetters[strings.ToLower(r.Tag)]; !ok {
+			closeFiles()
+			return fmt.Errorf("ApplyRouting: unknown tag %q", r.Tag)
+		}
+
+		switch r.Dest {
+		case "stderr":
+			dests[i] = os.Stderr
+		case "stdout":
+			dests[i] = os.Stdout
+		case "":
+			closeFiles()
+			return fmt.Errorf("ApplyRouting: no destination for tag %q", r.Tag)
+		default:
+			f, ok := files[r.Dest]
+			if !ok {
+				var err error
+				if f, err = os.OpenFile(r.Dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+					closeFiles()
+					return fmt.Errorf("ApplyRouting: %s: %w", r.Tag, err)
+				}
+				files[r.Dest] = f
+			}
+			dests[i] = f
+		}
+	}
+
+	for i, r := range routes {
+		routeSetters[strings.ToLower(r.Tag)](dests[i])
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// sampleLevels maps each device to the level used to pick its sampling
+// rate. It matches the level the Logger methods require for the device.
+var sampleLevels = [...]int{
+	DevStart:   LevelTrace,
+	DevError:   LevelError,
+	DevPanic:   LevelError,
+	DevTrace:   LevelTrace,
+	DevWarning: LevelWarning,
+	DevQuery:   LevelTrace,
+	DevData:    LevelOutput,
+}
+
+// sampleRates holds the keep thresholds for each level. A line is kept
+// when a random number is below the threshold of its level.
+type sampleRates [LevelTrace + 1]uint64
+
+var (
+	sampling        atomic.Value // *sampleRates
+	contextSampling atomic.Value // *uint64
+	sampleSeed      uint64
+	sampledOut      uint64
+)
+
+// SetSeveritySampling sets the probability, from 0 to 1, that a line of
+// each level is kept. Levels that are not in the map keep all of their
+// lines, so errors are never lost unless asked for.
+//
+//	log.SetSeveritySampling(map[int]float64{
+//		log.LevelTrace:  0.01,
+//		log.LevelOutput: 0.1,
+//	})
+//
+// Start, Trace and Query lines use LevelTrace, Data lines LevelOutput,
+// Warning lines LevelWarning and Error and Panic lines LevelError. Splunk,
+// Event and Audit records are not sampled. Passing nil turns sampling off.
+// The lines dropped are counted by SampledOut.
+func SetSeveritySampling(rates map[int]float64) {
+	if len(rates) == 0 {
+		sampling.Store((*sampleRates)(nil))
+		return
+	}
+
+	var r sampleRates
+	for i := range r {
+		r[i] = math.MaxUint64
+	}
+	for level, p := range rates {
+		if level <= LevelOff || level > LevelTrace {
+			continue
+		}
+		switch {
+		case p <= 0:
+			r[level] = 0
+		case p < 1:
+			r[level] = uint64(p * (1 << 64))
+		}
+	}
+
+	sampling.Store(&r)
+}
+
+// SetContextSampling sets the share, from 0 to 1, of the contexts whose
+// lines are kept. The context of each line is hashed, so all of the lines
+// of a kept context are written and none of the lines of the others,
+// which gives whole traces for a sample of the requests. Lines with
+// NoContext are always kept. It uses the same devices as
+// SetSeveritySampling, and both apply when both are set. Passing 1 or
+// more turns it off. The lines dropped are counted by SampledOut.
+//
+//	log.SetContextSampling(0.05)
+func SetContextSampling(fraction float64) {
+	if fraction >= 1 {
+		contextSampling.Store((*uint64)(nil))
+		return
+	}
+
+	var threshold uint64
+	if fraction > 0 {
+		threshold = uint64(fraction * (1 << 64))
+	}
+
+	contextSampling.Store(&threshold)
+}
+
+// SampledOut returns the number of lines dropped by SetSeveritySampling
+// and SetContextSampling since the program started.
+func SampledOut() uint64 {
+	return atomic.LoadUint64(&sampledOut)
+}
+
+// sampled reports whether a line for the device should be dropped.
+func sampled(dev int8) bool {
+	r, _ := sampling.Load().(*sampleRates)
+	if r == nil || int(dev) >= len(sampleLevels) {
+		return false
+	}
+
+	threshold := r[sampleLevels[dev]]
+	if threshold == math.MaxUint64 {
+		return false
+	}
+
+	if threshold == 0 || sampleRand() >= threshold {
+		atomic.AddUint64(&sampledOut, 1)
+		return true
+	}
+
+	return false
+}
+
+// contextSampled reports whether a line for the device should be dropped
+// because of its context.
+func contextSampled(dev int8, context interface{}) bool {
+	threshold, _ := contextSampling.Load().(*uint64)
+	if threshold == nil || int(dev) >= len(sampleLevels) || context == NoContext {
+		return false
+	}
+
+	if contextHash(context) >= *threshold {
+		atomic.AddUint64(&sampledOut, 1)
+		return true
+	}
+
+	return false
+}
+
+// contextHash returns the hash of a context used by SetContextSampling.
+// Contexts that are written the same hash the same.
+func contextHash(context interface{}) uint64 {
+	h := fnv.New64a()
+	if s, ok := context.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, context)
+	}
+
+	// FNV leaves short keys clustered in the high bits the threshold is
+	// compared against, so they are mixed first.
+	return mix64(h.Sum64())
+}
+
+// sampleRand returns a pseudo random number. It is a splitmix64 step over
+// a shared counter, which is cheap and needs no lock.
+func sampleRand() uint64 {
+	return mix64(atomic.AddUint64(&sampleSeed, 0x9e3779b97f4a7c15))
+}
+
+// mix64 is the splitmix64 finalizer, which spreads the bits of z.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
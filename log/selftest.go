@@ -0,0 +1,100 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// writeErrs holds the last error returned by each device writer.
+var writeErrs = struct {
+	sync.Mutex
+	m map[io.Writer]error
+}{m: make(map[io.Writer]error)}
+
+// writeFailed records an error returned by a device writer.
+func writeFailed(w io.Writer, err error) {
+	writeErrs.Lock()
+	writeErrs.m[w] = err
+	writeErrs.Unlock()
+}
+
+// SelfTest writes a probe line to every configured device, waits for the
+// lines to be flushed and reports the devices whose writer returned an
+// error. Devices sharing a writer get a single probe line. It is meant to
+// be called once at startup to fail fast when, say, the log file can't be
+// written.
+//
+//	if err := log.SelfTest(); err != nil {
+//		fmt.Fprintln(os.Stderr, err)
+//		os.Exit(1)
+//	}
+func SelfTest() error {
+	l.mu.Lock()
+	running := !l.shutdown && l.write != nil
+	l.mu.Unlock()
+	if !running {
+		return fmt.Errorf("SelfTest: logging is not initialized")
+	}
+
+	// Group the devices by writer so each writer is probed once.
+	var writers []io.Writer
+	devs := make(map[io.Writer][]string)
+	for d, name := range tagNames {
+		if d == int(DevAll) {
+			continue
+		}
+		w := Dev.get(int8(d))
+		if w == nil {
+			continue
+		}
+		if _, ok := devs[w]; !ok {
+			writers = append(writers, w)
+		}
+		devs[w] = append(devs[w], name)
+	}
+
+	writeErrs.Lock()
+	for _, w := range writers {
+		delete(writeErrs.m, w)
+	}
+	writeErrs.Unlock()
+
+	dt, _, _, pid := dtFile(1, "SelfTest")
+	for _, w := range writers {
+		output(w, "%s: %s[%d]: SELFTEST: %s", dt, appPrefix(), pid, strings.Join(devs[w], ","))
+	}
+	Flush()
+
+	var failed []string
+	writeErrs.Lock()
+	for _, w := range writers {
+		if err := writeErrs.m[w]; err != nil {
+			failed = append(failed, fmt.Sprintf("%s[%s]", strings.Join(devs[w], ","), err))
+		}
+	}
+	writeErrs.Unlock()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("SelfTest: unhealthy devices: %s", strings.Join(failed, ": "))
+	}
+
+	return nil
+}
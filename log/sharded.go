@@ -0,0 +1,184 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// shardedMaxOpen is the number of files a Sharded writer keeps open.
+const shardedMaxOpen = 64
+
+// shardedDefaultKey is used for lines that produce an empty key.
+const shardedDefaultKey = "_"
+
+// shard is an open file in the LRU.
+type shard struct {
+	key string
+	f   *os.File
+}
+
+// Sharded is a device writer that routes each line to a file named
+// after a key extracted from the line.
+type Sharded struct {
+	mu      sync.Mutex
+	dir     string
+	keyFrom func(line []byte) string
+	lru     *list.List
+	open    map[string]*list.Element
+}
+
+// ShardedWriter returns a device writer that writes each line to
+// dir/<key>.log where the key is returned by keyFrom. Files are opened
+// when first needed and the least recently used file is closed once too
+// many are open. Indented DATA lines and repeat counts are written to the
+// same file as the line before them. ContextKey can be used as keyFrom to
+// shard by the context of each trace line.
+func ShardedWriter(dir string, keyFrom func(line []byte) string) *Sharded {
+	return &Sharded{
+		dir:     dir,
+		keyFrom: keyFrom,
+		lru:     list.New(),
+		open:    make(map[string]*list.Element),
+	}
+}
+
+// ContextKey returns the context field of a trace line.
+func ContextKey(line []byte) string {
+	fields := bytes.SplitN(line, []byte(": "), 5)
+	if len(fields) < 5 {
+		return ""
+	}
+
+	return string(fields[3])
+}
+
+// Write implements the io.Writer interface.
+func (s *Sharded) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var key string
+	for rest := p; len(rest) > 0; {
+		ln := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			ln = rest[:i+1]
+		}
+		rest = rest[len(ln):]
+
+		// Continuation lines belong with the line before them.
+		if key == "" || !(ln[0] == '\t' || bytes.HasPrefix(ln, []byte("... "))) {
+			key = shardKey(s.keyFrom(ln))
+		}
+
+		f, err := s.file(key)
+		if err != nil {
+			return len(p) - len(rest) - len(ln), err
+		}
+		if _, err := f.Write(ln); err != nil {
+			return len(p) - len(rest) - len(ln), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes all open files.
+func (s *Sharded) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for s.lru.Len() > 0 {
+		if cerr := s.evict(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// file returns the open file for the key, opening it if required.
+func (s *Sharded) file(key string) (*os.File, error) {
+	if e, ok := s.open[key]; ok {
+		s.lru.MoveToFront(e)
+		return e.Value.(*shard).f, nil
+	}
+
+	if s.lru.Len() >= shardedMaxOpen {
+		s.evict()
+	}
+
+	name := filepath.Join(s.dir, key+".log")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	// The process is out of file handles. Give some back and try again.
+	for err != nil && s.lru.Len() > 0 && exhausted(err) {
+		s.evict()
+		f, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.open[key] = s.lru.PushFront(&shard{key: key, f: f})
+	return f, nil
+}
+
+// evict closes the least recently used file.
+func (s *Sharded) evict() error {
+	e := s.lru.Back()
+	if e == nil {
+		return nil
+	}
+
+	sh := s.lru.Remove(e).(*shard)
+	delete(s.open, sh.key)
+	return sh.f.Close()
+}
+
+// exhausted reports whether the error is caused by running out of
+// file handles.
+func exhausted(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+
+	return err == syscall.EMFILE || err == syscall.ENFILE
+}
+
+// shardKey makes the key safe to use as a file name.
+func shardKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, key)
+
+	if key == "" || key == "." || key == ".." {
+		return shardedDefaultKey
+	}
+
+	return key
+}
@@ -0,0 +1,108 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"sync/atomic"
+	"text/template"
+)
+
+// DefaultTemplate is a template that writes the trace lines the same way
+// they are written when no template is set, apart from the TERMINATING
+// line that gets a colon after its tag.
+const DefaultTemplate = "{{.Time}}: {{.App}}[{{.PID}}]: {{.File}}: {{.Context}}: {{.Func}}: {{.Tag}}:{{with .Message}} {{.}}{{end}}"
+
+// TemplateData holds the parts of a trace line that a template set with
+// SetTemplate can use.
+type TemplateData struct {
+	Time    string
+	App     string
+	PID     int
+	File    string
+	Context interface{}
+	Func    string
+	Tag     string // Started, Completed, ERROR, Trace, DATA and so on.
+	Message string // The var segment and the message.
+}
+
+// traceTemplate holds the template set with SetTemplate.
+var traceTemplate atomic.Value // *template.Template
+
+// SetTemplate sets a text/template used to write the trace lines in place
+// of the built in layout, so the order and the set of the parts can be
+// changed. The template is executed with a TemplateData and is parsed once
+// here, an error is returned if it can't be. An empty string goes back to
+// the built in layout.
+//
+//	log.SetTemplate("{{.Time}} {{.App}} {{.Context}} {{.Tag}} {{.Message}}")
+//
+// The block of a DATA line is written on the lines after the template.
+// Splunk, Event and Audit records are not changed. A line the template
+// fails to execute for is written with the built in layout instead.
+func SetTemplate(tmpl string) error {
+	if tmpl == "" {
+		traceTemplate.Store((*template.Template)(nil))
+		return nil
+	}
+
+	t, err := template.New("trace").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+	traceTemplate.Store(t)
+
+	return nil
+}
+
+// lineTemplate returns the template set with SetTemplate or nil.
+func lineTemplate() *template.Template {
+	t, _ := traceTemplate.Load().(*template.Template)
+	return t
+}
+
+// renderLine executes the template set with SetTemplate for a trace line,
+// followed by the block of a DATA line. It returns false when there is no
+// template or it fails to execute.
+func renderLine(f LineFields) (string, bool) {
+	t := lineTemplate()
+	if t == nil {
+		return "", false
+	}
+
+	data := TemplateData{
+		Time:    f.Time,
+		App:     f.App,
+		PID:     f.PID,
+		File:    lineFile(f),
+		Context: f.Context,
+		Func:    f.Func,
+		Tag:     f.Tag,
+		Message: joinVars(formatVars(f.Fields), f.Message),
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", false
+	}
+
+	if f.Data != nil {
+		writeDataLines(&buf, dataBytes(f.Data))
+	}
+
+	return buf.String(), true
+}
@@ -0,0 +1,263 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Settings of the Unix socket writer. The first three are its default
+// RetryPolicy. They are variables so the tests can shorten them.
+var (
+	unixSocketBackoff      = 100 * time.Millisecond
+	unixSocketMaxBackoff   = 10 * time.Second
+	unixSocketRetries      = 10
+	unixSocketMaxBuffer    = 1 << 20
+	unixSocketDialTimeout  = time.Second
+	unixSocketWriteTimeout = time.Second
+)
+
+// UnixSocket is a device writer that sends the lines to a Unix domain
+// socket, like the one of a local log router.
+type UnixSocket struct {
+	mu       sync.Mutex
+	path     string
+	conn     net.Conn
+	fallback io.Writer
+	buf      []byte
+	dropped  int
+	lost     int
+	failures int
+	retry    RetryPolicy
+	retryAt  time.Time
+	failed   bool
+}
+
+// UnixSocketWriter returns a device writer that sends the lines to the
+// stream socket at path. It connects on the first write. When the socket
+// can't be reached the lines are kept, up to 1MB with the oldest lines
+// dropped first, and a connection is tried again on the writes that come
+// after the wait of its RetryPolicy. By default that starts at 100ms and
+// doubles up to 10s, and it gives up after 10 failed attempts in a row. A
+// line saying how many lines were dropped is sent once it connects again.
+// A send that doesn't complete within a second is abandoned like a lost
+// connection, and the rest of a line that was only partly sent is dropped.
+// Once the socket is given up on, the kept lines and every line after them
+// are written to the writer set with SetFallback, or dropped with an error
+// if there is none. It keeps trying to connect every MaxBackoff while it
+// writes to the fallback and goes back to the socket once it can.
+func UnixSocketWriter(path string) *UnixSocket {
+	return &UnixSocket{
+		path: path,
+		retry: RetryPolicy{
+			MaxAttempts:    unixSocketRetries,
+			InitialBackoff: unixSocketBackoff,
+			MaxBackoff:     unixSocketMaxBackoff,
+		},
+	}
+}
+
+// SetRetryPolicy sets how the socket is tried again when it can't be
+// reached. It applies from the next failed attempt.
+func (s *UnixSocket) SetRetryPolicy(p RetryPolicy) {
+	s.mu.Lock()
+	s.retry = p
+	s.mu.Unlock()
+}
+
+// Dropped returns the number of lines dropped since the writer was made.
+func (s *UnixSocket) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lost
+}
+
+// SetFallback sets the writer used once the socket has been given up on.
+func (s *UnixSocket) SetFallback(w io.Writer) {
+	s.mu.Lock()
+	s.fallback = w
+	s.mu.Unlock()
+}
+
+// Write implements the io.Writer interface.
+func (s *UnixSocket) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed && !s.redial() {
+		return s.writeFallback(p)
+	}
+
+	s.buf = append(s.buf, p...)
+	s.send()
+
+	if s.failed {
+		b := s.buf
+		s.buf = nil
+		if _, err := s.writeFallback(b); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	s.trim()
+	return len(p), nil
+}
+
+// Close sends the lines that are kept, if it is connected, and closes the
+// connection.
+func (s *UnixSocket) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(unixSocketWriteTimeout))
+	_, err := s.conn.Write(s.buf)
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	s.conn = nil
+	s.buf = nil
+
+	return err
+}
+
+// send connects if required and it is time to try again, then sends the
+// lines that are kept. What can't be sent stays in the buffer.
+func (s *UnixSocket) send() {
+	if s.conn == nil {
+		if time.Now().Before(s.retryAt) {
+			return
+		}
+
+		if !s.connect() {
+			s.failures++
+			if s.retry.exhausted(s.failures) {
+				s.failed = true
+				s.retryAt = time.Now().Add(s.retry.MaxBackoff)
+				return
+			}
+
+			s.retryAt = time.Now().Add(s.retry.wait(s.failures))
+			return
+		}
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(unixSocketWriteTimeout))
+	n, err := s.conn.Write(s.buf)
+	rest := s.buf[n:]
+
+	// Connect again on the next write. The receiver can't put a line
+	// back together across connections, so the rest of a line that was
+	// partly sent is dropped.
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+
+		if n > 0 && s.buf[n-1] != '\n' {
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				rest = rest[i+1:]
+			} else {
+				rest = nil
+			}
+			s.drop(1)
+		}
+	}
+
+	s.buf = append(s.buf[:0], rest...)
+}
+
+// connect dials the socket and reports whether it connected. The line
+// saying how many lines were dropped goes in front of the kept ones.
+func (s *UnixSocket) connect() bool {
+	conn, err := net.DialTimeout("unix", s.path, unixSocketDialTimeout)
+	if err != nil {
+		return false
+	}
+
+	s.conn, s.failed, s.failures = conn, false, 0
+	if s.dropped > 0 {
+		s.buf = append([]byte(fmt.Sprintf("... (%d lines dropped while disconnected)\n", s.dropped)), s.buf...)
+		s.dropped = 0
+	}
+
+	return true
+}
+
+// redial tries to connect again once the socket has been given up on, at
+// most every MaxBackoff of the retry policy, and reports whether it did.
+func (s *UnixSocket) redial() bool {
+	if time.Now().Before(s.retryAt) {
+		return false
+	}
+
+	if !s.connect() {
+		s.retryAt = time.Now().Add(s.retry.MaxBackoff)
+		return false
+	}
+
+	return true
+}
+
+// drop counts lines that were dropped, for the line sent once it connects
+// again and for Dropped.
+func (s *UnixSocket) drop(n int) {
+	s.dropped += n
+	s.lost += n
+}
+
+// trim drops the oldest whole lines that don't fit in the buffer.
+func (s *UnixSocket) trim() {
+	over := len(s.buf) - unixSocketMaxBuffer
+	if over <= 0 {
+		return
+	}
+
+	cut := len(s.buf)
+	if i := bytes.IndexByte(s.buf[over-1:], '\n'); i >= 0 {
+		cut = over + i
+	}
+
+	s.drop(bytes.Count(s.buf[:cut], []byte{'\n'}))
+	s.buf = append(s.buf[:0], s.buf[cut:]...)
+}
+
+// writeFallback writes the lines to the fallback writer, with a line
+// saying how many were dropped before them. Without a fallback the lines
+// are dropped.
+func (s *UnixSocket) writeFallback(p []byte) (int, error) {
+	if s.fallback == nil {
+		s.drop(bytes.Count(p, []byte{'\n'}))
+		return 0, fmt.Errorf("UnixSocketWriter: %s: gave up after %d attempts to connect", s.path, s.retry.MaxAttempts)
+	}
+
+	if s.dropped > 0 {
+		fmt.Fprintf(s.fallback, "... (%d lines dropped while disconnected)\n", s.dropped)
+		s.dropped = 0
+	}
+
+	return s.fallback.Write(p)
+}
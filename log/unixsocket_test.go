@@ -0,0 +1,253 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketWriter(t *testing.T) {
+	t.Log("Given the need to send lines to a Unix socket that can go away.")
+	{
+		dir, err := os.MkdirTemp("", "socket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		defer func(b time.Duration, r, m int) {
+			unixSocketBackoff, unixSocketRetries, unixSocketMaxBuffer = b, r, m
+		}(unixSocketBackoff, unixSocketRetries, unixSocketMaxBuffer)
+		unixSocketBackoff, unixSocketRetries, unixSocketMaxBuffer = time.Millisecond, 3, 2
+
+		path := filepath.Join(dir, "socket")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := UnixSocketWriter(path)
+		io.WriteString(w, "a\n")
+
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 16)
+		if n, _ := conn.Read(b); string(b[:n]) == "a\n" {
+			t.Log("\tShould send the lines to the socket.", succeed)
+		} else {
+			t.Errorf("\tShould send the lines to the socket. %s %q", failed, b[:n])
+		}
+
+		// The router goes away. The lines are kept, the oldest dropped
+		// once they don't fit, until it is back.
+		conn.Close()
+		ln.Close()
+		io.WriteString(w, "b\n")
+		io.WriteString(w, "c\n")
+
+		ln, err = net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, "d\n")
+
+		conn, err = ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		got, _ := io.ReadAll(conn)
+		conn.Close()
+
+		if exp := "... (1 lines dropped while disconnected)\nc\nd\n"; string(got) == exp {
+			t.Log("\tShould send the kept lines once it connects again.", succeed)
+		} else {
+			t.Errorf("\tShould send the kept lines once it connects again. %s %q", failed, got)
+		}
+
+		// The socket is given up on after three attempts and the kept
+		// lines go to the fallback.
+		defer func(d time.Duration) { unixSocketMaxBackoff = d }(unixSocketMaxBackoff)
+		unixSocketMaxBackoff = time.Millisecond
+		w = UnixSocketWriter(filepath.Join(dir, "missing"))
+		unixSocketMaxBuffer = 1 << 20
+		for _, s := range []string{"x\n", "y\n"} {
+			if _, err := io.WriteString(w, s); err != nil {
+				t.Fatalf("\tShould keep the lines while it tries again. %s %v", failed, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		var buf bytes.Buffer
+		w.SetFallback(&buf)
+		io.WriteString(w, "z\n")
+		io.WriteString(w, "w\n")
+
+		if exp := "x\ny\nz\nw\n"; buf.String() == exp {
+			t.Log("\tShould write to the fallback once it gives up.", succeed)
+		} else {
+			t.Errorf("\tShould write to the fallback once it gives up. %s %q", failed, buf.String())
+		}
+
+		w.SetFallback(nil)
+		if _, err := io.WriteString(w, "v\n"); err != nil {
+			t.Log("\tShould return an error without a fallback.", succeed)
+		} else {
+			t.Errorf("\tShould return an error without a fallback. %s", failed)
+		}
+
+		// The router shows up after it was given up on.
+		ln2, err := net.Listen("unix", filepath.Join(dir, "missing"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln2.Close()
+		time.Sleep(5 * time.Millisecond)
+
+		if _, err := io.WriteString(w, "u\n"); err != nil {
+			t.Fatalf("\tShould connect again after giving up. %s %v", failed, err)
+		}
+		conn, err = ln2.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		got, _ = io.ReadAll(conn)
+		conn.Close()
+
+		// The line written without a fallback was dropped.
+		if exp := "... (1 lines dropped while disconnected)\nu\n"; string(got) == exp {
+			t.Log("\tShould connect again after giving up.", succeed)
+		} else {
+			t.Errorf("\tShould connect again after giving up. %s %q", failed, got)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Log("Given the need to try a network writer again without all of them coming back at once.")
+	{
+		p := RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+		ok := true
+		for i := 0; i < 100; i++ {
+			for n, d := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+				if w := p.wait(n); w < d/2 || w > d {
+					ok = false
+					t.Logf("\t\tattempt %d waited %v", n, w)
+				}
+			}
+		}
+		if ok {
+			t.Log("\tShould double the wait up to the maximum and take up to half off.", succeed)
+		} else {
+			t.Errorf("\tShould double the wait up to the maximum and take up to half off. %s", failed)
+		}
+
+		if !p.exhausted(2) && p.exhausted(3) && !(RetryPolicy{}).exhausted(100) {
+			t.Log("\tShould give up after the attempts, or never without a maximum.", succeed)
+		} else {
+			t.Errorf("\tShould give up after the attempts, or never without a maximum. %s", failed)
+		}
+
+		dir, err := os.MkdirTemp("", "socket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		w := UnixSocketWriter(filepath.Join(dir, "missing"))
+		w.SetRetryPolicy(RetryPolicy{MaxAttempts: 1, MaxBackoff: time.Hour})
+		_, err1 := io.WriteString(w, "a\nb\n")
+		_, err2 := io.WriteString(w, "c\n")
+		if err1 != nil && err2 != nil && w.Dropped() == 3 {
+			t.Log("\tShould drop and count the lines once the attempts run out.", succeed)
+		} else {
+			t.Errorf("\tShould drop and count the lines once the attempts run out. %s %v %v %d", failed, err1, err2, w.Dropped())
+		}
+	}
+}
+
+func TestUnixSocketWriteTimeout(t *testing.T) {
+	t.Log("Given the need to stop waiting on a Unix socket nobody reads.")
+	{
+		dir, err := os.MkdirTemp("", "socket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		defer func(d time.Duration, m int) {
+			unixSocketWriteTimeout, unixSocketMaxBuffer = d, m
+		}(unixSocketWriteTimeout, unixSocketMaxBuffer)
+		unixSocketWriteTimeout, unixSocketMaxBuffer = 50*time.Millisecond, 1<<24
+
+		path := filepath.Join(dir, "socket")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		// Far more than the socket buffers hold, in lines that tell
+		// each other apart.
+		var p []byte
+		for i := 0; len(p) < 1<<22; i++ {
+			p = append(p, fmt.Sprintf("line %07d %0990d\n", i, 0)...)
+		}
+
+		w := UnixSocketWriter(path)
+		done := make(chan struct{})
+		go func() {
+			w.Write(p)
+			close(done)
+		}()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		select {
+		case <-done:
+			t.Log("\tShould give up on a send that doesn't complete.", succeed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("\tShould give up on a send that doesn't complete.", failed)
+		}
+
+		w.mu.Lock()
+		kept := w.buf
+		w.mu.Unlock()
+		if bytes.HasPrefix(kept, []byte("line ")) && bytes.HasSuffix(kept, []byte("\n")) {
+			t.Log("\tShould keep whole lines only.", succeed)
+		} else {
+			t.Errorf("\tShould keep whole lines only. %s %d", failed, len(kept))
+		}
+	}
+}
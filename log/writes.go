@@ -0,0 +1,86 @@
+/**
+* Copyright 2016 Comcast Cable Communications Management, LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// written counts the lines written to the devices for WaitForWrites. The
+// changed channel is closed and replaced each time the count goes up.
+var written = struct {
+	sync.Mutex
+	n       int
+	changed chan struct{}
+}{changed: make(chan struct{})}
+
+// addWritten counts n lines that were written to a device.
+func addWritten(n int) {
+	if n == 0 {
+		return
+	}
+
+	written.Lock()
+	written.n += n
+	close(written.changed)
+	written.changed = make(chan struct{})
+	written.Unlock()
+}
+
+// resetWritten clears the count when logging is initialized.
+func resetWritten() {
+	written.Lock()
+	written.n = 0
+	written.Unlock()
+}
+
+// WaitForWrites is a test hook that blocks until n lines have been written
+// to their devices since Init or since the previous call returned, so a
+// test can read its buffer without sleeping past the bulk log period. The
+// lines waited for are taken from the count and the next call waits for
+// new ones. Each logging call is one line, however many physical lines it
+// has, and a line collapsed by SetCollapseConsecutive counts once its
+// repeat marker is written. An error is returned if the lines are not
+// written before the timeout.
+//
+//	log.Tracef("TEST", "foo", "hello")
+//	if err := log.WaitForWrites(1, time.Second); err != nil {
+//		t.Fatal(err)
+//	}
+func WaitForWrites(n int, timeout time.Duration) error {
+	limit := time.NewTimer(timeout)
+	defer limit.Stop()
+
+	for {
+		written.Lock()
+		got, changed := written.n, written.changed
+		if got >= n {
+			written.n -= n
+			written.Unlock()
+			return nil
+		}
+		written.Unlock()
+
+		select {
+		case <-changed:
+		case <-limit.C:
+			return fmt.Errorf("WaitForWrites: %d of %d lines written after %v", got, n, timeout)
+		}
+	}
+}